@@ -2,6 +2,7 @@ package appender
 
 import (
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -50,9 +51,13 @@ func (r *ColAppender) Append(values ...interface{}) {
 		case int64:
 			cell.SetInt64(x)
 		case float32:
-			cell.SetFloat(float64(x))
+			if !math.IsNaN(float64(x)) && !math.IsInf(float64(x), 0) {
+				cell.SetFloat(float64(x))
+			}
 		case float64:
-			cell.SetFloat(x)
+			if !math.IsNaN(x) && !math.IsInf(x, 0) {
+				cell.SetFloat(x)
+			}
 		case bool:
 			cell.SetBool(x)
 		case string:
@@ -109,9 +114,13 @@ func (r *RowAppender) Append(values ...interface{}) {
 		case int64:
 			cell.SetInt64(x)
 		case float32:
-			cell.SetFloat(float64(x))
+			if !math.IsNaN(float64(x)) && !math.IsInf(float64(x), 0) {
+				cell.SetFloat(float64(x))
+			}
 		case float64:
-			cell.SetFloat(x)
+			if !math.IsNaN(x) && !math.IsInf(x, 0) {
+				cell.SetFloat(x)
+			}
 		case bool:
 			cell.SetBool(x)
 		case string:
@@ -0,0 +1,183 @@
+package atf
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Field is a "Key=Value" header record.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Reader reads an Axon Text File.
+type Reader struct {
+	Version string
+	Header  []Field
+	Columns []string
+
+	r    *csv.Reader
+	line int
+}
+
+// NewReader reads the ATF preamble, header records and column titles from r.
+// Gzip compressed input is detected and decompressed.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+
+		r = zr
+	} else {
+		r = br
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comma = '\t'
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	version, headers, columns, err := readPreamble(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]Field, 0, headers)
+
+	for i := 0; i < headers; i++ {
+		record, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("could not read header record %d: %w", i+1, err)
+		}
+
+		header = append(header, parseField(record))
+	}
+
+	titles, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read column titles: %w", err)
+	}
+
+	if len(titles) != columns {
+		return nil, fmt.Errorf("expected %d column titles, got %d", columns, len(titles))
+	}
+
+	cols := make([]string, len(titles))
+	for i, title := range titles {
+		cols[i] = strings.TrimSpace(title)
+	}
+
+	return &Reader{
+		Version: version,
+		Header:  header,
+		Columns: cols,
+		r:       cr,
+		// the preamble, header records and column titles precede the data
+		line: headers + 3,
+	}, nil
+}
+
+// Read returns the next data record, reusing the slice between calls.
+func (r *Reader) Read() ([]string, error) {
+	r.line++
+
+	record, err := r.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("line %d: %w", r.line, err)
+	}
+
+	if len(record) != len(r.Columns) {
+		return nil, fmt.Errorf("line %d: expected %d fields, got %d", r.line, len(r.Columns), len(record))
+	}
+
+	return record, nil
+}
+
+// Line returns the line number of the last record read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Index maps each column title to its position.
+func Index(columns []string, required ...string) (map[string]int, error) {
+	idx := make(map[string]int, len(columns))
+
+	for i, col := range columns {
+		idx[col] = i
+	}
+
+	for _, name := range required {
+		if _, ok := idx[name]; !ok {
+			return nil, fmt.Errorf("missing required column %q", name)
+		}
+	}
+
+	return idx, nil
+}
+
+// Quote quotes a string value for writing.
+func Quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// readPreamble returns the version, header record count and column count.
+func readPreamble(r *csv.Reader) (string, int, int, error) {
+	line, err := r.Read()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("could not read ATF preamble: %w", err)
+	}
+
+	if len(line) < 2 || line[0] != "ATF" {
+		return "", 0, 0, errors.New("not an ATF file")
+	}
+
+	version := line[1]
+
+	line, err = r.Read()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("could not read ATF record counts: %w", err)
+	}
+
+	if len(line) < 2 {
+		return "", 0, 0, errors.New("invalid ATF record counts")
+	}
+
+	headers, err := strconv.Atoi(line[0])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid ATF header count: %w", err)
+	}
+
+	columns, err := strconv.Atoi(line[1])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid ATF column count: %w", err)
+	}
+
+	return version, headers, columns, nil
+}
+
+// parseField splits a "Key=Value" header record, rejoining unquoted tabs.
+func parseField(fields []string) Field {
+	record := strings.Join(fields, "\t")
+
+	i := strings.Index(record, "=")
+	if i < 0 {
+		return Field{Key: strings.TrimSpace(record)}
+	}
+
+	return Field{Key: strings.TrimSpace(record[:i]), Value: record[i+1:]}
+}
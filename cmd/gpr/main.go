@@ -1,110 +1,436 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/alecthomas/kong"
 	"github.com/tealeg/xlsx"
 
 	"gitlab.node-3.net/nadams/gpr/appender"
+	"gitlab.node-3.net/nadams/gpr/curve"
+	"gitlab.node-3.net/nadams/gpr/gal"
 	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/norm"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/spatial"
 )
 
+type CLI struct {
+	options.Input
+
+	Dir              string  `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" default:"."`
+	IncludeFlagged   bool    `name:"include-flagged" help:"Keep spots flagged Bad, Absent or Not Found by GenePix."`
+	Layout           string  `name:"gal" help:"GenePix Array List giving the IDs, names and annotations of every spot." type:"existingfile" optional:""`
+	Normalization    string  `name:"normalization" help:"Method normalizing intensities across the arrays (none, median, quantile, total, loess, controls)." enum:"none,median,quantile,total,loess,controls" default:"none"`
+	Statistic        string  `name:"statistic" help:"Statistic combining replicate spots (mean, median, trimmed)." enum:"mean,median,trimmed" default:"mean"`
+	Trim             float64 `name:"trim" help:"Fraction of replicates dropped from each end by the trimmed statistic." default:"0.2"`
+	Replicates       int     `name:"replicates" help:"Expected number of replicate spots per protein, 0 accepts any number." default:"2"`
+	Outliers         string  `name:"outliers" help:"Reject replicate outliers before summarising (none, mad, grubbs, fold)." enum:"none,mad,grubbs,fold" default:"none"`
+	OutlierThreshold float64 `name:"outlier-threshold" help:"Threshold of the outlier test, 0 uses its default."`
+	Curve            string  `name:"curve" help:"Standard curve converting intensities to concentrations (none, 4pl, 5pl), fitted and estimated from the background subtracted medians before normalization." enum:"none,4pl,5pl" default:"none"`
+	Concentration    string  `name:"concentration" help:"Layout annotation giving the concentration of standard spots." default:"Concentration"`
+	Standards        string  `name:"standards" help:"Case insensitive pattern matching the IDs of the positive control standards of each label, with {label} standing for its name." default:"^{label}"`
+	Negative         string  `name:"negative" help:"How negative background subtracted values are treated (raw, clamp, missing, offset)." enum:"raw,clamp,missing,offset" default:"clamp"`
+}
+
+type result struct {
+	name                string
+	data                *gpr.GPR
+	excluded            gpr.Exclusions
+	factors             []options.Setting
+	curves              map[int]curve.Curve
+	curveErrs           map[int]error
+	unnormalized        *gpr.GPR
+	unnormalizedSummary *gpr.GPR
+	summary             *gpr.GPR
+	unexpected          []gpr.ReplicateCount
+	rejections          []gpr.Rejection
+	layout              *gal.GAL
+	mismatches          []gal.Mismatch
+	settings            []options.Setting
+}
+
 func main() {
-	d, err := os.Getwd()
-	if err != nil {
-		panic(err)
+	var cli CLI
+	ctx := kong.Parse(&cli)
+
+	ctx.FatalIfErrorf(ctx.Validate())
+
+	input, err := cli.Input.Parse()
+	ctx.FatalIfErrorf(err)
+
+	var filters []gpr.Filter
+	if !cli.IncludeFlagged {
+		filters = append(filters, gpr.ExcludeFlagged)
 	}
 
-	fi, err := ioutil.ReadDir(d)
-	if err != nil {
-		panic(err)
+	negative, err := gpr.ParseNegativePolicy(cli.Negative, filters...)
+	ctx.FatalIfErrorf(err)
+
+	statistic, err := gpr.ParseStatistic(cli.Statistic)
+	ctx.FatalIfErrorf(err)
+
+	summariser := gpr.Summariser{Statistic: statistic, Trim: cli.Trim, Replicates: cli.Replicates}
+
+	method, err := norm.Parse(cli.Normalization)
+	ctx.FatalIfErrorf(err)
+
+	var model curve.Model
+	if cli.Curve != "none" {
+		model, err = curve.ParseModel(cli.Curve)
+		ctx.FatalIfErrorf(err)
+	}
+
+	var outliers gpr.OutlierTest
+	outliersName := "none"
+
+	if cli.Outliers != "none" {
+		outliers, err = gpr.ParseOutlierTest(cli.Outliers, cli.OutlierThreshold)
+		ctx.FatalIfErrorf(err)
+
+		outliersName = outliers.String()
+	}
+
+	var layout *gal.GAL
+	if cli.Layout != "" {
+		layout, err = gal.Read(cli.Layout)
+		ctx.FatalIfErrorf(err)
 	}
 
+	fi, err := ioutil.ReadDir(cli.Dir)
+	ctx.FatalIfErrorf(err)
+
+	var results []*result
+
 	for _, f := range fi {
 		if !strings.HasSuffix(f.Name(), ".gpr") {
 			continue
 		}
 
-		res, err := gpr.Read(filepath.Join(d, f.Name()))
+		data, err := gpr.Read(filepath.Join(cli.Dir, f.Name()), gpr.WithBackground(input.Background), gpr.WithNegativePolicy(negative), gpr.WithRules(input.Rules))
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
-		if err := outputDoc(f.Name()+".xlsx", res); err != nil {
+		res := &result{name: f.Name(), layout: layout}
+
+		if layout != nil {
+			data, res.mismatches = gal.Join(data, layout)
+			data.Classify(input.Rules)
+
+			if len(res.mismatches) > 0 {
+				log.Printf("%s: %d spots do not match the layout (%v)", f.Name(), len(res.mismatches), gal.Summary(res.mismatches))
+			}
+		}
+
+		res.data, res.excluded = data.Filter(filters...)
+		res.data = spatial.Correct(res.data, input.Spatial)
+		results = append(results, res)
+	}
+
+	arrays := make([]*gpr.GPR, len(results))
+	for i, res := range results {
+		arrays[i] = res.data
+
+		// curves are fitted on the scale of the standards before
+		// normalization
+		if model != 0 {
+			res.unnormalized = res.data
+			res.curves, res.curveErrs = curve.FitLabels(res.data, input.Labels, cli.Concentration, cli.Standards, model)
+			for w, err := range res.curveErrs {
+				log.Printf("%s: no %d standard curve: %v", res.name, w, err)
+			}
+		}
+	}
+
+	arrays, factors := norm.Normalize(arrays, method)
+	for i, res := range results {
+		res.data = arrays[i]
+	}
+
+	for _, f := range factors {
+		res := results[f.Array]
+		res.factors = append(res.factors, options.Setting{Name: fmt.Sprintf("Scale %d", f.Wavelength), Value: f.Scale})
+
+		if f.Failed != "" {
+			log.Printf("%s: %d channel failed normalization QC: %s", res.name, f.Wavelength, f.Failed)
+			res.factors = append(res.factors, options.Setting{Name: fmt.Sprintf("QC %d", f.Wavelength), Value: f.Failed})
+		}
+	}
+
+	for _, res := range results {
+		// controls are kept until normalized and fitted as some methods
+		// depend on them
+		data, ex := res.data.Filter(gpr.SamplesOnly)
+		res.data = data
+		res.excluded.Add(ex)
+
+		if res.excluded.Total() > 0 {
+			log.Printf("%s: excluded %d spots (%s)", res.name, res.excluded.Total(), res.excluded)
+		}
+
+		if outliers != nil {
+			res.data, res.rejections = res.data.RejectOutliers(outliers)
+			if len(res.rejections) > 0 {
+				log.Printf("%s: rejected %d replicate outliers", res.name, len(res.rejections))
+			}
+		}
+
+		res.summary, res.unexpected = summariser.Summarise(res.data)
+		if len(res.unexpected) > 0 {
+			log.Printf("%s: %d proteins do not have %d replicates", res.name, len(res.unexpected), cli.Replicates)
+		}
+
+		if len(res.curves) > 0 {
+			res.unnormalized = unnormalize(res.data, res.unnormalized)
+			res.unnormalizedSummary, _ = summariser.Summarise(res.unnormalized)
+		}
+
+		res.settings = []options.Setting{
+			{Name: "Background", Value: input.Background.String()},
+			{Name: "Spatial correction", Value: input.Spatial.String()},
+			{Name: "Negative values", Value: negative.String()},
+			{Name: "Rules", Value: cli.Rules},
+			{Name: "Layout", Value: cli.Layout},
+			{Name: "Layout mismatches", Value: len(res.mismatches)},
+			{Name: "Include flagged", Value: cli.IncludeFlagged},
+			{Name: "Normalization", Value: method.String()},
+			{Name: "Standard curve", Value: cli.Curve},
+			{Name: "Standards", Value: cli.Standards},
+		}
+		res.settings = append(res.settings, res.factors...)
+		res.settings = append(res.settings, []options.Setting{
+			{Name: "Statistic", Value: summariser.String()},
+			{Name: "Expected replicates", Value: cli.Replicates},
+			{Name: "Unexpected replicate counts", Value: len(res.unexpected)},
+			{Name: "Outliers", Value: outliersName},
+			{Name: "Outliers rejected", Value: len(res.rejections)},
+			{Name: "Spots excluded", Value: res.excluded.Total()},
+			{Name: "Exclusions", Value: res.excluded.String()},
+		}...)
+
+		if err := outputDoc(filepath.Join(cli.Dir, res.name+".xlsx"), res); err != nil {
 			log.Println(err)
 			continue
 		}
 	}
 }
 
-func outputDoc(path string, doc *gpr.GPR) error {
-	sheets := []string{
-		"Raw Data",
-		"F650Mean-B650",
-		"F650Medium-B650_SNR650",
-		"F550Mean-B550",
-		"F550Medium-B550_SNR550",
+// unnormalize returns a copy of g with the channels of each spot taken from
+// the same position in raw.
+func unnormalize(g, raw *gpr.GPR) *gpr.GPR {
+	byPosition := make(map[string]gpr.Row, len(raw.Rows))
+	for _, row := range raw.Rows {
+		byPosition[gpr.ByPosition.Of(row)] = row
 	}
 
+	out := g.Clone()
+	for i, row := range out.Rows {
+		if r, ok := byPosition[gpr.ByPosition.Of(row)]; ok {
+			out.Rows[i].Channels = append([]gpr.Channel(nil), r.Channels...)
+		}
+	}
+
+	return out
+}
+
+func outputDoc(path string, res *result) error {
 	spreadsheet := xlsx.NewFile()
-	avg := doc.Averaged()
+	doc := res.data
+	avg := res.summary
+
+	var annotations []string
+	if res.layout != nil {
+		annotations = res.layout.Annotations
+	}
+
+	var fitted []int
+	for _, w := range doc.Wavelengths {
+		if _, ok := res.curves[w]; ok {
+			fitted = append(fitted, w)
+		}
+	}
+
+	// concentrations are estimated from the intensities before
+	// normalization
+	spots := map[string]gpr.Row{}
+	proteins := map[string]gpr.Row{}
 
-	for i, name := range sheets {
-		sheet, err := spreadsheet.AddSheet(name)
+	if len(fitted) > 0 {
+		for _, row := range res.unnormalized.Rows {
+			spots[gpr.ByPosition.Of(row)] = row
+		}
+
+		for _, row := range res.unnormalizedSummary.Rows {
+			proteins[row.ID] = row
+		}
+	}
+
+	sheet, err := spreadsheet.AddSheet("Raw Data")
+	if err != nil {
+		return err
+	}
+
+	apndr := appender.NewRowAppender(sheet)
+
+	apndr.Append("ID")
+	for _, w := range doc.Wavelengths {
+		apndr.Append(fmt.Sprintf("F%d Median - B%d", w, w))
+	}
+	for _, w := range doc.Wavelengths {
+		apndr.Append(fmt.Sprintf("F%d Mean - B%d", w, w))
+	}
+	for _, w := range doc.Wavelengths {
+		apndr.Append(fmt.Sprintf("SNR %d", w))
+	}
+	for _, w := range fitted {
+		apndr.Append(fmt.Sprintf("Conc. %d", w), fmt.Sprintf("LOQ %d", w))
+	}
+	for _, a := range annotations {
+		apndr.Append(a)
+	}
+	apndr.NewRow()
+
+	for _, row := range doc.SortByID().Rows {
+		apndr.Append(row.ID)
+		for _, w := range doc.Wavelengths {
+			apndr.Append(row.Channel(w).MedianMinusBackground)
+		}
+		for _, w := range doc.Wavelengths {
+			apndr.Append(row.Channel(w).MeanMinusBackground)
+		}
+		for _, w := range doc.Wavelengths {
+			apndr.Append(row.Channel(w).SNR)
+		}
+		for _, w := range fitted {
+			conc, note := res.curves[w].Estimate(spots[gpr.ByPosition.Of(row)].Channel(w).MedianMinusBackground)
+			apndr.Append(conc, note)
+		}
+		for _, a := range annotations {
+			apndr.Append(row.Annotations[a])
+		}
+		apndr.NewRow()
+	}
+
+	for _, w := range doc.Wavelengths {
+		sheet, err := spreadsheet.AddSheet(fmt.Sprintf("F%dMean-B%d", w, w))
 		if err != nil {
 			return err
 		}
 
-		appender := appender.NewRowAppender(sheet)
+		apndr := appender.NewRowAppender(sheet)
+		apndr.Append("ID", fmt.Sprintf("F%d Mean - B%d", w, w), "n", "SD", "CV")
+		apndr.NewRow()
 
-		switch i {
-		case 0:
-			appender.Append("ID", "F650 Median - B650", "F550 Median - B550", "F650 Mean - B650", "F550 Mean - B550", "SNR 650", "SNR 550")
-			appender.NewRow()
+		for _, row := range avg.SortByMean(w).Rows {
+			c := row.Channel(w)
+			apndr.Append(row.ID, c.MeanMinusBackground, row.Replicates, c.MeanSpread.SD, c.MeanSpread.CV)
+			apndr.NewRow()
+		}
 
-			for _, row := range doc.SortByID().Rows {
-				appender.Append(row.ID, row.F650MedianB650, row.F550MedianB550, row.F650MeanB650, row.F550MeanB550, row.SNR650, row.SNR550)
-				appender.NewRow()
-			}
-		case 1:
-			appender.Append("ID", "F650 Mean - B650")
-			appender.NewRow()
+		sheet, err = spreadsheet.AddSheet(fmt.Sprintf("F%dMedium-B%d_SNR%d", w, w, w))
+		if err != nil {
+			return err
+		}
 
-			for _, row := range avg.SortBy650Mean().Rows {
-				appender.Append(row.ID, row.F650MeanB650)
-				appender.NewRow()
-			}
-		case 2:
-			appender.Append("ID", "F650 Medium - B650", "SNR 650")
-			appender.NewRow()
+		apndr = appender.NewRowAppender(sheet)
+		apndr.Append("ID", fmt.Sprintf("F%d Medium - B%d", w, w), fmt.Sprintf("SNR %d", w), "n", "SD", "CV")
+		c, fit := res.curves[w]
+		if fit {
+			apndr.Append(fmt.Sprintf("Conc. %d", w), fmt.Sprintf("LOQ %d", w))
+		}
+		apndr.NewRow()
 
-			for _, row := range avg.SortBy650Median().Rows {
-				appender.Append(row.ID, row.F650MedianB650, row.SNR650)
-				appender.NewRow()
+		for _, row := range avg.SortByMedian(w).Rows {
+			ch := row.Channel(w)
+			apndr.Append(row.ID, ch.MedianMinusBackground, ch.SNR, row.Replicates, ch.MedianSpread.SD, ch.MedianSpread.CV)
+			if fit {
+				conc, note := c.Estimate(proteins[row.ID].Channel(w).MedianMinusBackground)
+				apndr.Append(conc, note)
 			}
-		case 3:
-			appender.Append("ID", "F550 Mean - B550")
-			appender.NewRow()
+			apndr.NewRow()
+		}
+	}
 
-			for _, row := range avg.SortBy550Mean().Rows {
-				appender.Append(row.ID, row.F550MeanB550)
-				appender.NewRow()
+	if len(res.curves)+len(res.curveErrs) > 0 {
+		sheet, err := spreadsheet.AddSheet("Standard Curves")
+		if err != nil {
+			return err
+		}
+
+		apndr := appender.NewRowAppender(sheet)
+		apndr.Append("Wavelength", "Model", "A", "B", "C", "D", "G", "LLOQ", "ULOQ", "Error")
+		apndr.NewRow()
+
+		for _, w := range doc.Wavelengths {
+			if c, ok := res.curves[w]; ok {
+				apndr.Append(w, c.Model.String(), c.A, c.B, c.C, c.D, c.G, c.LLOQ, c.ULOQ, "")
+				apndr.NewRow()
 			}
-		case 4:
-			appender.Append("ID", "F550 Medium - B550", "SNR 550")
-			appender.NewRow()
 
-			for _, row := range avg.SortBy550Median().Rows {
-				appender.Append(row.ID, row.F550MedianB550, row.SNR550)
-				appender.NewRow()
+			if err, ok := res.curveErrs[w]; ok {
+				apndr.Append(w, "", "", "", "", "", "", "", "", err.Error())
+				apndr.NewRow()
 			}
 		}
 	}
 
+	if len(res.unexpected) > 0 {
+		sheet, err := spreadsheet.AddSheet("Replicates")
+		if err != nil {
+			return err
+		}
+
+		apndr := appender.NewRowAppender(sheet)
+		apndr.Append("ID", "Replicates", "Expected")
+		apndr.NewRow()
+
+		for _, r := range res.unexpected {
+			apndr.Append(r.ID, r.Count, r.Expected)
+			apndr.NewRow()
+		}
+	}
+
+	if len(res.rejections) > 0 {
+		sheet, err := spreadsheet.AddSheet("Rejected Spots")
+		if err != nil {
+			return err
+		}
+
+		apndr := appender.NewRowAppender(sheet)
+		apndr.Append("ID", "Block", "Column", "Row", "Wavelength", "F Median - B", "Reason")
+		apndr.NewRow()
+
+		for _, r := range res.rejections {
+			apndr.Append(r.ID, r.Block, r.Column, r.Row, r.Wavelength, r.Value, r.Reason)
+			apndr.NewRow()
+		}
+	}
+
+	if len(res.mismatches) > 0 {
+		sheet, err := spreadsheet.AddSheet("Layout Mismatches")
+		if err != nil {
+			return err
+		}
+
+		apndr := appender.NewRowAppender(sheet)
+		apndr.Append("Block", "Column", "Row", "Mismatch", "Results", "Layout")
+		apndr.NewRow()
+
+		for _, m := range res.mismatches {
+			apndr.Append(m.Block, m.Column, m.Row, m.Kind.String(), m.Results, m.Layout)
+			apndr.NewRow()
+		}
+	}
+
+	if err := options.AddSettings(spreadsheet, res.settings); err != nil {
+		return err
+	}
+
 	return spreadsheet.Save(path)
 }
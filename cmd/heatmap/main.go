@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/heatmap"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+)
+
+type CLI struct {
+	options.Input
+
+	Dir    string `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" default:"."`
+	Output string `name:"output" short:"o" help:"Directory the images are written to, the gpr directory by default." type:"existingdir" optional:""`
+	Metric string `name:"metric" help:"Spot metric coloured (median, mean, snr, background, flags)." enum:"median,mean,snr,background,flags" default:"median"`
+	Scale  string `name:"scale" help:"Colour scale (viridis, heat, grey, diverging)." enum:"viridis,heat,grey,diverging" default:"viridis"`
+	Log    bool   `name:"log" help:"Colour the log10 of the metric."`
+	Min    string `name:"min" help:"Value at the bottom of the colour scale, the 1st percentile if not set." optional:""`
+	Max    string `name:"max" help:"Value at the top of the colour scale, the 99th percentile if not set." optional:""`
+	Spot   int    `name:"spot" help:"Size of each spot in pixels." default:"12"`
+}
+
+func main() {
+	var cli CLI
+	ctx := kong.Parse(&cli)
+
+	ctx.FatalIfErrorf(ctx.Validate())
+	ctx.FatalIfErrorf(work(&cli))
+}
+
+func work(cli *CLI) error {
+	input, err := cli.Input.Parse()
+	if err != nil {
+		return err
+	}
+
+	labels := input.Labels
+
+	// flags are the same at every wavelength
+	if cli.Metric == "flags" {
+		labels = labels[:1]
+	}
+
+	scale, err := heatmap.ParseScale(cli.Scale)
+	if err != nil {
+		return err
+	}
+
+	min, err := limit("min", cli.Min)
+	if err != nil {
+		return err
+	}
+
+	max, err := limit("max", cli.Max)
+	if err != nil {
+		return err
+	}
+
+	if cli.Output == "" {
+		cli.Output = cli.Dir
+	}
+
+	fis, err := ioutil.ReadDir(cli.Dir)
+	if err != nil {
+		return err
+	}
+
+	var n int
+
+	for _, fi := range fis {
+		if strings.ToLower(filepath.Ext(fi.Name())) != ".gpr" {
+			continue
+		}
+
+		// every spot is drawn, flagged spots are part of the picture
+		g, err := gpr.Read(filepath.Join(cli.Dir, fi.Name()), gpr.WithBackground(input.Background), gpr.WithRules(input.Rules))
+		if err != nil {
+			return err
+		}
+
+		g = spatial.Correct(g, input.Spatial)
+		base := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+
+		for _, l := range labels {
+			metric, err := heatmap.ParseMetric(cli.Metric, l.Wavelength)
+			if err != nil {
+				return err
+			}
+
+			opts := heatmap.Options{
+				Metric: metric,
+				Scale:  scale,
+				Log:    cli.Log,
+				Min:    min,
+				Max:    max,
+				Spot:   cli.Spot,
+				Title:  fmt.Sprintf("%s %s %s", base, l.Name, metric),
+			}
+
+			name := fmt.Sprintf("%s-%s-%s.png", base, l.Name, cli.Metric)
+			if cli.Metric == "flags" {
+				opts.Title = fmt.Sprintf("%s %s", base, metric)
+				name = fmt.Sprintf("%s-%s.png", base, cli.Metric)
+			}
+
+			if err := write(filepath.Join(cli.Output, name), g, opts); err != nil {
+				return fmt.Errorf("%s: %w", fi.Name(), err)
+			}
+
+			n++
+		}
+	}
+
+	if n == 0 {
+		return fmt.Errorf("no gpr files in %s", cli.Dir)
+	}
+
+	return nil
+}
+
+// limit parses a colour scale limit, nil if not set.
+func limit(flag, spec string) (*float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	v, err := strconv.ParseFloat(spec, 64)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", flag, err)
+	}
+
+	return &v, nil
+}
+
+func write(path string, g *gpr.GPR, opts heatmap.Options) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := heatmap.WritePNG(f, g, opts); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -2,95 +2,106 @@ package main
 
 import (
 	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/alecthomas/kong"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/tealeg/xlsx"
 
 	"gitlab.node-3.net/nadams/gpr/appender"
+	"gitlab.node-3.net/nadams/gpr/experiment"
+	"gitlab.node-3.net/nadams/gpr/gal"
 	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/hits"
+	"gitlab.node-3.net/nadams/gpr/norm"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/plot"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+	"gitlab.node-3.net/nadams/gpr/stats"
 )
 
-type wvtype int
-
-const (
-	IgG wvtype = iota + 1
-	IgM
-)
+type rejection struct {
+	file string
+	gpr.Rejection
+}
 
-func (w wvtype) String() string {
-	switch w {
-	case IgG:
-		return "IgG"
-	case IgM:
-		return "IgM"
-	default:
-		return ""
-	}
+// misalignment records the rows of two arrays that could not be matched.
+type misalignment struct {
+	sheet string
+	left  string
+	right string
+	join  *gpr.Join
 }
 
-type samplegroup int
+func (m misalignment) String() string {
+	var b strings.Builder
 
-const (
-	SHRA3 samplegroup = iota + 1
-	SHRB2
-	SHRA3CHR6B2
-	IgHKO
-	Normal
-)
+	fmt.Fprintf(&b, "%s: %s and %s are not aligned (%s)", m.sheet, m.left, m.right, m.join)
 
-func (s samplegroup) String() string {
-	switch s {
-	case SHRA3:
-		return "A340W-18W_Hits1"
-	case SHRB2:
-		return "B240W-18W_Hits2"
-	case SHRA3CHR6B2:
-		return "Chr6B240W-18W_Hits3"
-	case IgHKO:
-		return "IgHKO_Hits4"
-	case Normal:
-		return "Normal_Hits5"
-	default:
-		return ""
+	for _, r := range m.join.Left {
+		fmt.Fprintf(&b, "\n\t%s only in %s", r.ID, m.left)
 	}
+
+	for _, r := range m.join.Right {
+		fmt.Fprintf(&b, "\n\t%s only in %s", r.ID, m.right)
+	}
+
+	for _, p := range differing(m.join) {
+		fmt.Fprintf(&b, "\n\tblock %d column %d row %d is %s in %s and %s in %s", p.Left.Block, p.Left.Column, p.Left.Row, p.Left.ID, m.left, p.Right.ID, m.right)
+	}
+
+	return b.String()
 }
 
-type gprmap struct {
-	left  string
-	right string
-	group samplegroup
-}
-
-var (
-	files = []gprmap{
-		{left: "1", right: "13", group: SHRA3},
-		{left: "2", right: "14", group: SHRA3},
-		{left: "3", right: "15", group: SHRA3},
-		{left: "4", right: "16", group: SHRA3},
-		{left: "5", right: "17", group: SHRA3},
-		{left: "6", right: "18", group: SHRA3},
-		{left: "7", right: "19", group: SHRB2},
-		{left: "8", right: "20", group: SHRB2},
-		{left: "9", right: "21", group: SHRB2},
-		{left: "10", right: "22", group: SHRB2},
-		{left: "11", right: "23", group: SHRB2},
-		{left: "12", right: "24", group: SHRB2},
-		{left: "25", right: "31", group: SHRA3CHR6B2},
-		{left: "26", right: "32", group: SHRA3CHR6B2},
-		{left: "27", right: "33", group: SHRA3CHR6B2},
-		{left: "28", right: "34", group: SHRA3CHR6B2},
-		{left: "29", right: "35", group: SHRA3CHR6B2},
-		{left: "30", right: "36", group: SHRA3CHR6B2},
-		{left: "55", group: IgHKO},
-		{left: "56", group: Normal},
+// differing returns the spots matched by position that hold different
+// proteins.
+func differing(j *gpr.Join) []gpr.Pair {
+	var ps []gpr.Pair
+	for _, p := range j.Inner {
+		if p.Left.ID != p.Right.ID {
+			ps = append(ps, p)
+		}
 	}
-)
+
+	return ps
+}
 
 type CLI struct {
-	Dir string `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" optional:""`
+	options.Input
+
+	Dir              string   `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" optional:""`
+	Samples          string   `name:"samples" help:"Sample sheet describing the arrays, pairs and groups, samples.csv in the directory by default." type:"existingfile" optional:""`
+	Template         bool     `name:"template" help:"Print a sample sheet listing the gpr files in the directory and exit."`
+	IncludeFlagged   bool     `name:"include-flagged" help:"Keep spots flagged Bad, Absent or Not Found by GenePix."`
+	Layout           string   `name:"gal" help:"GenePix Array List giving the IDs, names and annotations of every spot." type:"existingfile" optional:""`
+	Normalization    string   `name:"normalization" help:"Method normalizing intensities across the arrays (none, median, quantile, total, loess, controls)." enum:"none,median,quantile,total,loess,controls" default:"none"`
+	Statistic        string   `name:"statistic" help:"Statistic combining replicate spots (mean, median, trimmed)." enum:"mean,median,trimmed" default:"mean"`
+	Trim             float64  `name:"trim" help:"Fraction of replicates dropped from each end by the trimmed statistic." default:"0.2"`
+	Replicates       int      `name:"replicates" help:"Expected number of replicate spots per protein, 0 accepts any number." default:"2"`
+	Outliers         string   `name:"outliers" help:"Reject replicate outliers before summarising (none, mad, grubbs, fold)." enum:"none,mad,grubbs,fold" default:"none"`
+	OutlierThreshold float64  `name:"outlier-threshold" help:"Threshold of the outlier test, 0 uses its default."`
+	Align            string   `name:"align" help:"How arrays are checked to hold the same spots (id, position), by position every spot is compared before replicates are summarised." enum:"id,position" default:"id"`
+	Test             string   `name:"test" help:"Test comparing groups (welch, mannwhitney)." enum:"welch,mannwhitney" default:"welch"`
+	PairedTest       string   `name:"paired-test" help:"Test comparing arrays with their pairs (t, wilcoxon)." enum:"t,wilcoxon" default:"t"`
+	Compare          []string `name:"compare" help:"Groups compared, as GROUP:GROUP, every pair of groups by default."`
+	HitSD            float64  `name:"hit-sd" help:"Standard deviations above the mean of the control spots a hit must exceed." default:"3"`
+	HitControls      []string `name:"hit-controls" help:"Control spots the hit cutoff of each array is derived from, such as negative or buffer, any signal above background by default." optional:""`
+	HitSNR           float64  `name:"hit-snr" help:"Minimum SNR of a hit, 0 disables."`
+	HitFold          float64  `name:"hit-fold" help:"Minimum fold over the mean of the reference group of a hit, 0 disables."`
+	HitReference     string   `name:"hit-reference" help:"Group the fold of a hit is computed against."`
+	HitAgreement     float64  `name:"hit-agreement" help:"Minimum fraction of replicate spots above the cutoff of a hit, 0 disables."`
+	Negative         string   `name:"negative" help:"How negative background subtracted values are treated (raw, clamp, missing, offset)." enum:"raw,clamp,missing,offset" default:"clamp"`
+	Plots            bool     `name:"plots" help:"Write a volcano plot of every comparison and a bar plot of the top proteins of every group next to the results."`
+	PlotTop          int      `name:"plot-top" help:"Number of proteins named in volcano plots and ranked in bar plots." default:"10"`
+	PlotQ            float64  `name:"plot-q" help:"Largest q value drawn as significant in volcano plots." default:"0.05"`
+	PlotFold         float64  `name:"plot-fold" help:"Smallest absolute log2 fold change drawn as significant in volcano plots." default:"1"`
 }
 
 func main() {
@@ -103,157 +114,396 @@ func main() {
 		cli.Dir = "."
 	}
 
+	if cli.Template {
+		ctx.FatalIfErrorf(template(cli.Dir))
+		return
+	}
+
 	ctx.FatalIfErrorf(work(&cli))
 }
 
+// template prints a sample sheet for the gpr files in dir.
+func template(dir string) error {
+	s, err := experiment.Template(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(s.Samples) == 0 {
+		return fmt.Errorf("no gpr files in %s", dir)
+	}
+
+	return s.Write(os.Stdout)
+}
+
 func work(cli *CLI) error {
-	// IgG -> 550
-	// IgM -> 650
+	if cli.Samples == "" {
+		cli.Samples = filepath.Join(cli.Dir, "samples.csv")
+	}
+
+	samples, err := experiment.ReadSampleSheet(cli.Samples)
+	if err != nil {
+		return err
+	}
+
+	input, err := cli.Input.Parse()
+	if err != nil {
+		return err
+	}
+
+	var filters []gpr.Filter
+	if !cli.IncludeFlagged {
+		filters = append(filters, gpr.ExcludeFlagged)
+	}
+
+	negative, err := gpr.ParseNegativePolicy(cli.Negative, filters...)
+	if err != nil {
+		return err
+	}
 
-	for _, wv := range []wvtype{IgG, IgM} {
+	key, err := gpr.ParseKey(cli.Align)
+	if err != nil {
+		return err
+	}
+
+	statistic, err := gpr.ParseStatistic(cli.Statistic)
+	if err != nil {
+		return err
+	}
+
+	summariser := gpr.Summariser{Statistic: statistic, Trim: cli.Trim, Replicates: cli.Replicates}
+
+	method, err := norm.Parse(cli.Normalization)
+	if err != nil {
+		return err
+	}
+
+	var outliers gpr.OutlierTest
+	outliersName := "none"
+
+	if cli.Outliers != "none" {
+		outliers, err = gpr.ParseOutlierTest(cli.Outliers, cli.OutlierThreshold)
+		if err != nil {
+			return err
+		}
+
+		outliersName = outliers.String()
+	}
+
+	var layout *gal.GAL
+	if cli.Layout != "" {
+		layout, err = gal.Read(cli.Layout)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts := []gpr.Option{gpr.WithBackground(input.Background), gpr.WithNegativePolicy(negative), gpr.WithRules(input.Rules)}
+
+	var exp experiment.Experiment
+
+	// every sample spot of each array, flagged or not, is kept to check the
+	// arrays are aligned
+	printed := map[string]*gpr.GPR{}
+
+	for _, sample := range samples.Samples {
+		path, err := sample.Path(cli.Dir)
+		if err != nil {
+			return err
+		}
+
+		g, all, err := load(path, opts, layout, input.Rules, filters, input.Spatial)
+		if err != nil {
+			return err
+		}
+
+		printed[sample.Array] = all
+
+		exp.Add(sample.Array, g, sample.Metadata(filepath.Base(path)))
+	}
+
+	groups, err := comparedGroups(cli.Compare, exp.Groups())
+	if err != nil {
+		return err
+	}
+
+	unpaired, paired := tests[cli.Test], tests[cli.PairedTest]
+
+	thresholds := hits.Thresholds{
+		SD:        cli.HitSD,
+		MinSNR:    cli.HitSNR,
+		MinFold:   cli.HitFold,
+		Reference: cli.HitReference,
+		Agreement: cli.HitAgreement,
+	}
+
+	for _, name := range cli.HitControls {
+		if name == "" {
+			continue
+		}
+
+		c, err := gpr.ParseControl(name)
+		if err != nil {
+			return err
+		}
+
+		thresholds.Controls = append(thresholds.Controls, c)
+	}
+
+	if thresholds.MinFold > 0 && thresholds.Reference == "" {
+		return fmt.Errorf("--hit-fold needs a --hit-reference group")
+	}
+
+	if err := checkControls(&exp, thresholds.Controls); err != nil {
+		return err
+	}
+
+	normalized, factors := norm.Normalize(exp.Data(), method)
+
+	// every spot, including the controls, is kept for the hit cutoffs
+	spots := exp.Map(func(g *gpr.GPR) *gpr.GPR { return g })
+
+	for i := range exp.Arrays {
+		// controls are kept until normalized as some methods depend on them
+		a := &exp.Arrays[i]
+		spots.Arrays[i].Data = normalized[i]
+
+		var ex gpr.Exclusions
+
+		a.Data, ex = normalized[i].Filter(gpr.SamplesOnly)
+		if ex.Total() > 0 {
+			log.Printf("%s: excluded %d control spots (%s)", a.Meta.Slide, ex.Total(), ex)
+		}
+	}
+
+	for _, f := range factors {
+		if f.Failed != "" {
+			log.Printf("%s: %d channel failed normalization QC: %s", exp.Arrays[f.Array].Meta.Slide, f.Wavelength, f.Failed)
+		}
+	}
+
+	for _, label := range input.Labels {
+		w := label.Wavelength
 		spreadsheet := xlsx.NewFile()
-		sheets := map[samplegroup]*xlsx.Sheet{}
-		appenders := map[samplegroup]*appender.ColAppender{}
+		sheets := map[string]*xlsx.Sheet{}
+		appenders := map[string]*appender.ColAppender{}
+		refs := map[string]string{}
+		references := map[string]*gpr.GPR{}
+		referenceIDs := map[string][]string{}
+		var rejections []rejection
+		var misaligned []misalignment
+		var subjects []subject
+		var summarised experiment.Experiment
 
-		for _, m := range files {
+		for _, m := range samples.Comparisons() {
 			var newSheet bool
 
-			sheet, ok := sheets[m.group]
+			sheet, ok := sheets[m.Sheet]
 			if !ok {
-				s, err := spreadsheet.AddSheet(m.group.String())
+				s, err := spreadsheet.AddSheet(m.Sheet)
 				if err != nil {
 					return fmt.Errorf("%s - %w", spew.Sdump(m), err)
 				}
 
 				sheet = s
 				newSheet = true
-				sheets[m.group] = sheet
+				sheets[m.Sheet] = sheet
 			}
 
-			apndr, ok := appenders[m.group]
+			apndr, ok := appenders[m.Sheet]
 			if !ok {
 				apndr = appender.NewColAppender(sheet)
-				appenders[m.group] = apndr
+				appenders[m.Sheet] = apndr
 			}
 
-			left, err := match(cli.Dir, m.left)
+			// report records the arrays of a comparison that do not match
+			report := func(left, right string) func(*gpr.Join) {
+				return func(j *gpr.Join) {
+					misaligned = append(misaligned, misalignment{sheet: m.Sheet, left: left, right: right, join: j})
+				}
+			}
+
+			left, _ := exp.Get(m.Left)
+			leftGPR, rejected, err := prepare(left, summariser, outliers, label)
 			if err != nil {
 				return err
 			}
 
-			var leftGPR, rightGPR *gpr.GPR
+			rejections = append(rejections, rejected...)
+			summarised.Add(left.Name, leftGPR, left.Meta)
 
-			leftGPR, err = gpr.Read(left)
+			if newSheet {
+				refs[m.Sheet] = left.Meta.SampleID
+				references[m.Sheet] = printed[left.Name]
+				referenceIDs[m.Sheet] = proteins(printed[left.Name])
+
+				apndr.Append("ID")
+
+				for _, id := range referenceIDs[m.Sheet] {
+					apndr.Append(id)
+				}
+
+				apndr.NewCol()
+			}
+
+			ref, ids := references[m.Sheet], referenceIDs[m.Sheet]
+
+			leftRows, ok, err := align(ids, leftGPR, ref, printed[left.Name], key, w, report(refs[m.Sheet], left.Meta.SampleID))
 			if err != nil {
-				return err
+				return fmt.Errorf("%s: %w", left.Meta.Slide, err)
 			}
 
-			leftGPR = leftGPR.Averaged().SortByID()
+			aligned := ok
+			sub := subject{group: left.Meta.Group, left: values(leftGPR, w)}
 
-			if m.right != "" {
-				right, err := match(cli.Dir, m.right)
+			if m.Right != "" {
+				right, _ := exp.Get(m.Right)
+				rightGPR, rejected, err := prepare(right, summariser, outliers, label)
 				if err != nil {
 					return err
 				}
 
-				rightGPR, err = gpr.Read(right)
+				rejections = append(rejections, rejected...)
+				summarised.Add(right.Name, rightGPR, right.Meta)
+
+				_, paired, err := align(proteins(printed[left.Name]), rightGPR, printed[left.Name], printed[right.Name], key, w, report(left.Meta.SampleID, right.Meta.SampleID))
 				if err != nil {
-					return err
+					return fmt.Errorf("%s: %w", right.Meta.Slide, err)
 				}
 
-				rightGPR = rightGPR.Averaged().SortByID()
-			}
+				reportRight := report(refs[m.Sheet], right.Meta.SampleID)
+				if ref == printed[left.Name] {
+					// already reported against the left array
+					reportRight = func(*gpr.Join) {}
+				}
 
-			if newSheet {
-				apndr.Append("ID")
+				rightRows, ok, err := align(ids, rightGPR, ref, printed[right.Name], key, w, reportRight)
+				if err != nil {
+					return fmt.Errorf("%s: %w", right.Meta.Slide, err)
+				}
 
-				for _, p := range leftGPR.Rows {
-					apndr.Append(p.ID)
+				if !aligned || !paired || !ok {
+					continue
 				}
 
-				apndr.NewCol()
-			}
+				sub.right = values(rightGPR, w)
+				subjects = append(subjects, sub)
 
-			switch wv {
-			case IgG:
-				if rightGPR != nil {
-					apndr.Append(fmt.Sprintf("F550 Medium - B550 (No.%s)", m.left))
-					for _, v := range leftGPR.Rows {
-						apndr.Append(v.F550MedianB550)
-					}
+				apndr.Append(fmt.Sprintf("F%d Medium - B%d (%s)", w, w, left.Meta.SampleID))
+				for _, v := range leftRows {
+					apndr.Append(v)
+				}
 
-					apndr.NewCol()
+				apndr.NewCol()
 
-					apndr.Append(fmt.Sprintf("F550 Medium - B550 (No.%s)", m.right))
-					for _, v := range rightGPR.Rows {
-						apndr.Append(v.F550MedianB550)
-					}
+				apndr.Append(fmt.Sprintf("F%d Medium - B%d (%s)", w, w, right.Meta.SampleID))
+				for _, v := range rightRows {
+					apndr.Append(v)
+				}
 
-					apndr.NewCol()
+				apndr.NewCol()
 
-					apndr.Append(fmt.Sprintf("No.%s-No.%s", m.left, m.right))
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F550MedianB550 - rightGPR.Rows[i].F550MedianB550)
-					}
+				apndr.Append(fmt.Sprintf("%s-%s", left.Meta.SampleID, right.Meta.SampleID))
+				for i := range leftRows {
+					apndr.Append(leftRows[i] - rightRows[i])
+				}
 
-					apndr.NewCol()
+				apndr.NewCol()
 
-					apndr.Append(fmt.Sprintf("No.%s/No.%s", m.left, m.right))
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F550MedianB550 / rightGPR.Rows[i].F550MedianB550)
-					}
+				apndr.Append(fmt.Sprintf("%s/%s", left.Meta.SampleID, right.Meta.SampleID))
+				for i := range leftRows {
+					apndr.Append(leftRows[i] / rightRows[i])
+				}
 
-					apndr.NewCol()
-				} else {
-					apndr.Append("F550 Medium - B550")
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F550MedianB550)
-					}
+				apndr.NewCol()
+			} else if aligned {
+				subjects = append(subjects, sub)
 
-					apndr.NewCol()
-				}
-			case IgM:
-				if rightGPR != nil {
-					apndr.Append(fmt.Sprintf("F650 Medium - B650 (No.%s)", m.left))
-					for _, v := range leftGPR.Rows {
-						apndr.Append(v.F650MedianB650)
-					}
-
-					apndr.NewCol()
-
-					apndr.Append(fmt.Sprintf("F650 Medium - B650 (No.%s)", m.right))
-					for _, v := range rightGPR.Rows {
-						apndr.Append(v.F650MedianB650)
-					}
-
-					apndr.NewCol()
-
-					apndr.Append(fmt.Sprintf("No.%s-No.%s", m.left, m.right))
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F650MedianB650 - rightGPR.Rows[i].F650MedianB650)
-					}
-
-					apndr.NewCol()
-
-					apndr.Append(fmt.Sprintf("No.%s/No.%s", m.left, m.right))
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F650MedianB650 / rightGPR.Rows[i].F650MedianB650)
-					}
-
-					apndr.NewCol()
-				} else {
-					apndr.Append("F650 Medium - B650")
-					for i := range leftGPR.Rows {
-						apndr.Append(leftGPR.Rows[i].F650MedianB650)
-					}
-
-					apndr.NewCol()
+				apndr.Append(fmt.Sprintf("F%d Medium - B%d", w, w))
+				for _, v := range leftRows {
+					apndr.Append(v)
 				}
+
+				apndr.NewCol()
+			}
+		}
+
+		for _, m := range misaligned {
+			log.Print(m)
+		}
+
+		if len(misaligned) > 0 {
+			log.Printf("%s: %d comparisons are not aligned by %s and were left out", label.Name, len(misaligned), key)
+		}
+
+		cs := contrasts(subjects, groups, unpaired, paired)
+		results := make([][]comparison, len(cs))
+
+		for i, c := range cs {
+			results[i] = compare(c)
+		}
+
+		if err := addStatistics(spreadsheet, cs, results, w); err != nil {
+			return err
+		}
+
+		calls, cutoffs, err := thresholds.Call(spots, &summarised, w)
+		if err != nil {
+			return err
+		}
+
+		if err := addHits(spreadsheet, &exp, calls, cutoffs, summarised.Groups(), w); err != nil {
+			return err
+		}
+
+		if cli.Plots {
+			if err := writePlots(cli, label, cs, results, &summarised); err != nil {
+				return err
 			}
 		}
 
+		if err := addRejections(spreadsheet, rejections, w); err != nil {
+			return err
+		}
+
+		if err := addMisaligned(spreadsheet, misaligned); err != nil {
+			return err
+		}
+
+		if err := addFactors(spreadsheet, &exp, factors, w); err != nil {
+			return err
+		}
+
+		if err := options.AddSettings(spreadsheet, []options.Setting{
+			{Name: "Label", Value: label.String()},
+			{Name: "Samples", Value: cli.Samples},
+			{Name: "Background", Value: input.Background.String()},
+			{Name: "Spatial correction", Value: input.Spatial.String()},
+			{Name: "Negative values", Value: negative.String()},
+			{Name: "Rules", Value: cli.Rules},
+			{Name: "Layout", Value: cli.Layout},
+			{Name: "Include flagged", Value: cli.IncludeFlagged},
+			{Name: "Normalization", Value: method.String()},
+			{Name: "Statistic", Value: summariser.String()},
+			{Name: "Expected replicates", Value: cli.Replicates},
+			{Name: "Alignment", Value: key.String()},
+			{Name: "Outliers", Value: outliersName},
+			{Name: "Test", Value: unpaired.name},
+			{Name: "Paired test", Value: paired.name},
+			{Name: "Hit cutoff", Value: thresholds.String()},
+			{Name: "Hit minimum SNR", Value: thresholds.MinSNR},
+			{Name: "Hit minimum fold", Value: thresholds.MinFold},
+			{Name: "Hit reference group", Value: thresholds.Reference},
+			{Name: "Hit replicate agreement", Value: thresholds.Agreement},
+			{Name: "Plots", Value: cli.Plots},
+			{Name: "Outliers rejected", Value: len(rejections)},
+			{Name: "Misaligned comparisons", Value: len(misaligned)},
+		}); err != nil {
+			return err
+		}
+
 		if err := func() error {
-			f, err := os.Create(filepath.Join(cli.Dir, fmt.Sprintf("%s Results.xlsx", wv)))
+			f, err := os.Create(filepath.Join(cli.Dir, fmt.Sprintf("%s Results.xlsx", label.Name)))
 			if err != nil {
 				return err
 			}
@@ -269,28 +519,618 @@ func work(cli *CLI) error {
 	return nil
 }
 
-func match(dir, part string) (string, error) {
-	matches, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("*No.%s.gpr", part)))
+// subject is an array, and the array it is paired with if any, giving the
+// value of each protein.
+type subject struct {
+	group string
+	left  map[string]float64
+	right map[string]float64
+}
+
+func values(g *gpr.GPR, w int) map[string]float64 {
+	m := make(map[string]float64, len(g.Rows))
+	for _, r := range g.Rows {
+		m[r.ID] = r.Channel(w).MedianMinusBackground
+	}
+
+	return m
+}
+
+type test struct {
+	name string
+	run  func(a, b []float64) stats.TestResult
+}
+
+var tests = map[string]test{
+	"welch":       {"Welch's t", stats.WelchT},
+	"mannwhitney": {"Mann-Whitney U", stats.MannWhitneyU},
+	"t":           {"paired t", stats.PairedT},
+	"wilcoxon":    {"Wilcoxon signed-rank", stats.WilcoxonSignedRank},
+}
+
+// contrast compares the values of two sets of arrays for every protein. The
+// arrays of paired contrasts are compared in order.
+type contrast struct {
+	name string
+	a, b string
+	test test
+	as   []map[string]float64
+	bs   []map[string]float64
+}
+
+// comparedGroups parses the GROUP:GROUP comparisons, returning every pair of
+// groups if none are given.
+func comparedGroups(specs []string, groups []string) ([][2]string, error) {
+	var pairs [][2]string
+
+	if len(specs) == 0 {
+		for i := range groups {
+			for j := i + 1; j < len(groups); j++ {
+				pairs = append(pairs, [2]string{groups[i], groups[j]})
+			}
+		}
+
+		return pairs, nil
+	}
+
+	known := map[string]bool{}
+	for _, g := range groups {
+		known[g] = true
+	}
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid comparison %q, expected GROUP:GROUP", spec)
+		}
+
+		for _, p := range parts {
+			if !known[p] {
+				return nil, fmt.Errorf("comparison %q: unknown group %q", spec, p)
+			}
+		}
+
+		pairs = append(pairs, [2]string{parts[0], parts[1]})
+	}
+
+	return pairs, nil
+}
+
+// contrasts compares the arrays of each pair of groups, and the arrays of
+// each group with their pairs when every array of the group has one.
+func contrasts(subjects []subject, groups [][2]string, unpaired, paired test) []contrast {
+	byGroup := map[string][]subject{}
+	var names []string
+
+	for _, s := range subjects {
+		if _, ok := byGroup[s.group]; !ok {
+			names = append(names, s.group)
+		}
+
+		byGroup[s.group] = append(byGroup[s.group], s)
+	}
+
+	var cs []contrast
+
+	for _, g := range groups {
+		c := contrast{name: g[0] + " vs " + g[1], a: g[0], b: g[1], test: unpaired}
+
+		for _, s := range byGroup[g[0]] {
+			c.as = append(c.as, s.left)
+		}
+
+		for _, s := range byGroup[g[1]] {
+			c.bs = append(c.bs, s.left)
+		}
+
+		cs = append(cs, c)
+	}
+
+	for _, name := range names {
+		c := contrast{name: name + " left vs right", a: "left", b: "right", test: paired}
+
+		for _, s := range byGroup[name] {
+			if s.right == nil {
+				c.as = nil
+				break
+			}
+
+			c.as = append(c.as, s.left)
+			c.bs = append(c.bs, s.right)
+		}
+
+		if len(c.as) > 0 {
+			cs = append(cs, c)
+		}
+	}
+
+	return cs
+}
+
+// comparison is the test of a protein in a contrast.
+type comparison struct {
+	id           string
+	nA, nB       int
+	meanA, meanB float64
+	result       stats.TestResult
+	q            float64
+	bonferroni   float64
+}
+
+// fold returns the ratio of the group means.
+func (c comparison) fold() float64 {
+	return c.meanA / c.meanB
+}
+
+// compare tests every protein of a contrast, with p values adjusted across
+// the proteins.
+func compare(c contrast) []comparison {
+	seen := map[string]bool{}
+	var ids []string
+
+	for _, vs := range append(append([]map[string]float64{}, c.as...), c.bs...) {
+		for id := range vs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	sort.Strings(ids)
+
+	column := func(vs []map[string]float64, id string) []float64 {
+		xs := make([]float64, len(vs))
+		for i, v := range vs {
+			x, ok := v[id]
+			if !ok {
+				x = math.NaN()
+			}
+
+			xs[i] = x
+		}
+
+		return xs
+	}
+
+	cs := make([]comparison, len(ids))
+	ps := make([]float64, len(ids))
+
+	for i, id := range ids {
+		a, b := column(c.as, id), column(c.bs, id)
+
+		cs[i] = comparison{
+			id:     id,
+			nA:     len(stats.Values(a)),
+			nB:     len(stats.Values(b)),
+			meanA:  stats.Mean(a),
+			meanB:  stats.Mean(b),
+			result: c.test.run(a, b),
+		}
+
+		ps[i] = cs[i].result.P
+	}
+
+	qs := stats.BenjaminiHochberg(ps)
+	bonferroni := stats.Bonferroni(ps)
+
+	for i := range cs {
+		cs[i].q, cs[i].bonferroni = qs[i], bonferroni[i]
+	}
+
+	return cs
+}
+
+// addStatistics lists the group means, fold change and test of every
+// protein in each contrast.
+func addStatistics(spreadsheet *xlsx.File, cs []contrast, results [][]comparison, w int) error {
+	if len(cs) == 0 {
+		return nil
+	}
+
+	sheet, err := spreadsheet.AddSheet("Statistics")
+	if err != nil {
+		return err
+	}
+
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("Comparison", "Test", "ID", "A", "n A", fmt.Sprintf("Mean A F%d Median - B%d", w, w), "B", "n B", fmt.Sprintf("Mean B F%d Median - B%d", w, w), "Fold Change", "Statistic", "p", "q", "Bonferroni")
+	apndr.NewRow()
+
+	for i, c := range cs {
+		for _, r := range results[i] {
+			apndr.Append(c.name, c.test.name, r.id, c.a, r.nA, r.meanA, c.b, r.nB, r.meanB, r.fold(), r.result.Statistic, r.result.P, r.q, r.bonferroni)
+			apndr.NewRow()
+		}
+	}
+
+	return nil
+}
+
+// writePlots draws a volcano plot of every contrast and a bar plot of the
+// top proteins of every group, named after the label like the results.
+func writePlots(cli *CLI, label gpr.Label, cs []contrast, results [][]comparison, summarised *experiment.Experiment) error {
+	w := label.Wavelength
+
+	for i, c := range cs {
+		ids := make([]string, len(results[i]))
+		folds := make([]float64, len(results[i]))
+		qs := make([]float64, len(results[i]))
+
+		for j, r := range results[i] {
+			ids[j], folds[j], qs[j] = r.id, r.fold(), r.q
+		}
+
+		p := plot.Volcano(fmt.Sprintf("%s %s (%s)", label.Name, c.name, c.test.name), ids, folds, qs, cli.PlotTop, cli.PlotQ, cli.PlotFold)
+		if err := savePlot(filepath.Join(cli.Dir, fileName(fmt.Sprintf("%s Volcano %s.png", label.Name, c.name))), p); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range summarised.Groups() {
+		mx := summarised.Group(group).Matrix(experiment.MedianMinusBackground(w))
+
+		means := make([]float64, len(mx.Proteins))
+		sds := make([]float64, len(mx.Proteins))
+
+		for i := range mx.Proteins {
+			means[i], sds[i] = stats.Mean(mx.Row(i)), stats.SD(mx.Row(i))
+		}
+
+		r := plot.Rank(fmt.Sprintf("%s %s top %d", label.Name, group, cli.PlotTop), fmt.Sprintf("Mean F%d Median - B%d, bars show the SD", w, w), mx.Proteins, means, sds, cli.PlotTop)
+		if err := savePlot(filepath.Join(cli.Dir, fileName(fmt.Sprintf("%s Top %s.png", label.Name, group))), r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileName replaces the path separators of a name.
+func fileName(name string) string {
+	return strings.NewReplacer("/", "-", "\\", "-").Replace(name)
+}
+
+// savePlot writes a chart as PNG, logging charts with nothing to draw.
+func savePlot(path string, p interface{ Render() (image.Image, error) }) error {
+	img, err := p.Render()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// checkControls fails when none of the controls hit cutoffs are derived from
+// were classified on any array.
+func checkControls(exp *experiment.Experiment, controls []gpr.Control) error {
+	if len(controls) == 0 {
+		return nil
+	}
+
+	for _, a := range exp.Arrays {
+		for _, r := range a.Data.Rows {
+			for _, c := range controls {
+				if r.Control == c {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("no %v control spots on any array to derive hit cutoffs from, check the rules or layout", controls)
+}
+
+// addHits lists the hits of each group, the hit frequency of every protein
+// and the cutoff of each array.
+func addHits(spreadsheet *xlsx.File, exp *experiment.Experiment, calls []hits.Call, cutoffs []hits.Cutoff, groups []string, w int) error {
+	sampleID := func(name string) string {
+		a, _ := exp.Get(name)
+		return a.Meta.SampleID
+	}
+
+	sheet, err := spreadsheet.AddSheet("Hits")
+	if err != nil {
+		return err
+	}
+
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("Group", "Sample", "ID", fmt.Sprintf("F%d Median - B%d", w, w), "Cutoff", "SNR", "Fold", "Replicate Agreement")
+	apndr.NewRow()
+
+	for _, g := range groups {
+		for _, c := range calls {
+			if c.Group == g && c.Hit {
+				apndr.Append(c.Group, sampleID(c.Array), c.ID, c.Value, c.Cutoff, c.SNR, c.Fold, c.Agreement)
+				apndr.NewRow()
+			}
+		}
+	}
+
+	sheet, err = spreadsheet.AddSheet("Hit Frequency")
+	if err != nil {
+		return err
+	}
+
+	apndr = appender.NewRowAppender(sheet)
+	apndr.Append("ID")
+
+	for _, g := range groups {
+		apndr.Append(g+" Hits", g+" Arrays", g+" Frequency")
+	}
+
+	apndr.Append("Total Hits", "Total Arrays", "Total Frequency")
+	apndr.NewRow()
+
+	// groups without a cutoff on any array have no frequency
+	frequency := func(hits, arrays int) interface{} {
+		if arrays == 0 {
+			return ""
+		}
+
+		return float64(hits) / float64(arrays)
+	}
+
+	for _, f := range hits.Frequencies(calls) {
+		apndr.Append(f.ID)
+
+		for _, g := range groups {
+			apndr.Append(f.Hits[g], f.Arrays[g], frequency(f.Hits[g], f.Arrays[g]))
+		}
+
+		n, arrays := f.Total()
+		apndr.Append(n, arrays, frequency(n, arrays))
+		apndr.NewRow()
+	}
+
+	sheet, err = spreadsheet.AddSheet("Hit Cutoffs")
+	if err != nil {
+		return err
+	}
+
+	apndr = appender.NewRowAppender(sheet)
+	apndr.Append("Sample", "Controls", "Mean", "SD", "Cutoff", "QC")
+	apndr.NewRow()
+
+	for _, c := range cutoffs {
+		apndr.Append(sampleID(c.Array), c.N, c.Mean, c.SD, c.Value, c.Failed)
+		apndr.NewRow()
+	}
+
+	return nil
+}
+
+// addFactors lists the scaling applied to each array by normalization.
+func addFactors(spreadsheet *xlsx.File, exp *experiment.Experiment, factors []norm.Factor, w int) error {
+	sheet, err := spreadsheet.AddSheet("Normalization")
+	if err != nil {
+		return err
+	}
+
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("File", "Scale", "QC")
+	apndr.NewRow()
+
+	for _, f := range factors {
+		if f.Wavelength != w {
+			continue
+		}
+
+		apndr.Append(exp.Arrays[f.Array].Meta.Slide, f.Scale, f.Failed)
+		apndr.NewRow()
+	}
+
+	return nil
+}
+
+// load reads an array, returning its spots left after the filters and every
+// sample spot it was printed with.
+func load(path string, opts []gpr.Option, layout *gal.GAL, rules gpr.Rules, filters []gpr.Filter, correction spatial.Correction) (*gpr.GPR, *gpr.GPR, error) {
+	g, err := gpr.Read(path, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if layout != nil {
+		var mismatches []gal.Mismatch
+
+		g, mismatches = gal.Join(g, layout)
+		g.Classify(rules)
+
+		if len(mismatches) > 0 {
+			log.Printf("%s: %d spots do not match the layout (%v)", filepath.Base(path), len(mismatches), gal.Summary(mismatches))
+		}
+	}
+
+	all, _ := g.Filter(gpr.SamplesOnly)
+
+	g, ex := g.Filter(filters...)
+	if ex.Total() > 0 {
+		log.Printf("%s: excluded %d spots (%s)", filepath.Base(path), ex.Total(), ex)
+	}
+
+	return spatial.Correct(g, correction), all, nil
+}
+
+// prepare checks an array has the channel of the label and summarises it,
+// sorted by ID.
+func prepare(a experiment.Array, summariser gpr.Summariser, outliers gpr.OutlierTest, label gpr.Label) (*gpr.GPR, []rejection, error) {
+	w := label.Wavelength
+
+	if !a.Data.HasWavelength(w) {
+		return nil, nil, fmt.Errorf("%s: no %d channel for %s", a.Meta.Slide, w, label.Name)
+	}
+
+	g, rejected := summarise(a.Meta.Slide, a.Data, summariser, outliers, w)
+
+	return g.SortByID(), rejected, nil
+}
+
+// align returns the value of each protein of ids in g, NaN where every spot
+// of a protein was filtered out, or false after calling misaligned. The
+// arrays are compared on every sample spot they were printed with, ref and
+// printed, by position first if key is ByPosition.
+func align(ids []string, g, ref, printed *gpr.GPR, key gpr.Key, w int, misaligned func(*gpr.Join)) ([]float64, bool, error) {
+	if key == gpr.ByPosition {
+		j, err := gpr.JoinRows(ref, printed, gpr.ByPosition)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !j.Aligned() || len(differing(j)) > 0 {
+			misaligned(j)
+			return nil, false, nil
+		}
+	}
+
+	j, err := gpr.JoinRows(firstSpots(ref), firstSpots(printed), gpr.ByID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !j.Aligned() {
+		misaligned(j)
+		return nil, false, nil
+	}
+
+	summarised := make(map[string]float64, len(g.Rows))
+	for _, r := range g.Rows {
+		summarised[r.ID] = r.Channel(w).MedianMinusBackground
+	}
+
+	values := make([]float64, len(ids))
+	for i, id := range ids {
+		v, ok := summarised[id]
+		if !ok {
+			v = math.NaN()
+		}
+
+		values[i] = v
+	}
+
+	return values, true, nil
+}
+
+// firstSpots returns the first spot of each protein of g, sorted by ID.
+func firstSpots(g *gpr.GPR) *gpr.GPR {
+	rows := append([]gpr.Row(nil), g.Rows...)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	seen := map[string]bool{}
+	first := &gpr.GPR{}
+
+	for _, r := range rows {
+		if !seen[r.ID] {
+			seen[r.ID] = true
+			first.Rows = append(first.Rows, r)
+		}
+	}
+
+	return first
+}
+
+// proteins returns the IDs of the proteins of g, sorted.
+func proteins(g *gpr.GPR) []string {
+	var ids []string
+	for _, r := range firstSpots(g).Rows {
+		ids = append(ids, r.ID)
+	}
+
+	return ids
+}
+
+// summarise rejects outliers, if a test is given, and summarises the
+// replicates of g. Rejections in the given wavelength are returned.
+func summarise(file string, g *gpr.GPR, summariser gpr.Summariser, outliers gpr.OutlierTest, w int) (*gpr.GPR, []rejection) {
+	var rejections []rejection
+
+	if outliers != nil {
+		var rejected []gpr.Rejection
+
+		g, rejected = g.RejectOutliers(outliers)
+		for _, r := range rejected {
+			if r.Wavelength == w {
+				rejections = append(rejections, rejection{file: file, Rejection: r})
+			}
+		}
+	}
+
+	avg, unexpected := summariser.Summarise(g)
+	for _, r := range unexpected {
+		log.Printf("%s: %s", file, r)
+	}
+
+	return avg, rejections
+}
+
+// addMisaligned lists the comparisons left out as their arrays do not hold
+// the same proteins.
+func addMisaligned(spreadsheet *xlsx.File, misaligned []misalignment) error {
+	if len(misaligned) == 0 {
+		return nil
+	}
+
+	sheet, err := spreadsheet.AddSheet("Misaligned")
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(matches) > 1 {
-		return "", fmt.Errorf("glob did not match exactly one file: %s", spew.Sdump(matches))
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("Sheet", "Array", "Compared With", "Block", "Column", "Row", "ID", "Other ID")
+	apndr.NewRow()
+
+	for _, m := range misaligned {
+		add := func(r gpr.Row, id, other string) {
+			apndr.Append(m.sheet, m.right, m.left, r.Block, r.Column, r.Row, id, other)
+			apndr.NewRow()
+		}
+
+		for _, r := range m.join.Left {
+			add(r, "", r.ID)
+		}
+
+		for _, r := range m.join.Right {
+			add(r, r.ID, "")
+		}
+
+		for _, p := range differing(m.join) {
+			add(p.Right, p.Right.ID, p.Left.ID)
+		}
 	}
 
-	if len(matches) == 1 {
-		return matches[0], nil
+	return nil
+}
+
+// addRejections lists the replicate spots rejected as outliers.
+func addRejections(spreadsheet *xlsx.File, rejections []rejection, w int) error {
+	if len(rejections) == 0 {
+		return nil
 	}
 
-	matches, err = filepath.Glob(filepath.Join(dir, fmt.Sprintf("*No. %s.gpr", part)))
+	sheet, err := spreadsheet.AddSheet("Rejected Spots")
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(matches) != 1 {
-		return "", fmt.Errorf("glob did not match exactly one file: %s", spew.Sdump(matches))
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("File", "ID", "Block", "Column", "Row", fmt.Sprintf("F%d Median - B%d", w, w), "Reason")
+	apndr.NewRow()
+
+	for _, r := range rejections {
+		apndr.Append(r.file, r.ID, r.Block, r.Column, r.Row, r.Value, r.Reason)
+		apndr.NewRow()
 	}
 
-	return matches[0], nil
+	return nil
 }
@@ -4,18 +4,33 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/alecthomas/kong"
+	"gitlab.node-3.net/nadams/gpr/curve"
+	"gitlab.node-3.net/nadams/gpr/gal"
 	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/norm"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/spatial"
 )
 
 type CLI struct {
-	Dir         string `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" default:"."`
-	UseSubtract bool   `arg:"" name:"use-subtract" help:"Use the subtraction fields." optional:""`
+	options.Input
+
+	Dir            string `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" default:"."`
+	UseSubtract    bool   `arg:"" name:"use-subtract" help:"Use the subtraction fields, always used when the background, spatial, normalization or curve option is set." optional:""`
+	IncludeFlagged bool   `name:"include-flagged" help:"Keep spots flagged Bad, Absent or Not Found by GenePix."`
+	Layout         string `name:"gal" help:"GenePix Array List giving the IDs, names and annotations of every spot." type:"existingfile" optional:""`
+	Normalization  string `name:"normalization" help:"Method normalizing intensities across the arrays (none, median, quantile, total, loess, controls)." enum:"none,median,quantile,total,loess,controls" default:"none"`
+	Curve          string `name:"curve" help:"Standard curve converting intensities to concentrations (none, 4pl, 5pl), fitted and estimated from the background subtracted medians before normalization." enum:"none,4pl,5pl" default:"none"`
+	Concentration  string `name:"concentration" help:"Layout annotation giving the concentration of standard spots." default:"Concentration"`
+	Standards      string `name:"standards" help:"Case insensitive pattern matching the IDs of the positive control standards of each label, with {label} standing for its name." default:"^{label}"`
+	Negative       string `name:"negative" help:"How negative background subtracted values are treated (raw, clamp, missing, offset)." enum:"raw,clamp,missing,offset" default:"clamp"`
 }
 
 func main() {
@@ -24,6 +39,36 @@ func main() {
 
 	ctx.FatalIfErrorf(ctx.Validate())
 
+	input, err := cli.Input.Parse()
+	ctx.FatalIfErrorf(err)
+
+	var filters []gpr.Filter
+	if !cli.IncludeFlagged {
+		filters = append(filters, gpr.ExcludeFlagged)
+	}
+
+	negative, err := gpr.ParseNegativePolicy(cli.Negative, filters...)
+	ctx.FatalIfErrorf(err)
+
+	var layout *gal.GAL
+	var annotations []string
+
+	if cli.Layout != "" {
+		layout, err = gal.Read(cli.Layout)
+		ctx.FatalIfErrorf(err)
+
+		annotations = layout.Annotations
+	}
+
+	method, err := norm.Parse(cli.Normalization)
+	ctx.FatalIfErrorf(err)
+
+	var model curve.Model
+	if cli.Curve != "none" {
+		model, err = curve.ParseModel(cli.Curve)
+		ctx.FatalIfErrorf(err)
+	}
+
 	fis, err := ioutil.ReadDir(cli.Dir)
 	ctx.FatalIfErrorf(err)
 
@@ -35,71 +80,206 @@ func main() {
 		}
 	}
 
+	// the raw medians are only written when nothing would adjust them
+	_, genepix := input.Background.(gpr.GenePix)
+	useSubtract := cli.UseSubtract || !genepix || cli.Spatial != "none" || cli.Normalization != "none" || model != 0
+
+	values, negativeName := "median", "not applied to raw medians"
+	if useSubtract {
+		values, negativeName = "median - background", negative.String()
+	}
+
 	resultsDir := filepath.Join(cli.Dir, "pcp_results")
 
-	for _, fis := range newFis {
-		if err := func() error {
-			gprPath := filepath.Join(cli.Dir, fis.Name())
-			groupName := strings.TrimSuffix(fis.Name(), ".gpr")
+	ctx.FatalIfErrorf(writeSettings(resultsDir, [][]string{
+		{"Values", values},
+		{"Background", input.Background.String()},
+		{"Spatial correction", input.Spatial.String()},
+		{"Negative values", negativeName},
+		{"Rules", cli.Rules},
+		{"Layout", cli.Layout},
+		{"Include flagged", strconv.FormatBool(cli.IncludeFlagged)},
+		{"Normalization", method.String()},
+		{"Standard curve", cli.Curve},
+		{"Standards", cli.Standards},
+	}))
+
+	var names []string
+	var arrays []*gpr.GPR
 
-			data, err := gpr.Read(gprPath)
+	for _, fis := range newFis {
+		data, err := func() (*gpr.GPR, error) {
+			data, err := gpr.Read(filepath.Join(cli.Dir, fis.Name()), gpr.WithBackground(input.Background), gpr.WithNegativePolicy(negative), gpr.WithRules(input.Rules))
 			if err != nil {
-				return fmt.Errorf("could not load gpr data: %w", err)
+				return nil, fmt.Errorf("could not load gpr data: %w", err)
 			}
 
-			iggDir := filepath.Join(resultsDir, "IgG")
-			igmDir := filepath.Join(resultsDir, "IgM")
+			if layout != nil {
+				var mismatches []gal.Mismatch
 
-			if err := os.MkdirAll(iggDir, 0755); err != nil {
-				return fmt.Errorf("could not create IgG dir: %w", err)
-			}
+				data, mismatches = gal.Join(data, layout)
+				data.Classify(input.Rules)
 
-			if err := os.MkdirAll(igmDir, 0755); err != nil {
-				return fmt.Errorf("could not create IgM dir: %w", err)
+				if len(mismatches) > 0 {
+					log.Printf("%s: %d spots do not match the layout (%v)", fis.Name(), len(mismatches), gal.Summary(mismatches))
+				}
 			}
 
-			iggFile, err := os.Create(filepath.Join(iggDir, groupName+".csv"))
-			if err != nil {
-				return fmt.Errorf("could not create IgG file: %w", err)
+			data, ex := data.Filter(filters...)
+			if ex.Total() > 0 {
+				log.Printf("%s: excluded %d spots (%s)", fis.Name(), ex.Total(), ex)
 			}
 
-			defer iggFile.Close()
+			data = spatial.Correct(data, input.Spatial)
 
-			igmFile, err := os.Create(filepath.Join(igmDir, groupName+".csv"))
-			if err != nil {
-				return fmt.Errorf("could not create IgM file: %w", err)
+			if err := input.Labels.Check(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", fis.Name(), err)
 			}
 
-			defer igmFile.Close()
+			return data, nil
+		}()
+		ctx.FatalIfErrorf(err)
 
-			iggOut := csv.NewWriter(iggFile)
-			igmOut := csv.NewWriter(igmFile)
+		names = append(names, strings.TrimSuffix(fis.Name(), ".gpr"))
+		arrays = append(arrays, data)
+	}
 
-			defer iggOut.Flush()
-			defer igmOut.Flush()
+	// curves are fitted, and concentrations estimated, on the scale of the
+	// standards before normalization
+	curves := make([]map[int]curve.Curve, len(arrays))
+	unnormalized := arrays
 
-			switch {
-			case cli.UseSubtract:
-				iggOut.Write([]string{"ID", "F550 Median - B550", "Block", "Column", "Row"})
-				igmOut.Write([]string{"ID", "F650 Median - B650", "Block", "Column", "Row"})
+	if model != 0 {
+		for i, data := range arrays {
+			var errs map[int]error
 
-				for _, row := range data.SortByID().Rows {
-					iggOut.Write([]string{row.ID, fmt.Sprintf("%v", row.F550MedianB550), strconv.Itoa(row.Block), strconv.Itoa(row.Column), strconv.Itoa(row.Row)})
-					igmOut.Write([]string{row.ID, fmt.Sprintf("%v", row.F650MedianB650), strconv.Itoa(row.Block), strconv.Itoa(row.Column), strconv.Itoa(row.Row)})
-				}
-			default:
-				iggOut.Write([]string{"ID", "F550 Median", "Block", "Column", "Row"})
-				igmOut.Write([]string{"ID", "F650 Median", "Block", "Column", "Row"})
+			curves[i], errs = curve.FitLabels(data, input.Labels, cli.Concentration, cli.Standards, model)
+			for w, err := range errs {
+				log.Printf("%s: no %d standard curve: %v", names[i], w, err)
+			}
+		}
+	}
 
-				for _, row := range data.SortByID().Rows {
-					iggOut.Write([]string{row.ID, fmt.Sprintf("%v", row.F550Median), strconv.Itoa(row.Block), strconv.Itoa(row.Column), strconv.Itoa(row.Row)})
-					igmOut.Write([]string{row.ID, fmt.Sprintf("%v", row.F650Median), strconv.Itoa(row.Block), strconv.Itoa(row.Column), strconv.Itoa(row.Row)})
-				}
+	arrays, factors := norm.Normalize(arrays, method)
+	ctx.FatalIfErrorf(writeFactors(resultsDir, names, factors))
+
+	for _, f := range factors {
+		if f.Failed != "" {
+			log.Printf("%s: %d channel failed normalization QC: %s", names[f.Array], f.Wavelength, f.Failed)
+		}
+	}
+
+	for i, data := range arrays {
+		// controls are kept until normalized and fitted as some methods
+		// depend on them
+		data, ex := data.Filter(gpr.SamplesOnly)
+		if ex.Total() > 0 {
+			log.Printf("%s: excluded %d control spots (%s)", names[i], ex.Total(), ex)
+		}
+
+		for _, label := range input.Labels {
+			if err := writeLabel(filepath.Join(resultsDir, label.Name), names[i], label.Wavelength, data, unnormalized[i], curves[i], annotations, useSubtract); err != nil {
+				ctx.FatalIfErrorf(fmt.Errorf("could not write %s results: %w", label.Name, err))
 			}
+		}
+	}
+}
+
+// writeFactors records the scaling applied to each array by normalization.
+func writeFactors(dir string, names []string, factors []norm.Factor) error {
+	f, err := os.Create(filepath.Join(dir, "normalization.csv"))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	out := csv.NewWriter(f)
+	out.Write([]string{"Array", "Wavelength", "Scale", "QC"})
+
+	for _, x := range factors {
+		out.Write([]string{names[x.Array], strconv.Itoa(x.Wavelength), fmt.Sprintf("%v", x.Scale), x.Failed})
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
+
+// writeSettings records the options used to produce the results.
+func writeSettings(dir string, settings [][]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "settings.csv"))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	out := csv.NewWriter(f)
+	out.Write([]string{"Setting", "Value"})
+	out.WriteAll(settings)
+
+	return out.Error()
+}
+
+// writeLabel writes the spots of data for one wavelength. Concentrations are
+// estimated from the same spots of raw, before they were normalized.
+func writeLabel(dir, groupName string, w int, data, raw *gpr.GPR, curves map[int]curve.Curve, annotations []string, useSubtract bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, groupName+".csv"))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	out := csv.NewWriter(f)
+	defer out.Flush()
+
+	title := fmt.Sprintf("F%d Median", w)
+	if useSubtract {
+		title = fmt.Sprintf("F%d Median - B%d", w, w)
+	}
+
+	c, fit := curves[w]
+
+	signals := make(map[string]float64, len(raw.Rows))
+	for _, row := range raw.Rows {
+		signals[gpr.ByPosition.Of(row)] = row.Channel(w).MedianMinusBackground
+	}
 
-			return nil
-		}(); err != nil {
-			ctx.FatalIfErrorf(err)
+	titles := []string{"ID", title, "Block", "Column", "Row"}
+	if fit {
+		titles = append(titles, "Concentration", "LOQ")
+	}
+
+	out.Write(append(titles, annotations...))
+
+	for _, row := range data.SortByID().Rows {
+		v := row.Channel(w).Median
+		if useSubtract {
+			v = row.Channel(w).MedianMinusBackground
+		}
+
+		record := []string{row.ID, fmt.Sprintf("%v", v), strconv.Itoa(row.Block), strconv.Itoa(row.Column), strconv.Itoa(row.Row)}
+		if fit {
+			conc, note := c.Estimate(signals[gpr.ByPosition.Of(row)])
+			record = append(record, fmt.Sprintf("%v", conc), note)
 		}
+
+		for _, a := range annotations {
+			record = append(record, row.Annotations[a])
+		}
+
+		out.Write(record)
 	}
+
+	return nil
 }
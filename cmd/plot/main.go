@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kong"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/plot"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+)
+
+// Input is the reading of the gpr files shared by every plot.
+type Input struct {
+	options.Input
+
+	Paths          []string `arg:"" name:"path" help:"gpr files, or directories containing them." type:"existingfile|existingdir" default:"."`
+	Output         string   `name:"output" short:"o" help:"Directory the images are written to, next to each gpr file by default." type:"existingdir" optional:""`
+	IncludeFlagged bool     `name:"include-flagged" help:"Keep spots flagged Bad, Absent or Not Found by GenePix."`
+}
+
+type CLI struct {
+	Scatter struct {
+		Input
+	} `cmd:"" help:"Plot the first label against the second for every spot."`
+
+	MA struct {
+		Input
+		Reference string `name:"reference" help:"Array every other array is compared with, the first by default." type:"existingfile" optional:""`
+	} `cmd:"" name:"ma" help:"Plot the log ratio against the mean log intensity of each protein between two arrays."`
+
+	Concordance struct {
+		Input
+	} `cmd:"" help:"Plot the first replicate spot of each protein against the second."`
+
+	Histogram struct {
+		Input
+		Bins int `name:"bins" help:"Number of bins." default:"30"`
+	} `cmd:"" help:"Plot the distribution of sample spot intensities."`
+}
+
+// array is a read gpr file.
+type array struct {
+	name string
+	path string
+	data *gpr.GPR
+}
+
+func main() {
+	var cli CLI
+	ctx := kong.Parse(&cli)
+
+	ctx.FatalIfErrorf(ctx.Validate())
+
+	var err error
+
+	switch cmd := strings.Fields(ctx.Command())[0]; cmd {
+	case "scatter":
+		err = scatter(&cli.Scatter.Input)
+	case "ma":
+		err = ma(&cli.MA.Input, cli.MA.Reference)
+	case "concordance":
+		err = concordance(&cli.Concordance.Input)
+	case "histogram":
+		err = histogram(&cli.Histogram.Input, cli.Histogram.Bins)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+
+	ctx.FatalIfErrorf(err)
+}
+
+// files lists the gpr files of the paths, in the order given.
+func files(paths []string) ([]string, error) {
+	var out []string
+
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			out = append(out, p)
+			continue
+		}
+
+		fis, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range fis {
+			if strings.ToLower(filepath.Ext(f.Name())) == ".gpr" {
+				out = append(out, filepath.Join(p, f.Name()))
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no gpr files in %s", strings.Join(paths, ", "))
+	}
+
+	return out, nil
+}
+
+func (in *Input) read(extra ...string) ([]array, gpr.Labels, error) {
+	input, err := in.Input.Parse()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var filters []gpr.Filter
+	if !in.IncludeFlagged {
+		filters = append(filters, gpr.ExcludeFlagged)
+	}
+
+	paths, err := files(in.Paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var arrays []array
+
+	for _, p := range append(extra, paths...) {
+		g, err := gpr.Read(p, gpr.WithBackground(input.Background), gpr.WithRules(input.Rules))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		g, _ = g.Filter(filters...)
+
+		arrays = append(arrays, array{
+			name: strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)),
+			path: p,
+			data: spatial.Correct(g, input.Spatial),
+		})
+	}
+
+	return arrays, input.Labels, nil
+}
+
+// write saves a plot of an array as NAME-SUFFIX.png, logging plots with
+// nothing to draw.
+func (in *Input) write(a array, suffix string, p *plot.Plot) error {
+	dir := in.Output
+	if dir == "" {
+		dir = filepath.Dir(a.path)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WritePNG(&buf); err != nil {
+		log.Printf("%s: %v", a.name, err)
+		return nil
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s-%s.png", a.name, suffix)), buf.Bytes(), 0644)
+}
+
+func scatter(in *Input) error {
+	arrays, labels, err := in.read()
+	if err != nil {
+		return err
+	}
+
+	if len(labels) < 2 {
+		return fmt.Errorf("scatter plots need two labels")
+	}
+
+	for _, a := range arrays {
+		p := plot.Scatter(a.name, a.data, labels[0], labels[1])
+		if err := in.write(a, fmt.Sprintf("%s-%s", labels[1].Name, labels[0].Name), p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ma(in *Input, reference string) error {
+	var extra []string
+	if reference != "" {
+		extra = append(extra, reference)
+	}
+
+	arrays, labels, err := in.read(extra...)
+	if err != nil {
+		return err
+	}
+
+	if len(arrays) < 2 {
+		return fmt.Errorf("MA plots need two arrays")
+	}
+
+	summarise := func(g *gpr.GPR) *gpr.GPR {
+		samples, _ := g.Filter(gpr.SamplesOnly)
+		s, _ := gpr.DefaultSummariser.Summarise(samples)
+
+		return s
+	}
+
+	ref := arrays[0]
+	rs := summarise(ref.data)
+
+	for _, a := range arrays[1:] {
+		if a.path == ref.path {
+			continue
+		}
+
+		as := summarise(a.data)
+
+		for _, l := range labels {
+			p, err := plot.MA(as, rs, a.name, ref.name, l)
+			if err != nil {
+				return fmt.Errorf("%s: %w", a.name, err)
+			}
+
+			if err := in.write(a, fmt.Sprintf("ma-%s-%s", ref.name, l.Name), p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func concordance(in *Input) error {
+	arrays, labels, err := in.read()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range arrays {
+		for _, l := range labels {
+			if err := in.write(a, fmt.Sprintf("concordance-%s", l.Name), plot.Concordance(a.name, a.data, l)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func histogram(in *Input, bins int) error {
+	arrays, labels, err := in.read()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range arrays {
+		for _, l := range labels {
+			if err := in.write(a, fmt.Sprintf("histogram-%s", l.Name), plot.Histogram(a.name, a.data, l, bins)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
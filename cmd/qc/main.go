@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/tealeg/xlsx"
+
+	"gitlab.node-3.net/nadams/gpr/appender"
+	"gitlab.node-3.net/nadams/gpr/gal"
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/options"
+	"gitlab.node-3.net/nadams/gpr/qc"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+)
+
+type CLI struct {
+	options.Input
+
+	Dir             string `arg:"" name:"dir" help:"Directory containing gpr files." type:"existingdir" default:"."`
+	Output          string `name:"output" short:"o" help:"Summary written as CSV, or xlsx if the name ends in .xlsx, qc.csv in the directory by default."`
+	Layout          string `name:"gal" help:"GenePix Array List giving the IDs, names and annotations of every spot." type:"existingfile" optional:""`
+	Flagged         string `name:"flagged" help:"Percentage of spots flagged Bad or Absent that warns and fails, as WARN,FAIL." default:"10,25"`
+	NotFound        string `name:"not-found" help:"Percentage of spots flagged Not Found that warns and fails, as WARN,FAIL." default:"10,25"`
+	CV              string `name:"cv" help:"Median replicate CV that warns and fails, as WARN,FAIL." default:"0.2,0.4"`
+	Saturated       string `name:"saturated" help:"Mean percentage of saturated pixels that warns and fails, as WARN,FAIL." default:"1,5"`
+	BackgroundLevel string `name:"background-level" help:"Median local background that warns and fails, as WARN,FAIL." default:"1000,5000"`
+	NegativeSNR     string `name:"negative-snr" help:"Median SNR of negative control spots that warns and fails, as WARN,FAIL." default:"2,5"`
+	Gradient        string `name:"gradient" help:"Log2 fold change across the array of a fitted plane that warns and fails, as WARN,FAIL." default:"1,2"`
+	Block           string `name:"block" help:"Log2 fold change of the median of a block from the array that warns and fails, as WARN,FAIL." default:"1,2"`
+	Edge            string `name:"edge" help:"Log2 fold change of the spots on the edge of their block that warns and fails, as WARN,FAIL." default:"0.5,1"`
+}
+
+func main() {
+	var cli CLI
+	ctx := kong.Parse(&cli)
+
+	ctx.FatalIfErrorf(ctx.Validate())
+	ctx.FatalIfErrorf(work(&cli))
+}
+
+func work(cli *CLI) error {
+	var thresholds qc.Thresholds
+
+	for _, t := range []struct {
+		flag string
+		spec string
+		th   *qc.Threshold
+	}{
+		{"flagged", cli.Flagged, &thresholds.Flagged},
+		{"not-found", cli.NotFound, &thresholds.NotFound},
+		{"cv", cli.CV, &thresholds.CV},
+		{"saturated", cli.Saturated, &thresholds.Saturated},
+		{"background-level", cli.BackgroundLevel, &thresholds.Background},
+		{"negative-snr", cli.NegativeSNR, &thresholds.NegativeSNR},
+		{"gradient", cli.Gradient, &thresholds.Gradient},
+		{"block", cli.Block, &thresholds.Block},
+		{"edge", cli.Edge, &thresholds.Edge},
+	} {
+		th, err := qc.ParseThreshold(t.spec)
+		if err != nil {
+			return fmt.Errorf("--%s: %w", t.flag, err)
+		}
+
+		*t.th = th
+	}
+
+	limits := spatial.Limits{Gradient: thresholds.Gradient.Warn, Block: thresholds.Block.Warn, Edge: thresholds.Edge.Warn}
+
+	input, err := cli.Input.Parse()
+	if err != nil {
+		return err
+	}
+
+	var layout *gal.GAL
+	if cli.Layout != "" {
+		var err error
+
+		layout, err = gal.Read(cli.Layout)
+		if err != nil {
+			return err
+		}
+	}
+
+	fis, err := ioutil.ReadDir(cli.Dir)
+	if err != nil {
+		return err
+	}
+
+	var reports []qc.Report
+
+	for _, fi := range fis {
+		if strings.ToLower(filepath.Ext(fi.Name())) != ".gpr" {
+			continue
+		}
+
+		name := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+
+		g, err := read(filepath.Join(cli.Dir, fi.Name()), input, layout)
+		if err != nil {
+			log.Printf("%s: %v", fi.Name(), err)
+			reports = append(reports, qc.Report{Array: name, Failed: err.Error()})
+			continue
+		}
+
+		r := thresholds.Check(name, g)
+		reports = append(reports, r)
+
+		if problems := r.Problems(); len(problems) > 0 {
+			log.Printf("%s: %s (%s)", fi.Name(), r.Status(), strings.Join(problems, ", "))
+		}
+
+		for _, d := range r.Spatial {
+			for _, p := range d.Problems(limits) {
+				log.Printf("%s: %s", fi.Name(), p)
+			}
+		}
+	}
+
+	if len(reports) == 0 {
+		return fmt.Errorf("no gpr files in %s", cli.Dir)
+	}
+
+	if cli.Output == "" {
+		cli.Output = filepath.Join(cli.Dir, "qc.csv")
+	}
+
+	if strings.EqualFold(filepath.Ext(cli.Output), ".xlsx") {
+		if err := writeXLSX(cli.Output, reports, thresholds.Block); err != nil {
+			return err
+		}
+	} else {
+		if err := writeCSV(cli.Output, reports); err != nil {
+			return err
+		}
+
+		blocks := strings.TrimSuffix(cli.Output, filepath.Ext(cli.Output)) + "-blocks.csv"
+		if err := writeBlocksCSV(blocks, reports, thresholds.Block); err != nil {
+			return err
+		}
+	}
+
+	var failed int
+	for _, r := range reports {
+		if r.Status() == qc.Fail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d arrays failed QC", failed, len(reports))
+	}
+
+	return nil
+}
+
+// read reads an array with every spot, flagged spots are part of the
+// measure, keeping the channels of the labels.
+func read(path string, input options.Reading, layout *gal.GAL) (*gpr.GPR, error) {
+	g, err := gpr.Read(path, gpr.WithBackground(input.Background), gpr.WithRules(input.Rules))
+	if err != nil {
+		return nil, err
+	}
+
+	if layout != nil {
+		g, _ = gal.Join(g, layout)
+		g.Classify(input.Rules)
+	}
+
+	if err := input.Labels.Check(g); err != nil {
+		return nil, err
+	}
+
+	g.Wavelengths = nil
+	for _, l := range input.Labels {
+		g.Wavelengths = append(g.Wavelengths, l.Wavelength)
+	}
+
+	return spatial.Correct(g, input.Spatial), nil
+}
+
+// columns returns the metric names in the order they first appear.
+func columns(reports []qc.Report) []string {
+	var names []string
+	seen := map[string]bool{}
+
+	for _, r := range reports {
+		for _, m := range r.Metrics {
+			if !seen[m.String()] {
+				seen[m.String()] = true
+				names = append(names, m.String())
+			}
+		}
+	}
+
+	return names
+}
+
+// row returns the value of every metric of a report, in column order.
+func row(r qc.Report, names []string) []interface{} {
+	values := map[string]float64{}
+	for _, m := range r.Metrics {
+		values[m.String()] = m.Value
+	}
+
+	vs := make([]interface{}, len(names))
+	for i, n := range names {
+		if v, ok := values[n]; ok {
+			vs[i] = v
+		} else {
+			vs[i] = ""
+		}
+	}
+
+	return vs
+}
+
+func writeCSV(path string, reports []qc.Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	names := columns(reports)
+
+	out := csv.NewWriter(f)
+	out.Write(append(append([]string{"Array", "Status"}, names...), "Problems"))
+
+	for _, r := range reports {
+		line := []string{r.Array, r.Status().String()}
+		for _, v := range row(r, names) {
+			switch v := v.(type) {
+			case float64:
+				if math.IsNaN(v) {
+					line = append(line, "")
+				} else {
+					line = append(line, strconv.FormatFloat(v, 'g', -1, 64))
+				}
+			default:
+				line = append(line, fmt.Sprint(v))
+			}
+		}
+
+		out.Write(append(line, strings.Join(r.Problems(), "; ")))
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+var blockColumns = []string{"Array", "Wavelength", "Block", "Spots", "Median", "Log2 Ratio", "Status"}
+
+// blockRows returns the effect of every block of each array.
+func blockRows(reports []qc.Report, th qc.Threshold) [][]interface{} {
+	var rows [][]interface{}
+
+	for _, r := range reports {
+		for _, d := range r.Spatial {
+			for _, b := range d.Blocks {
+				rows = append(rows, []interface{}{r.Array, d.Wavelength, b.Block, b.N, b.Median, b.LogRatio, th.Judge(math.Abs(b.LogRatio)).String()})
+			}
+		}
+	}
+
+	return rows
+}
+
+func writeBlocksCSV(path string, reports []qc.Report, th qc.Threshold) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(f)
+	out.Write(blockColumns)
+
+	for _, row := range blockRows(reports, th) {
+		line := make([]string, len(row))
+		for i, v := range row {
+			line[i] = fmt.Sprint(v)
+		}
+
+		out.Write(line)
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeXLSX(path string, reports []qc.Report, block qc.Threshold) error {
+	spreadsheet := xlsx.NewFile()
+
+	sheet, err := spreadsheet.AddSheet("QC")
+	if err != nil {
+		return err
+	}
+
+	names := columns(reports)
+
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("Array", "Status")
+
+	for _, n := range names {
+		apndr.Append(n)
+	}
+
+	apndr.Append("Problems")
+	apndr.NewRow()
+
+	for _, r := range reports {
+		apndr.Append(r.Array, r.Status().String())
+		apndr.Append(row(r, names)...)
+		apndr.Append(strings.Join(r.Problems(), "; "))
+		apndr.NewRow()
+	}
+
+	sheet, err = spreadsheet.AddSheet("Blocks")
+	if err != nil {
+		return err
+	}
+
+	apndr = appender.NewRowAppender(sheet)
+	for _, c := range blockColumns {
+		apndr.Append(c)
+	}
+
+	apndr.NewRow()
+
+	for _, row := range blockRows(reports, block) {
+		apndr.Append(row...)
+		apndr.NewRow()
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := spreadsheet.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
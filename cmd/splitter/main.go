@@ -38,6 +38,8 @@ var (
 type CLI struct {
 	Dir      string   `arg:"" name:"dir" help:"Directory containing tiff and gpr files." type:"existingdir" default:"."`
 	Proteins []string `name:"proteins" help:"List of proteins to get, get all if empty." optional:""`
+	Rules    string   `name:"rules" help:"Rules classifying control and excluded spots: legacy excludes rows 17-18 and empty or blank IDs, default only excludes empty or blank IDs, or give a rules file." default:"legacy"`
+	Labels   []string `name:"label" help:"Reagent detected at each wavelength, as NAME=WAVELENGTH." default:"IgG=550,IgM=650"`
 }
 
 func main() {
@@ -46,6 +48,12 @@ func main() {
 
 	ctx.FatalIfErrorf(ctx.Validate())
 
+	labels, err := gpr.ParseLabels(cli.Labels)
+	ctx.FatalIfErrorf(err)
+
+	rules, err := gpr.LoadRules(cli.Rules)
+	ctx.FatalIfErrorf(err)
+
 	fis, err := ioutil.ReadDir(cli.Dir)
 	ctx.FatalIfErrorf(err)
 
@@ -95,11 +103,24 @@ func main() {
 
 			defer tiff.Close()
 
-			data, err := gpr.Read(gprPath)
+			data, err := gpr.Read(gprPath, gpr.WithRules(rules))
 			if err != nil {
 				return err
 			}
 
+			data, _ = data.Filter(gpr.SamplesOnly)
+
+			pixelSize, err := data.Header.PixelSize()
+			if err != nil {
+				return fmt.Errorf("could not get pixel size for '%s': %w", fi.Name(), err)
+			}
+
+			// tiff pages are stored in the order of the header wavelengths
+			pages, err := data.Header.Wavelengths()
+			if err != nil {
+				pages = data.Wavelengths
+			}
+
 			brightness, contrast, err := gps.BrightnessContrast(gpsPath)
 			if err != nil {
 				return err
@@ -112,9 +133,9 @@ func main() {
 				var c int64
 
 				if len(selectedProteins) > 0 {
-					c = int64(len(selectedProteins)) * int64(2) * int64(len(newfis))
+					c = int64(len(selectedProteins)) * int64(len(labels)) * int64(len(newfis))
 				} else {
-					c = int64(len(proteins)) * int64(2) * int64(len(newfis))
+					c = int64(len(proteins)) * int64(len(labels)) * int64(len(newfis))
 				}
 
 				bar = pb.Start64(c)
@@ -130,20 +151,17 @@ func main() {
 				w, h := newimg.Bounds().Max.X, newimg.Bounds().Max.Y
 
 				const M = 1<<16 - 1
-				var m color.Model
-				var t string
-
-				switch n {
-				case 0:
-					m = colr.MonoRed64Model
-					t = "IgM"
-				case 1:
-					m = colr.MonoGreen64Model
-					t = "IgG"
-				default:
+				if n >= len(pages) {
 					return
 				}
 
+				t := labels.Name(pages[n])
+				if t == "" {
+					return
+				}
+
+				m := colorModel(pages[n])
+
 				dir := filepath.Join(outdir, t)
 				if err := os.MkdirAll(dir, 0755); err != nil {
 					fmt.Println(err)
@@ -172,9 +190,13 @@ func main() {
 						}
 					}
 
+					if len(spots) < 2 {
+						continue
+					}
+
 					r1, r2 := spots[0].Diameter/2, spots[1].Diameter/2
-					x1, y1 := (spots[0].X-r1)/10, (spots[0].Y-r1)/10
-					x2, y2 := (spots[1].X+r2)/10, (spots[1].Y+r2)/10
+					x1, y1 := toPixels(spots[0].X-r1, pixelSize), toPixels(spots[0].Y-r1, pixelSize)
+					x2, y2 := toPixels(spots[1].X+r2, pixelSize), toPixels(spots[1].Y+r2, pixelSize)
 					rect := image.Rect(x1-paddingx, y1-paddingy, x2+paddingx, y2+paddingy)
 
 					x := copyImg(s.SubImage(rect).(*image.RGBA))
@@ -203,6 +225,21 @@ func main() {
 	}
 }
 
+// colorModel returns the colour used to render a channel, red for the
+// longer wavelengths and green for the shorter.
+func colorModel(wavelength int) color.Model {
+	if wavelength >= 600 {
+		return colr.MonoRed64Model
+	}
+
+	return colr.MonoGreen64Model
+}
+
+// toPixels converts a gpr coordinate in micrometres to an image coordinate.
+func toPixels(um int, pixelSize float64) int {
+	return int(float64(um) / pixelSize)
+}
+
 func copyImg(src *image.RGBA) *image.RGBA {
 	origRect := src.Bounds()
 	newRect := image.Rect(0, 0, origRect.Dx(), origRect.Dy())
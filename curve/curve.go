@@ -0,0 +1,428 @@
+// Package curve fits standard curves and converts intensities to
+// concentrations.
+package curve
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Model is the form of logistic curve fitted.
+type Model int
+
+const (
+	FourPL Model = iota + 4
+	FivePL
+)
+
+func (m Model) String() string {
+	switch m {
+	case FourPL:
+		return "4pl"
+	case FivePL:
+		return "5pl"
+	default:
+		return ""
+	}
+}
+
+func ParseModel(s string) (Model, error) {
+	switch strings.ToLower(s) {
+	case "4pl":
+		return FourPL, nil
+	case "5pl":
+		return FivePL, nil
+	default:
+		return 0, fmt.Errorf("unknown curve model %q", s)
+	}
+}
+
+// ErrTooFewStandards is returned when there are too few concentrations to fit.
+var ErrTooFewStandards = errors.New("too few standard concentrations")
+
+// Point is the signal measured for a standard of known concentration.
+type Point struct {
+	Concentration float64
+	Signal        float64
+}
+
+// Curve is a fitted logistic curve
+//
+//	signal = D + (A - D) / (1 + (concentration / C)^B)^G
+//
+// where G is 1 for the four parameter model.
+type Curve struct {
+	Model Model
+
+	// A and D are the signals at zero and infinite concentration.
+	A, D float64
+	// B is the slope and C the inflection concentration.
+	B, C float64
+	// G is the asymmetry of the five parameter model.
+	G float64
+
+	// LLOQ and ULOQ are the limits of quantification.
+	LLOQ, ULOQ float64
+}
+
+// Signal returns the signal the curve predicts for a concentration.
+func (c Curve) Signal(concentration float64) float64 {
+	return c.D + (c.A-c.D)/math.Pow(1+math.Pow(concentration/c.C, c.B), c.G)
+}
+
+// Concentration returns the concentration giving the signal, or NaN.
+func (c Curve) Concentration(signal float64) float64 {
+	r := (c.A - c.D) / (signal - c.D)
+	if r <= 0 {
+		return math.NaN()
+	}
+
+	inner := math.Pow(r, 1/c.G) - 1
+	if inner <= 0 {
+		return math.NaN()
+	}
+
+	return c.C * math.Pow(inner, 1/c.B)
+}
+
+// Quantifiable reports whether a concentration lies within LLOQ and ULOQ.
+func (c Curve) Quantifiable(concentration float64) bool {
+	return concentration >= c.LLOQ && concentration <= c.ULOQ
+}
+
+func (c Curve) String() string {
+	s := fmt.Sprintf("%s A=%.4g B=%.4g C=%.4g D=%.4g", c.Model, c.A, c.B, c.C, c.D)
+	if c.Model == FivePL {
+		s += fmt.Sprintf(" G=%.4g", c.G)
+	}
+
+	return s
+}
+
+// params maps the fitted parameters to a curve, C and G are fitted as logs.
+func params(m Model, p []float64) Curve {
+	c := Curve{Model: m, A: p[0], B: p[1], C: math.Exp(p[2]), D: p[3], G: 1}
+	if m == FivePL {
+		c.G = math.Exp(p[4])
+	}
+
+	return c
+}
+
+// Fit fits the model to the points by least squares and sets the limits of
+// quantification to the standards recovered within 20%.
+func Fit(points []Point, m Model) (Curve, error) {
+	levels := map[float64][]float64{}
+	for _, p := range points {
+		if !math.IsNaN(p.Signal) {
+			levels[p.Concentration] = append(levels[p.Concentration], p.Signal)
+		}
+	}
+
+	if len(levels) < int(m) {
+		return Curve{}, fmt.Errorf("%d concentrations for a %s curve: %w", len(levels), m, ErrTooFewStandards)
+	}
+
+	concs := make([]float64, 0, len(levels))
+	var signals, positive []float64
+
+	for conc, ys := range levels {
+		concs = append(concs, conc)
+		signals = append(signals, ys...)
+
+		if conc > 0 {
+			positive = append(positive, conc)
+		}
+	}
+
+	if len(positive) < int(m)-1 {
+		return Curve{}, fmt.Errorf("%d positive concentrations for a %s curve: %w", len(positive), m, ErrTooFewStandards)
+	}
+
+	sort.Float64s(concs)
+
+	lo, hi := stats.Median(levels[concs[0]]), stats.Median(levels[concs[len(concs)-1]])
+	p := []float64{lo, 1, math.Log(stats.Median(positive)), hi}
+	if m == FivePL {
+		p = append(p, 0)
+	}
+
+	scale := stats.SD(signals)
+	if !(scale > 0) {
+		return Curve{}, errors.New("standards have no variation in signal")
+	}
+
+	residuals := func(p []float64) []float64 {
+		c := params(m, p)
+		r := make([]float64, 0, len(points))
+
+		for _, pt := range points {
+			if !math.IsNaN(pt.Signal) {
+				r = append(r, (pt.Signal-c.Signal(math.Max(pt.Concentration, 0)))/scale)
+			}
+		}
+
+		return r
+	}
+
+	p = levenbergMarquardt(residuals, p)
+
+	c := params(m, p)
+	for _, v := range []float64{c.A, c.B, c.C, c.D, c.G} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Curve{}, errors.New("curve did not converge")
+		}
+	}
+
+	c.LLOQ, c.ULOQ = math.NaN(), math.NaN()
+
+	for _, conc := range concs {
+		if conc <= 0 {
+			continue
+		}
+
+		recovered := c.Concentration(stats.Median(levels[conc])) / conc
+		if recovered < 0.8 || recovered > 1.2 {
+			continue
+		}
+
+		if math.IsNaN(c.LLOQ) {
+			c.LLOQ = conc
+		}
+
+		c.ULOQ = conc
+	}
+
+	return c, nil
+}
+
+// levenbergMarquardt minimises the sum of squared residuals from p.
+func levenbergMarquardt(residuals func([]float64) []float64, p []float64) []float64 {
+	const (
+		maxIter = 500
+		tol     = 1e-12
+	)
+
+	n := len(p)
+	lambda := 1e-3
+
+	r := residuals(p)
+	cost := sumSquares(r)
+
+	for iter := 0; iter < maxIter; iter++ {
+		jac := make([][]float64, n)
+
+		for j := range p {
+			h := 1e-6 * math.Max(math.Abs(p[j]), 1)
+			q := append([]float64(nil), p...)
+			q[j] += h
+
+			rq := residuals(q)
+			jac[j] = make([]float64, len(r))
+			for i := range r {
+				jac[j][i] = (rq[i] - r[i]) / h
+			}
+		}
+
+		jtj := make([][]float64, n)
+		jtr := make([]float64, n)
+
+		for a := 0; a < n; a++ {
+			jtj[a] = make([]float64, n)
+			for b := 0; b < n; b++ {
+				for i := range r {
+					jtj[a][b] += jac[a][i] * jac[b][i]
+				}
+			}
+
+			for i := range r {
+				jtr[a] -= jac[a][i] * r[i]
+			}
+		}
+
+		accepted := false
+
+		for lambda < 1e12 && !accepted {
+			m := make([][]float64, n)
+			for a := range m {
+				m[a] = append([]float64(nil), jtj[a]...)
+				m[a][a] += lambda * math.Max(jtj[a][a], 1e-12)
+			}
+
+			step, ok := solve(m, jtr)
+			if !ok {
+				lambda *= 10
+				continue
+			}
+
+			q := make([]float64, n)
+			for j := range p {
+				q[j] = p[j] + step[j]
+			}
+
+			rq := residuals(q)
+			c := sumSquares(rq)
+
+			if c >= cost {
+				lambda *= 10
+				continue
+			}
+
+			converged := cost-c < tol*(1+cost)
+			p, r, cost = q, rq, c
+			lambda = math.Max(lambda/10, 1e-12)
+
+			if converged {
+				return p
+			}
+
+			accepted = true
+		}
+
+		if !accepted {
+			return p
+		}
+	}
+
+	return p
+}
+
+func sumSquares(r []float64) float64 {
+	var s float64
+	for _, x := range r {
+		s += x * x
+	}
+
+	if math.IsNaN(s) {
+		return math.Inf(1)
+	}
+
+	return s
+}
+
+// solve solves m x = b by Gaussian elimination with partial pivoting.
+func solve(m [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = append(append([]float64(nil), m[i]...), b[i])
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(a[pivot][col]) < 1e-300 {
+			return nil, false
+		}
+
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		s := a[i][n]
+		for k := i + 1; k < n; k++ {
+			s -= a[i][k] * x[k]
+		}
+
+		x[i] = s / a[i][i]
+	}
+
+	return x, true
+}
+
+// Standards returns the positive controls selected by match, with their
+// concentration taken from the named annotation.
+func Standards(g *gpr.GPR, wavelength int, annotation string, match func(gpr.Row) bool) []Point {
+	var points []Point
+
+	for _, row := range g.Rows {
+		if row.Control != gpr.PositiveControl || !match(row) {
+			continue
+		}
+
+		conc, err := strconv.ParseFloat(strings.TrimSpace(row.Annotations[annotation]), 64)
+		if err != nil {
+			continue
+		}
+
+		points = append(points, Point{Concentration: conc, Signal: row.Channel(wavelength).MedianMinusBackground})
+	}
+
+	return points
+}
+
+// Estimate returns the concentration for a signal, and a note when it is not
+// quantifiable.
+func (c Curve) Estimate(signal float64) (float64, string) {
+	conc := c.Concentration(signal)
+
+	switch {
+	case math.IsNaN(conc):
+		return conc, "out of range"
+	case math.IsNaN(c.LLOQ) || math.IsNaN(c.ULOQ):
+		return conc, "not quantifiable"
+	case !(conc >= c.LLOQ):
+		return conc, "below LLOQ"
+	case !(conc <= c.ULOQ):
+		return conc, "above ULOQ"
+	default:
+		return conc, ""
+	}
+}
+
+// DefaultStandards matches standards with an ID starting with the label.
+const DefaultStandards = "^{label}"
+
+// FitLabels fits a curve for each label from the standards matching pattern,
+// where {label} stands for its name.
+func FitLabels(g *gpr.GPR, labels gpr.Labels, annotation, pattern string, m Model) (map[int]Curve, map[int]error) {
+	curves := map[int]Curve{}
+	errs := map[int]error{}
+
+	for _, label := range labels {
+		if !g.HasWavelength(label.Wavelength) {
+			continue
+		}
+
+		re, err := regexp.Compile("(?i)" + strings.ReplaceAll(pattern, "{label}", regexp.QuoteMeta(label.Name)))
+		if err != nil {
+			errs[label.Wavelength] = fmt.Errorf("%s standards: %w", label.Name, err)
+			continue
+		}
+
+		points := Standards(g, label.Wavelength, annotation, func(r gpr.Row) bool {
+			return re.MatchString(r.ID)
+		})
+
+		c, err := Fit(points, m)
+		if err != nil {
+			errs[label.Wavelength] = fmt.Errorf("%s standards: %w", label.Name, err)
+			continue
+		}
+
+		curves[label.Wavelength] = c
+	}
+
+	return curves, errs
+}
@@ -0,0 +1,144 @@
+package curve
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func series(c Curve, concs ...float64) []Point {
+	var points []Point
+	for _, conc := range concs {
+		y := c.Signal(conc)
+		points = append(points, Point{Concentration: conc, Signal: y * 1.01}, Point{Concentration: conc, Signal: y * 0.99})
+	}
+
+	return points
+}
+
+func Test_Fit(t *testing.T) {
+	tests := []struct {
+		model Model
+		curve Curve
+	}{
+		{FourPL, Curve{A: 100, B: 1.5, C: 50, D: 40000, G: 1}},
+		{FivePL, Curve{A: 200, B: 1.2, C: 30, D: 50000, G: 0.6}},
+	}
+
+	for _, tt := range tests {
+		points := series(tt.curve, 0.5, 1.5, 5, 15, 50, 150, 500, 1500, 5000)
+
+		c, err := Fit(points, tt.model)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.model, err)
+		}
+
+		for _, conc := range []float64{2, 20, 200} {
+			if got := c.Concentration(tt.curve.Signal(conc)); math.Abs(got-conc)/conc > 0.05 {
+				t.Errorf("%s: expected concentration %v, got %v (%s)", tt.model, conc, got, c)
+			}
+		}
+
+		if !(c.LLOQ <= 1.5) || !(c.ULOQ >= 1500) {
+			t.Errorf("%s: unexpected limits of quantification %v-%v", tt.model, c.LLOQ, c.ULOQ)
+		}
+
+		if !c.Quantifiable(100) || c.Quantifiable(1e6) {
+			t.Errorf("%s: unexpected quantifiable range %v-%v", tt.model, c.LLOQ, c.ULOQ)
+		}
+	}
+
+	if _, err := Fit(series(tests[0].curve, 1, 10, 100), FourPL); !errors.Is(err, ErrTooFewStandards) {
+		t.Errorf("expected too few standards, got %v", err)
+	}
+
+	blanks := []Point{{Concentration: -1, Signal: 100}, {Concentration: 0, Signal: 110}, {Concentration: 10, Signal: 500}, {Concentration: 100, Signal: 4000}}
+	if _, err := Fit(blanks, FourPL); !errors.Is(err, ErrTooFewStandards) || !strings.Contains(err.Error(), "2 positive") {
+		t.Errorf("expected too few positive standards, got %v", err)
+	}
+}
+
+func Test_Concentration_OutOfRange(t *testing.T) {
+	c := Curve{Model: FourPL, A: 100, B: 1, C: 50, D: 40000, G: 1}
+
+	if x := c.Concentration(50); !math.IsNaN(x) {
+		t.Errorf("expected NaN below the lower asymptote, got %v", x)
+	}
+
+	if x := c.Concentration(50000); !math.IsNaN(x) {
+		t.Errorf("expected NaN above the upper asymptote, got %v", x)
+	}
+
+	if x := c.Concentration(c.Signal(50)); math.Abs(x-50) > 1e-9 {
+		t.Errorf("expected inflection concentration, got %v", x)
+	}
+}
+
+func Test_Standards(t *testing.T) {
+	g := &gpr.GPR{
+		Wavelengths: []int{550},
+		Rows: []gpr.Row{
+			{ID: "IgG", Control: gpr.PositiveControl, Annotations: map[string]string{"Concentration": "100"}, Channels: []gpr.Channel{{Wavelength: 550, MedianMinusBackground: 5000}}},
+			{ID: "IgM", Control: gpr.PositiveControl, Annotations: map[string]string{"Concentration": "50"}, Channels: []gpr.Channel{{Wavelength: 550, MedianMinusBackground: 20}}},
+			{ID: "IgG", Control: gpr.PositiveControl, Annotations: map[string]string{"Concentration": ""}},
+			{ID: "IgG", Annotations: map[string]string{"Concentration": "10"}},
+		},
+	}
+
+	points := Standards(g, 550, "Concentration", func(r gpr.Row) bool { return r.ID == "IgG" })
+	if len(points) != 1 || points[0].Concentration != 100 || points[0].Signal != 5000 {
+		t.Errorf("unexpected standards: %v", points)
+	}
+}
+
+func Test_FitLabels(t *testing.T) {
+	igg := Curve{A: 100, B: 1.5, C: 50, D: 40000, G: 1}
+	g := &gpr.GPR{Wavelengths: []int{550, 650}}
+
+	for i, p := range series(igg, 1, 5, 25, 125, 625) {
+		g.Rows = append(g.Rows, gpr.Row{
+			ID:          "IgG",
+			Row:         i,
+			Control:     gpr.PositiveControl,
+			Annotations: map[string]string{"Concentration": strconv.FormatFloat(p.Concentration, 'g', -1, 64)},
+			Channels:    []gpr.Channel{{Wavelength: 550, MedianMinusBackground: p.Signal}, {Wavelength: 650, MedianMinusBackground: 10}},
+		})
+	}
+
+	curves, errs := FitLabels(g, gpr.Labels{{Name: "IgG", Wavelength: 550}, {Name: "IgM", Wavelength: 650}, {Name: "IgA", Wavelength: 488}}, "Concentration", DefaultStandards, FourPL)
+	if _, ok := curves[550]; !ok || len(curves) != 1 {
+		t.Fatalf("expected an IgG curve, got %v %v", curves, errs)
+	}
+
+	if !errors.Is(errs[650], ErrTooFewStandards) || len(errs) != 1 {
+		t.Errorf("expected IgM to have too few standards, got %v", errs)
+	}
+
+	if conc, note := curves[550].Estimate(igg.Signal(25)); math.Abs(conc-25) > 1 || note != "" {
+		t.Errorf("unexpected estimate %v %q", conc, note)
+	}
+
+	if _, note := curves[550].Estimate(igg.Signal(0.1)); note != "below LLOQ" {
+		t.Errorf("expected estimate below LLOQ, got %q", note)
+	}
+
+	unrecovered := curves[550]
+	unrecovered.LLOQ, unrecovered.ULOQ = math.NaN(), math.NaN()
+
+	if _, note := unrecovered.Estimate(igg.Signal(25)); note != "not quantifiable" {
+		t.Errorf("expected estimate to be not quantifiable, got %q", note)
+	}
+
+	curves, _ = FitLabels(g, gpr.Labels{{Name: "IgG", Wavelength: 550}}, "Concentration", "^std-{label}$", FourPL)
+	if len(curves) != 0 {
+		t.Errorf("expected no standards to match, got %v", curves)
+	}
+
+	if _, errs := FitLabels(g, gpr.Labels{{Name: "IgG", Wavelength: 550}}, "Concentration", "(", FourPL); errs[550] == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
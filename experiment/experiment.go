@@ -0,0 +1,238 @@
+// Package experiment holds the arrays of an experiment with their samples.
+package experiment
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Metadata describes the sample hybridised to an array.
+type Metadata struct {
+	SampleID  string
+	Group     string
+	Timepoint string
+	Slide     string
+	ScanDate  time.Time
+}
+
+type Array struct {
+	Name string
+	Data *gpr.GPR
+	Meta Metadata
+}
+
+type Experiment struct {
+	Arrays []Array
+}
+
+// Add adds an array, dated from its header when the date is not set.
+func (e *Experiment) Add(name string, g *gpr.GPR, meta Metadata) {
+	if meta.ScanDate.IsZero() {
+		if t, err := g.Header.DateTime(); err == nil {
+			meta.ScanDate = t
+		}
+	}
+
+	e.Arrays = append(e.Arrays, Array{Name: name, Data: g, Meta: meta})
+}
+
+// Index returns the position of the named array, or -1.
+func (e *Experiment) Index(name string) int {
+	for i, a := range e.Arrays {
+		if a.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Get returns the named array.
+func (e *Experiment) Get(name string) (Array, bool) {
+	i := e.Index(name)
+	if i < 0 {
+		return Array{}, false
+	}
+
+	return e.Arrays[i], true
+}
+
+// Groups returns the group names in the order they first appear.
+func (e *Experiment) Groups() []string {
+	var groups []string
+	seen := map[string]bool{}
+
+	for _, a := range e.Arrays {
+		if !seen[a.Meta.Group] {
+			seen[a.Meta.Group] = true
+			groups = append(groups, a.Meta.Group)
+		}
+	}
+
+	return groups
+}
+
+// Group returns an experiment holding the arrays in the named group.
+func (e *Experiment) Group(name string) *Experiment {
+	out := &Experiment{}
+
+	for _, a := range e.Arrays {
+		if a.Meta.Group == name {
+			out.Arrays = append(out.Arrays, a)
+		}
+	}
+
+	return out
+}
+
+// Map returns an experiment with f applied to the data of every array.
+func (e *Experiment) Map(f func(*gpr.GPR) *gpr.GPR) *Experiment {
+	out := &Experiment{Arrays: make([]Array, len(e.Arrays))}
+
+	for i, a := range e.Arrays {
+		a.Data = f(a.Data)
+		out.Arrays[i] = a
+	}
+
+	return out
+}
+
+// Data returns the data of every array in order.
+func (e *Experiment) Data() []*gpr.GPR {
+	gs := make([]*gpr.GPR, len(e.Arrays))
+	for i, a := range e.Arrays {
+		gs[i] = a.Data
+	}
+
+	return gs
+}
+
+// Metric extracts a value from a row.
+type Metric func(gpr.Row) float64
+
+func MedianMinusBackground(wavelength int) Metric {
+	return func(r gpr.Row) float64 {
+		return r.Channel(wavelength).MedianMinusBackground
+	}
+}
+
+func MeanMinusBackground(wavelength int) Metric {
+	return func(r gpr.Row) float64 {
+		return r.Channel(wavelength).MeanMinusBackground
+	}
+}
+
+func SNR(wavelength int) Metric {
+	return func(r gpr.Row) float64 {
+		return r.Channel(wavelength).SNR
+	}
+}
+
+// Matrix holds a metric by protein and array, NaN where a protein is missing.
+type Matrix struct {
+	Proteins []string
+	Arrays   []string
+	Values   [][]float64
+
+	present [][]bool
+}
+
+// Gap is a protein missing from an array.
+type Gap struct {
+	Protein string
+	Array   string
+}
+
+func (g Gap) String() string {
+	return fmt.Sprintf("%s missing from %s", g.Protein, g.Array)
+}
+
+// Matrix returns the metric of every protein by ID, averaging replicates.
+func (e *Experiment) Matrix(m Metric) *Matrix {
+	values := make([]map[string][]float64, len(e.Arrays))
+	ids := map[string]bool{}
+
+	for j, a := range e.Arrays {
+		values[j] = map[string][]float64{}
+
+		for _, row := range a.Data.Rows {
+			values[j][row.ID] = append(values[j][row.ID], m(row))
+			ids[row.ID] = true
+		}
+	}
+
+	mx := &Matrix{Arrays: make([]string, len(e.Arrays))}
+	for j, a := range e.Arrays {
+		mx.Arrays[j] = a.Name
+	}
+
+	for id := range ids {
+		mx.Proteins = append(mx.Proteins, id)
+	}
+
+	sort.Strings(mx.Proteins)
+
+	mx.Values = make([][]float64, len(mx.Proteins))
+	mx.present = make([][]bool, len(mx.Proteins))
+
+	for i, id := range mx.Proteins {
+		mx.Values[i] = make([]float64, len(e.Arrays))
+		mx.present[i] = make([]bool, len(e.Arrays))
+
+		for j := range e.Arrays {
+			xs, ok := values[j][id]
+			if !ok {
+				mx.Values[i][j] = math.NaN()
+				continue
+			}
+
+			mx.present[i][j] = true
+			mx.Values[i][j] = xs[0]
+			if len(xs) > 1 {
+				mx.Values[i][j] = stats.Mean(xs)
+			}
+		}
+	}
+
+	return mx
+}
+
+// Has reports whether protein i was present in array j.
+func (m *Matrix) Has(i, j int) bool {
+	return m.present[i][j]
+}
+
+// Gaps lists the proteins missing from each array.
+func (m *Matrix) Gaps() []Gap {
+	var gaps []Gap
+
+	for j, a := range m.Arrays {
+		for i, p := range m.Proteins {
+			if !m.present[i][j] {
+				gaps = append(gaps, Gap{Protein: p, Array: a})
+			}
+		}
+	}
+
+	return gaps
+}
+
+// Row returns the values of protein i across the arrays.
+func (m *Matrix) Row(i int) []float64 {
+	return m.Values[i]
+}
+
+// Column returns the values of every protein in array j.
+func (m *Matrix) Column(j int) []float64 {
+	col := make([]float64, len(m.Proteins))
+	for i := range m.Proteins {
+		col[i] = m.Values[i][j]
+	}
+
+	return col
+}
@@ -0,0 +1,77 @@
+package experiment
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func array(values map[string]float64) *gpr.GPR {
+	g := &gpr.GPR{Wavelengths: []int{635}}
+	for id, v := range values {
+		g.Rows = append(g.Rows, gpr.Row{ID: id, Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: v}}})
+	}
+
+	return g
+}
+
+func Test_Matrix(t *testing.T) {
+	var e Experiment
+	e.Add("1", array(map[string]float64{"A": 1, "B": 2}), Metadata{SampleID: "S1", Group: "control"})
+	e.Add("2", array(map[string]float64{"A": 3, "C": 4}), Metadata{SampleID: "S2", Group: "treated"})
+	e.Add("3", array(map[string]float64{"B": 5, "C": 6}), Metadata{SampleID: "S3", Group: "control"})
+
+	m := e.Matrix(MedianMinusBackground(635))
+
+	if len(m.Proteins) != 3 || m.Proteins[0] != "A" || m.Proteins[2] != "C" {
+		t.Fatalf("unexpected proteins %v", m.Proteins)
+	}
+
+	if m.Values[0][1] != 3 || m.Values[2][2] != 6 || !math.IsNaN(m.Values[0][2]) || m.Has(0, 2) || !m.Has(0, 1) {
+		t.Errorf("unexpected values %v", m.Values)
+	}
+
+	gaps := m.Gaps()
+	if len(gaps) != 3 || gaps[0] != (Gap{Protein: "C", Array: "1"}) {
+		t.Errorf("unexpected gaps %v", gaps)
+	}
+
+	if col := m.Column(1); col[0] != 3 || !math.IsNaN(col[1]) || col[2] != 4 {
+		t.Errorf("unexpected column %v", col)
+	}
+
+	if groups := e.Groups(); len(groups) != 2 || groups[0] != "control" {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	if g := e.Group("control"); len(g.Arrays) != 2 || g.Arrays[1].Meta.SampleID != "S3" {
+		t.Errorf("unexpected group %+v", g)
+	}
+}
+
+func Test_Add_ScanDate(t *testing.T) {
+	g, err := gpr.Read(filepath.Join("..", "gpr", "testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Experiment
+	e.Add("test1", g, Metadata{})
+	e.Add("dated", g, Metadata{ScanDate: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)})
+
+	expected, err := g.Header.DateTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a, _ := e.Get("test1"); !a.Meta.ScanDate.Equal(expected) {
+		t.Errorf("expected scan date from header, got %v", a.Meta.ScanDate)
+	}
+
+	if a, _ := e.Get("dated"); a.Meta.ScanDate.Year() != 2020 {
+		t.Errorf("expected scan date to be kept, got %v", a.Meta.ScanDate)
+	}
+}
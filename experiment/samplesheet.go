@@ -0,0 +1,321 @@
+package experiment
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	colArray     = "Array"
+	colFile      = "File"
+	colSample    = "Sample"
+	colGroup     = "Group"
+	colSheet     = "Sheet"
+	colPair      = "Pair"
+	colTimepoint = "Timepoint"
+)
+
+var sheetColumns = []string{colArray, colFile, colSample, colGroup, colSheet, colPair, colTimepoint}
+
+// Sample is a row of a sample sheet, describing one array.
+type Sample struct {
+	// Array names the array, read from "*No.ARRAY.gpr" without a File.
+	Array string
+	File  string
+
+	SampleID  string
+	Group     string
+	Timepoint string
+
+	// Sheet is the sheet the array is written to, the group if empty.
+	Sheet string
+
+	// Pair names the array this one is compared against.
+	Pair string
+}
+
+// Comparison is an array written to a sheet, with the array it is paired with.
+type Comparison struct {
+	Sheet string
+	Left  string
+	Right string
+}
+
+// SampleSheet describes the arrays of an experiment.
+type SampleSheet struct {
+	Samples []Sample
+}
+
+// Lookup returns the sample of the named array.
+func (s *SampleSheet) Lookup(array string) (Sample, bool) {
+	for _, sample := range s.Samples {
+		if sample.Array == array {
+			return sample, true
+		}
+	}
+
+	return Sample{}, false
+}
+
+// Sheets returns the sheet titles in the order they first appear.
+func (s *SampleSheet) Sheets() []string {
+	var sheets []string
+	seen := map[string]bool{}
+
+	for _, sample := range s.Samples {
+		if !seen[sample.Sheet] {
+			seen[sample.Sheet] = true
+			sheets = append(sheets, sample.Sheet)
+		}
+	}
+
+	return sheets
+}
+
+// Comparisons returns the arrays to write in sheet order, paired arrays
+// only alongside their pair.
+func (s *SampleSheet) Comparisons() []Comparison {
+	paired := map[string]bool{}
+	for _, sample := range s.Samples {
+		if sample.Pair != "" {
+			paired[sample.Pair] = true
+		}
+	}
+
+	var comparisons []Comparison
+
+	for _, sheet := range s.Sheets() {
+		for _, sample := range s.Samples {
+			if sample.Sheet == sheet && !paired[sample.Array] {
+				comparisons = append(comparisons, Comparison{Sheet: sheet, Left: sample.Array, Right: sample.Pair})
+			}
+		}
+	}
+
+	return comparisons
+}
+
+// Metadata returns the metadata of the sample read from slide.
+func (s Sample) Metadata(slide string) Metadata {
+	return Metadata{
+		SampleID:  s.SampleID,
+		Group:     s.Group,
+		Timepoint: s.Timepoint,
+		Slide:     slide,
+	}
+}
+
+// Path returns the file holding the array, relative to dir.
+func (s Sample) Path(dir string) (string, error) {
+	if s.File != "" {
+		if filepath.IsAbs(s.File) {
+			return s.File, nil
+		}
+
+		return filepath.Join(dir, s.File), nil
+	}
+
+	for _, pattern := range []string{"*No.%s.gpr", "*No. %s.gpr"} {
+		matches, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf(pattern, s.Array)))
+		if err != nil {
+			return "", err
+		}
+
+		if len(matches) > 1 {
+			return "", fmt.Errorf("array %s: %d files match %q", s.Array, len(matches), fmt.Sprintf(pattern, s.Array))
+		}
+
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("array %s: no file found", s.Array)
+}
+
+// ParseSampleSheet reads a CSV sample sheet with Array and Group columns.
+func ParseSampleSheet(r io.Reader) (*SampleSheet, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("empty sample sheet")
+		}
+
+		return nil, err
+	}
+
+	idx := map[string]int{}
+	for i, c := range header {
+		idx[strings.TrimSpace(c)] = i
+	}
+
+	for _, c := range []string{colArray, colGroup} {
+		if _, ok := idx[c]; !ok {
+			return nil, fmt.Errorf("missing column %q", c)
+		}
+	}
+
+	s := &SampleSheet{}
+
+	for n := 1; ; n++ {
+		line, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, err
+		}
+
+		get := func(col string) string {
+			if i, ok := idx[col]; ok && i < len(line) {
+				return strings.TrimSpace(line[i])
+			}
+
+			return ""
+		}
+
+		sample := Sample{
+			Array:     get(colArray),
+			File:      get(colFile),
+			SampleID:  get(colSample),
+			Group:     get(colGroup),
+			Timepoint: get(colTimepoint),
+			Sheet:     get(colSheet),
+			Pair:      get(colPair),
+		}
+
+		if sample.Array == "" {
+			continue
+		}
+
+		if _, ok := s.Lookup(sample.Array); ok {
+			return nil, fmt.Errorf("row %d: duplicate array %q", n, sample.Array)
+		}
+
+		if sample.Group == "" {
+			return nil, fmt.Errorf("row %d: array %q has no group", n, sample.Array)
+		}
+
+		if sample.SampleID == "" {
+			sample.SampleID = "No." + sample.Array
+		}
+
+		if sample.Sheet == "" {
+			sample.Sheet = sample.Group
+		}
+
+		s.Samples = append(s.Samples, sample)
+	}
+
+	for _, sample := range s.Samples {
+		if sample.Pair == "" {
+			continue
+		}
+
+		if sample.Pair == sample.Array {
+			return nil, fmt.Errorf("array %q is paired with itself", sample.Array)
+		}
+
+		pair, ok := s.Lookup(sample.Pair)
+		if !ok {
+			return nil, fmt.Errorf("array %q is paired with unknown array %q", sample.Array, sample.Pair)
+		}
+
+		if pair.Pair != "" {
+			return nil, fmt.Errorf("array %q is paired with %q which has its own pair", sample.Array, sample.Pair)
+		}
+	}
+
+	return s, nil
+}
+
+func ReadSampleSheet(path string) (*SampleSheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	s, err := ParseSampleSheet(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Write writes the sample sheet as CSV.
+func (s *SampleSheet) Write(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(sheetColumns); err != nil {
+		return err
+	}
+
+	for _, sample := range s.Samples {
+		if err := cw.Write([]string{sample.Array, sample.File, sample.SampleID, sample.Group, sample.Sheet, sample.Pair, sample.Timepoint}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+var arrayNumber = regexp.MustCompile(`No\.\s*(\w+)\.gpr$`)
+
+// Template returns a sample sheet listing every gpr file in dir.
+func Template(dir string) (*SampleSheet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.gpr"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SampleSheet{}
+
+	for _, path := range paths {
+		file := filepath.Base(path)
+		array := strings.TrimSuffix(file, filepath.Ext(file))
+
+		if m := arrayNumber.FindStringSubmatch(file); m != nil {
+			array = m[1]
+		}
+
+		s.Samples = append(s.Samples, Sample{
+			Array:    array,
+			File:     file,
+			SampleID: "No." + array,
+			Group:    "Group " + array,
+		})
+	}
+
+	sort.SliceStable(s.Samples, func(i, j int) bool {
+		a, errA := strconv.Atoi(s.Samples[i].Array)
+		b, errB := strconv.Atoi(s.Samples[j].Array)
+
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA == nil || errB == nil:
+			return errA == nil
+		default:
+			return s.Samples[i].Array < s.Samples[j].Array
+		}
+	})
+
+	return s, nil
+}
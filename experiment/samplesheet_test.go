@@ -0,0 +1,97 @@
+package experiment
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ReadSampleSheet(t *testing.T) {
+	s, err := ReadSampleSheet(filepath.Join("testdata", "samples.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Samples) != 5 {
+		t.Fatalf("expected 5 samples, got %d", len(s.Samples))
+	}
+
+	if a := s.Samples[1]; a.SampleID != "No.2" || a.Sheet != "control" || a.Pair != "4" || a.Timepoint != "24h" {
+		t.Errorf("unexpected sample %+v", a)
+	}
+
+	if sheets := s.Sheets(); len(sheets) != 2 || sheets[1] != "KO" {
+		t.Errorf("unexpected sheets %v", sheets)
+	}
+
+	c := s.Comparisons()
+	if len(c) != 3 || c[0] != (Comparison{Sheet: "control", Left: "1", Right: "3"}) || c[2] != (Comparison{Sheet: "KO", Left: "5"}) {
+		t.Errorf("unexpected comparisons %v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := ParseSampleSheet(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(again.Samples) != len(s.Samples) || again.Samples[4] != s.Samples[4] {
+		t.Errorf("sample sheet changed when written: %+v", again.Samples)
+	}
+}
+
+func Test_ParseSampleSheet_Invalid(t *testing.T) {
+	for _, sheet := range []string{
+		"",
+		"Array,Pair\n1,\n",
+		"Array,Group\n1,a\n1,b\n",
+		"Array,Group\n1,\n",
+		"Array,Group,Pair\n1,a,2\n",
+		"Array,Group,Pair\n1,a,1\n",
+		"Array,Group,Pair\n1,a,2\n2,a,3\n3,a,\n",
+	} {
+		if _, err := ParseSampleSheet(strings.NewReader(sheet)); err == nil {
+			t.Errorf("expected an error for %q", sheet)
+		}
+	}
+}
+
+func Test_Template(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"slide No.10.gpr", "slide No. 2.gpr", "other.gpr", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := Template(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Samples) != 3 || s.Samples[0].Array != "2" || s.Samples[1].Array != "10" || s.Samples[2].Array != "other" {
+		t.Fatalf("unexpected samples %+v", s.Samples)
+	}
+
+	path, err := s.Samples[0].Path(dir)
+	if err != nil || filepath.Base(path) != "slide No. 2.gpr" {
+		t.Errorf("unexpected path %q: %v", path, err)
+	}
+
+	if _, err := (Sample{Array: "2"}).Path(dir); err != nil {
+		t.Errorf("expected the array to be found by number: %v", err)
+	}
+}
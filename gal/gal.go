@@ -0,0 +1,221 @@
+package gal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/atf"
+)
+
+const (
+	colBlock  = "Block"
+	colColumn = "Column"
+	colRow    = "Row"
+	colName   = "Name"
+	colID     = "ID"
+
+	// ColControl is the annotation column giving the control of each spot.
+	ColControl = "Control"
+)
+
+var requiredColumns = []string{colBlock, colColumn, colRow, colName, colID}
+
+// Block is the position and shape of a printed block in micrometres.
+type Block struct {
+	Number    int
+	X         int
+	Y         int
+	Diameter  int
+	XFeatures int
+	XSpacing  int
+	YFeatures int
+	YSpacing  int
+}
+
+type Spot struct {
+	Block       int
+	Column      int
+	Row         int
+	Name        string
+	ID          string
+	Annotations map[string]string
+}
+
+// GAL is a GenePix Array List describing the print layout of an array.
+type GAL struct {
+	Type   string
+	Header []atf.Field
+	Blocks []Block
+
+	// Annotations lists the extra columns in order.
+	Annotations []string
+
+	Spots []Spot
+
+	idx map[key]int
+}
+
+type key struct {
+	block, column, row int
+}
+
+// Lookup returns the spot at a position. Spots are indexed on first use.
+func (g *GAL) Lookup(block, column, row int) (Spot, bool) {
+	i, ok := g.index()[key{block, column, row}]
+	if !ok {
+		return Spot{}, false
+	}
+
+	return g.Spots[i], true
+}
+
+// index maps positions to spots, the first winning on repeats.
+func (g *GAL) index() map[key]int {
+	if g.idx != nil {
+		return g.idx
+	}
+
+	g.idx = make(map[key]int, len(g.Spots))
+	for i, s := range g.Spots {
+		k := key{s.Block, s.Column, s.Row}
+		if _, ok := g.idx[k]; !ok {
+			g.idx[k] = i
+		}
+	}
+
+	return g.idx
+}
+
+func Parse(r io.Reader) (*GAL, error) {
+	ar, err := atf.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := atf.Index(ar.Columns, requiredColumns...)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GAL{Header: ar.Header}
+
+	for _, f := range ar.Header {
+		switch {
+		case f.Key == "Type":
+			g.Type = f.Value
+		case strings.HasPrefix(f.Key, "Block") && f.Key != "BlockCount" && f.Key != "BlockType":
+			b, err := parseBlock(f)
+			if err != nil {
+				return nil, err
+			}
+
+			g.Blocks = append(g.Blocks, b)
+		}
+	}
+
+	required := map[string]bool{}
+	for _, c := range requiredColumns {
+		required[c] = true
+	}
+
+	for _, c := range ar.Columns {
+		if !required[c] {
+			g.Annotations = append(g.Annotations, c)
+		}
+	}
+
+	for {
+		line, err := ar.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, err
+		}
+
+		s := Spot{
+			Name: line[idx[colName]],
+			ID:   line[idx[colID]],
+		}
+
+		for _, f := range []struct {
+			name string
+			v    *int
+		}{
+			{colBlock, &s.Block},
+			{colColumn, &s.Column},
+			{colRow, &s.Row},
+		} {
+			*f.v, err = strconv.Atoi(strings.TrimSpace(line[idx[f.name]]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: column %q: %w", ar.Line(), f.name, err)
+			}
+		}
+
+		if len(g.Annotations) > 0 {
+			s.Annotations = make(map[string]string, len(g.Annotations))
+			for _, a := range g.Annotations {
+				s.Annotations[a] = line[idx[a]]
+			}
+		}
+
+		g.Spots = append(g.Spots, s)
+	}
+
+	return g, nil
+}
+
+func Read(path string) (*GAL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	g, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return g, nil
+}
+
+// parseBlock parses a block definition such as
+// "Block1=1800, 2600, 100, 4, 200, 3, 200".
+func parseBlock(f atf.Field) (Block, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(f.Key, "Block"))
+	if err != nil {
+		return Block{}, fmt.Errorf("invalid block header %q", f.Key)
+	}
+
+	parts := strings.Split(f.Value, ",")
+	if len(parts) != 7 {
+		return Block{}, fmt.Errorf("invalid %s definition %q", f.Key, f.Value)
+	}
+
+	vs := make([]int, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Block{}, fmt.Errorf("invalid %s definition %q: %w", f.Key, f.Value, err)
+		}
+
+		vs[i] = int(v)
+	}
+
+	return Block{
+		Number:    n,
+		X:         vs[0],
+		Y:         vs[1],
+		Diameter:  vs[2],
+		XFeatures: vs[3],
+		XSpacing:  vs[4],
+		YFeatures: vs[5],
+		YSpacing:  vs[6],
+	}, nil
+}
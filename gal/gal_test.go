@@ -0,0 +1,93 @@
+package gal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func Test_Read(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "layout.gal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Type != "GenePix ArrayList V1.0" {
+		t.Errorf("unexpected type %q", g.Type)
+	}
+
+	if len(g.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(g.Blocks))
+	}
+
+	b := g.Blocks[1]
+	if b.Number != 2 || b.X != 6300 || b.Y != 2600 || b.Diameter != 100 || b.XFeatures != 4 || b.XSpacing != 200 || b.YFeatures != 3 || b.YSpacing != 200 {
+		t.Errorf("unexpected block: %+v", b)
+	}
+
+	if len(g.Annotations) != 2 || g.Annotations[0] != "Control" || g.Annotations[1] != "Concentration" {
+		t.Errorf("unexpected annotations: %v", g.Annotations)
+	}
+
+	if len(g.Spots) != 25 {
+		t.Fatalf("expected 25 spots, got %d", len(g.Spots))
+	}
+
+	s, ok := g.Lookup(1, 2, 3)
+	if !ok {
+		t.Fatal("missing spot")
+	}
+
+	if s.ID != "IgG" || s.Name != "igg" || s.Annotations["Control"] != "positive" || s.Annotations["Concentration"] != "100" {
+		t.Errorf("unexpected spot: %+v", s)
+	}
+
+	if _, ok := g.Lookup(3, 1, 1); ok {
+		t.Error("expected no spot in block 3")
+	}
+}
+
+func Test_Join(t *testing.T) {
+	layout, err := Read(filepath.Join("testdata", "layout.gal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gpr.Read(filepath.Join("..", "gpr", "testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined, mismatches := Join(g, layout)
+
+	summary := Summary(mismatches)
+	if summary[IDMismatch] != 1 || summary[NameMismatch] != 2 || summary[NotInResults] != 1 || summary[NotInLayout] != 0 {
+		t.Errorf("unexpected mismatches: %v", mismatches)
+	}
+
+	for _, row := range joined.Rows {
+		if row.Block == 2 && row.Column == 3 && row.Row == 2 {
+			if row.ID != "C1563" || row.Name != "c1563" {
+				t.Errorf("expected the id from the layout: %+v", row)
+			}
+		}
+
+		if row.ID == "IgM" && (row.Annotations["Control"] != "positive" || row.Control != gpr.PositiveControl) {
+			t.Errorf("expected annotations and control from the layout: %+v", row)
+		}
+	}
+
+	joined.Rows[0].Channels[0].Median = -1
+
+	if g.Rows[18].ID != "C1562" || g.Rows[0].Channels[0].Median == -1 {
+		t.Error("join should not modify the original")
+	}
+
+	g.Rows = append(g.Rows, gpr.Row{ID: "extra", Block: 3, Column: 1, Row: 1})
+
+	_, mismatches = Join(g, layout)
+	if Summary(mismatches)[NotInLayout] != 1 {
+		t.Errorf("expected a spot missing from the layout: %v", mismatches)
+	}
+}
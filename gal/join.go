@@ -0,0 +1,130 @@
+package gal
+
+import (
+	"fmt"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+type MismatchKind int
+
+const (
+	// NotInLayout is a spot in the results that has no entry in the GAL.
+	NotInLayout MismatchKind = iota + 1
+	// NotInResults is a spot in the GAL that is missing from the results.
+	NotInResults
+	// IDMismatch is a spot whose ID differs between the results and the GAL.
+	IDMismatch
+	// NameMismatch is a spot whose name differs between the results and GAL.
+	NameMismatch
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case NotInLayout:
+		return "not in layout"
+	case NotInResults:
+		return "not in results"
+	case IDMismatch:
+		return "id mismatch"
+	case NameMismatch:
+		return "name mismatch"
+	default:
+		return ""
+	}
+}
+
+type Mismatch struct {
+	Kind   MismatchKind
+	Block  int
+	Column int
+	Row    int
+
+	// Results and Layout hold the differing ID or name.
+	Results string
+	Layout  string
+}
+
+func (m Mismatch) String() string {
+	switch m.Kind {
+	case IDMismatch, NameMismatch:
+		return fmt.Sprintf("block %d column %d row %d: %s, results %q, layout %q", m.Block, m.Column, m.Row, m.Kind, m.Results, m.Layout)
+	default:
+		return fmt.Sprintf("block %d column %d row %d: %s", m.Block, m.Column, m.Row, m.Kind)
+	}
+}
+
+// Join copies the IDs, names and annotations of layout onto g by position,
+// reporting mismatches. Rules should be applied again afterwards.
+func Join(g *gpr.GPR, layout *GAL) (*gpr.GPR, []Mismatch) {
+	var mismatches []Mismatch
+
+	idx := layout.index()
+	seen := make([]bool, len(layout.Spots))
+	rows := make([]gpr.Row, len(g.Rows))
+
+	for i, row := range g.Rows {
+		row.Channels = append([]gpr.Channel(nil), row.Channels...)
+		rows[i] = row
+
+		j, ok := idx[key{row.Block, row.Column, row.Row}]
+		if !ok {
+			mismatches = append(mismatches, Mismatch{Kind: NotInLayout, Block: row.Block, Column: row.Column, Row: row.Row})
+			continue
+		}
+
+		seen[j] = true
+		spot := layout.Spots[j]
+
+		if row.ID != spot.ID {
+			mismatches = append(mismatches, Mismatch{Kind: IDMismatch, Block: row.Block, Column: row.Column, Row: row.Row, Results: row.ID, Layout: spot.ID})
+		}
+
+		if row.Name != spot.Name {
+			mismatches = append(mismatches, Mismatch{Kind: NameMismatch, Block: row.Block, Column: row.Column, Row: row.Row, Results: row.Name, Layout: spot.Name})
+		}
+
+		rows[i].ID = spot.ID
+		rows[i].Name = spot.Name
+		rows[i].Control = gpr.Sample
+
+		if c, err := gpr.ParseControl(spot.Annotations[ColControl]); err == nil {
+			rows[i].Control = c
+		}
+
+		if len(spot.Annotations) > 0 {
+			ann := make(map[string]string, len(row.Annotations)+len(spot.Annotations))
+			for k, v := range row.Annotations {
+				ann[k] = v
+			}
+
+			for k, v := range spot.Annotations {
+				ann[k] = v
+			}
+
+			rows[i].Annotations = ann
+		}
+	}
+
+	for j, spot := range layout.Spots {
+		if !seen[j] {
+			mismatches = append(mismatches, Mismatch{Kind: NotInResults, Block: spot.Block, Column: spot.Column, Row: spot.Row})
+		}
+	}
+
+	out := *g
+	out.Header = g.Header.Clone()
+	out.Rows = rows
+
+	return &out, mismatches
+}
+
+// Summary counts mismatches by kind.
+func Summary(mismatches []Mismatch) map[MismatchKind]int {
+	m := map[MismatchKind]int{}
+	for _, x := range mismatches {
+		m[x.Kind]++
+	}
+
+	return m
+}
@@ -0,0 +1,320 @@
+package gpr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// ErrNoRawColumns is returned when a background correction needs the raw
+// foreground and background columns and they were not read.
+var ErrNoRawColumns = errors.New("raw foreground and background columns not present")
+
+// BackgroundCorrection computes the background subtracted means and
+// medians of every channel from the raw foreground and background values.
+type BackgroundCorrection interface {
+	Correct(g *GPR) error
+	String() string
+}
+
+// correctAll replaces the background subtracted values of every channel
+// with f applied to the raw values of all spots scanned at the wavelength.
+// The median is corrected with the background median and the mean with the
+// background mean.
+func correctAll(g *GPR, f func(fg, bg []float64) []float64) error {
+	if err := checkRaw(g); err != nil {
+		return err
+	}
+
+	for _, w := range g.Wavelengths {
+		var chs []*Channel
+
+		for i := range g.Rows {
+			for j := range g.Rows[i].Channels {
+				if c := &g.Rows[i].Channels[j]; c.Wavelength == w {
+					chs = append(chs, c)
+				}
+			}
+		}
+
+		fmedian := make([]float64, len(chs))
+		bmedian := make([]float64, len(chs))
+		fmean := make([]float64, len(chs))
+		bmean := make([]float64, len(chs))
+
+		for i, c := range chs {
+			fmedian[i], bmedian[i] = c.Median, c.BackgroundMedian
+			fmean[i], bmean[i] = c.Mean, c.BackgroundMean
+		}
+
+		median, mean := f(fmedian, bmedian), f(fmean, bmean)
+
+		for i, c := range chs {
+			c.MedianMinusBackground = median[i]
+			c.MeanMinusBackground = mean[i]
+		}
+	}
+
+	return nil
+}
+
+// checkRaw returns ErrNoRawColumns if g was read from a file without the
+// raw columns. A GPR without columns was not read and is assumed complete.
+func checkRaw(g *GPR) error {
+	if len(g.Columns) == 0 {
+		return nil
+	}
+
+	have := make(map[string]bool, len(g.Columns))
+	for _, c := range g.Columns {
+		have[c] = true
+	}
+
+	for _, w := range g.Wavelengths {
+		for _, c := range rawColumns(w) {
+			if !have[c] {
+				return fmt.Errorf("%w: %s", ErrNoRawColumns, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rowCorrection is a background correction that can be applied to each row
+// on its own, as the rows are read.
+type rowCorrection interface {
+	correctRow(r *Row)
+}
+
+// correctRow replaces the background subtracted values of every channel of
+// r with f applied to its raw values.
+func correctRow(r *Row, f func(fg, bg []float64) []float64) {
+	for i := range r.Channels {
+		c := &r.Channels[i]
+
+		median := f([]float64{c.Median}, []float64{c.BackgroundMedian})
+		mean := f([]float64{c.Mean}, []float64{c.BackgroundMean})
+
+		c.MedianMinusBackground, c.MeanMinusBackground = median[0], mean[0]
+	}
+}
+
+func foreground(fg, bg []float64) []float64 {
+	return append([]float64(nil), fg...)
+}
+
+func subtract(fg, bg []float64) []float64 {
+	out := make([]float64, len(fg))
+	for i := range fg {
+		out[i] = fg[i] - bg[i]
+	}
+
+	return out
+}
+
+// GenePix keeps the background subtracted values computed by GenePix.
+type GenePix struct{}
+
+func (GenePix) Correct(g *GPR) error {
+	return nil
+}
+
+func (GenePix) correctRow(r *Row) {}
+
+func (GenePix) String() string {
+	return "genepix"
+}
+
+// NoCorrection uses the foreground values without removing any background.
+type NoCorrection struct{}
+
+func (NoCorrection) Correct(g *GPR) error {
+	return correctAll(g, foreground)
+}
+
+func (NoCorrection) correctRow(r *Row) {
+	correctRow(r, foreground)
+}
+
+func (NoCorrection) String() string {
+	return "none"
+}
+
+// Subtract subtracts the local background from the foreground.
+type Subtract struct{}
+
+func (Subtract) Correct(g *GPR) error {
+	return correctAll(g, subtract)
+}
+
+func (Subtract) correctRow(r *Row) {
+	correctRow(r, subtract)
+}
+
+func (Subtract) String() string {
+	return "subtract"
+}
+
+// HalfFloor subtracts the local background and raises values below Floor
+// to Floor, so that corrected intensities stay positive.
+type HalfFloor struct {
+	Floor float64
+}
+
+func (h HalfFloor) Correct(g *GPR) error {
+	return correctAll(g, h.floor)
+}
+
+func (h HalfFloor) correctRow(r *Row) {
+	correctRow(r, h.floor)
+}
+
+func (h HalfFloor) floor(fg, bg []float64) []float64 {
+	out := subtract(fg, bg)
+	for i, v := range out {
+		if v < h.Floor {
+			out[i] = h.Floor
+		}
+	}
+
+	return out
+}
+
+func (h HalfFloor) String() string {
+	return fmt.Sprintf("half (floor %v)", h.Floor)
+}
+
+// Normexp models the background subtracted intensities of each channel as
+// a normal background plus an exponential signal, and replaces each value
+// with the expected signal given the observation. The parameters are
+// estimated from the mode, the spread below it and the mean above it.
+type Normexp struct{}
+
+func (Normexp) Correct(g *GPR) error {
+	return correctAll(g, func(fg, bg []float64) []float64 {
+		return normexp(subtract(fg, bg))
+	})
+}
+
+func (Normexp) String() string {
+	return "normexp"
+}
+
+func normexp(x []float64) []float64 {
+	mu := stats.Mode(x)
+
+	var below, above []float64
+	for _, v := range x {
+		switch {
+		case v < mu:
+			below = append(below, (v-mu)*(v-mu))
+		case v > mu:
+			above = append(above, v-mu)
+		}
+	}
+
+	sigma := math.Sqrt(stats.Mean(below))
+	alpha := stats.Mean(above)
+
+	out := make([]float64, len(x))
+
+	if !(sigma > 0) || !(alpha > 0) {
+		for i, v := range x {
+			out[i] = math.Max(v-mu, 0)
+		}
+
+		return out
+	}
+
+	for i, v := range x {
+		a := v - mu - sigma*sigma/alpha
+		z := a / sigma
+
+		// the ratio of the density to the distribution tends to -z as z
+		// falls, where both underflow
+		ratio := -z
+		if z > -30 {
+			ratio = stats.NormalPDF(z) / stats.NormalCDF(z)
+		}
+
+		out[i] = a + sigma*ratio
+	}
+
+	return out
+}
+
+// MovingMinimum subtracts, from each spot, the smallest background median
+// of the spots within one column and row of it in the same block. This
+// removes local background estimates inflated by neighbouring spots.
+type MovingMinimum struct{}
+
+func (MovingMinimum) Correct(g *GPR) error {
+	if err := checkRaw(g); err != nil {
+		return err
+	}
+
+	type pos struct {
+		block, column, row int
+	}
+
+	for _, w := range g.Wavelengths {
+		bg := make(map[pos]float64, len(g.Rows))
+		for _, row := range g.Rows {
+			bg[pos{row.Block, row.Column, row.Row}] = row.Channel(w).BackgroundMedian
+		}
+
+		for i := range g.Rows {
+			row := &g.Rows[i]
+
+			min := math.Inf(1)
+			for dc := -1; dc <= 1; dc++ {
+				for dr := -1; dr <= 1; dr++ {
+					if b, ok := bg[pos{row.Block, row.Column + dc, row.Row + dr}]; ok && b < min {
+						min = b
+					}
+				}
+			}
+
+			for j := range row.Channels {
+				if c := &row.Channels[j]; c.Wavelength == w {
+					c.MedianMinusBackground = c.Median - min
+					c.MeanMinusBackground = c.Mean - min
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (MovingMinimum) String() string {
+	return "movingmin"
+}
+
+// BackgroundCorrections lists the names accepted by ParseBackgroundCorrection.
+var BackgroundCorrections = []string{"genepix", "none", "subtract", "half", "normexp", "movingmin"}
+
+// ParseBackgroundCorrection returns the correction with the given name. The
+// half correction uses a floor of 0.5.
+func ParseBackgroundCorrection(name string) (BackgroundCorrection, error) {
+	switch strings.ToLower(name) {
+	case "genepix":
+		return GenePix{}, nil
+	case "none":
+		return NoCorrection{}, nil
+	case "subtract":
+		return Subtract{}, nil
+	case "half":
+		return HalfFloor{Floor: 0.5}, nil
+	case "normexp":
+		return Normexp{}, nil
+	case "movingmin":
+		return MovingMinimum{}, nil
+	default:
+		return nil, fmt.Errorf("unknown background correction %q", name)
+	}
+}
@@ -0,0 +1,119 @@
+package gpr
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Background(t *testing.T) {
+	path := filepath.Join("testdata", "test1.gpr")
+
+	g, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := g.Rows[0].Channel(650); c.Mean != 4747 || c.BackgroundMedian != 57 || c.BackgroundMean != 61 {
+		t.Fatalf("unexpected raw values: %+v", c)
+	}
+
+	g, err = Read(path, WithBackground(Subtract{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := g.Rows[0].Channel(650); c.MedianMinusBackground != 4712 || c.MeanMinusBackground != 4686 {
+		t.Errorf("unexpected subtracted values: %+v", c)
+	}
+
+	g, err = Read(path, WithBackground(NoCorrection{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := g.Rows[0].Channel(650); c.MedianMinusBackground != 4769 || c.MeanMinusBackground != 4747 {
+		t.Errorf("unexpected uncorrected values: %+v", c)
+	}
+
+	for _, name := range []string{"half", "normexp"} {
+		bc, err := ParseBackgroundCorrection(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g, err := Read(path, WithBackground(bc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, row := range g.Rows {
+			for _, c := range row.Channels {
+				if !(c.MedianMinusBackground > 0) || !(c.MeanMinusBackground > 0) {
+					t.Fatalf("%s: expected positive values, got %+v", name, c)
+				}
+			}
+		}
+	}
+
+	if _, err := ParseBackgroundCorrection("rma"); err == nil {
+		t.Error("expected error for unknown correction")
+	}
+}
+
+func Test_Background_MovingMinimum(t *testing.T) {
+	g := &GPR{Wavelengths: []int{635}}
+
+	for col := 1; col <= 3; col++ {
+		g.Rows = append(g.Rows, Row{
+			Block:    1,
+			Column:   col,
+			Row:      1,
+			Channels: []Channel{{Wavelength: 635, Median: 500, Mean: 520, BackgroundMedian: float64(100 * col)}},
+		})
+	}
+
+	if err := (MovingMinimum{}).Correct(g); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []float64{400, 400, 300}
+	for i, row := range g.Rows {
+		if c := row.Channel(635); c.MedianMinusBackground != expected[i] || c.MeanMinusBackground != expected[i]+20 {
+			t.Errorf("column %d: unexpected values %+v", row.Column, c)
+		}
+	}
+}
+
+func Test_Background_Normexp(t *testing.T) {
+	x := []float64{-30, -10, -5, 0, 2, 5, 10, 50, 200, 1000, 5000}
+
+	out := normexp(x)
+	for i := range out {
+		if !(out[i] > 0) {
+			t.Errorf("expected positive signal for %v, got %v", x[i], out[i])
+		}
+
+		if i > 0 && out[i] < out[i-1] {
+			t.Errorf("expected increasing signal, got %v", out)
+		}
+	}
+
+	if math.Abs(out[len(out)-1]-5000) > 500 {
+		t.Errorf("expected large values to be kept, got %v", out[len(out)-1])
+	}
+}
+
+func Test_Background_NoRawColumns(t *testing.T) {
+	path, cleanup := writeTemp(t, "reordered.gpr", reordered)
+	defer cleanup()
+
+	if _, err := Read(path, WithBackground(Subtract{})); !errors.Is(err, ErrNoRawColumns) {
+		t.Errorf("expected missing raw columns, got %v", err)
+	}
+
+	if _, err := Read(path); err != nil {
+		t.Errorf("expected default correction to need no raw columns, got %v", err)
+	}
+}
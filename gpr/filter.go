@@ -0,0 +1,104 @@
+package gpr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flag is the spot quality flag assigned by GenePix.
+type Flag int
+
+const (
+	FlagBad      Flag = -100
+	FlagAbsent   Flag = -75
+	FlagNotFound Flag = -50
+	FlagNone     Flag = 0
+	FlagGood     Flag = 100
+)
+
+func (f Flag) String() string {
+	switch f {
+	case FlagBad:
+		return "Bad"
+	case FlagAbsent:
+		return "Absent"
+	case FlagNotFound:
+		return "Not Found"
+	case FlagNone:
+		return "None"
+	case FlagGood:
+		return "Good"
+	default:
+		return fmt.Sprintf("Flag %d", int(f))
+	}
+}
+
+// Filter reports whether a row should be excluded and why.
+type Filter func(Row) (reason string, exclude bool)
+
+// ExcludeFlagged excludes spots flagged Bad, Absent, Not Found or with any
+// other negative flag.
+func ExcludeFlagged(r Row) (string, bool) {
+	if r.Flags < 0 {
+		return r.Flags.String(), true
+	}
+
+	return "", false
+}
+
+// Exclusions counts excluded rows by reason.
+type Exclusions map[string]int
+
+func (e Exclusions) Total() int {
+	var n int
+	for _, c := range e {
+		n += c
+	}
+
+	return n
+}
+
+// Add adds the counts of o to e.
+func (e Exclusions) Add(o Exclusions) {
+	for reason, n := range o {
+		e[reason] += n
+	}
+}
+
+func (e Exclusions) String() string {
+	reasons := make([]string, 0, len(e))
+	for reason := range e {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%s: %d", reason, e[reason])
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Filter returns a copy of g without the rows excluded by any of the
+// filters, along with the number of rows excluded for each reason.
+func (g *GPR) Filter(filters ...Filter) (*GPR, Exclusions) {
+	rows := make([]Row, 0, len(g.Rows))
+	ex := Exclusions{}
+
+rows:
+	for _, row := range g.Rows {
+		for _, f := range filters {
+			if reason, exclude := f(row); exclude {
+				ex[reason]++
+				continue rows
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return g.withRows(rows), ex
+}
@@ -1,20 +1,44 @@
 package gpr
 
 import (
-	"encoding/csv"
-	"errors"
-	"fmt"
-	"io"
-	"os"
 	"sort"
-	"strconv"
-	"strings"
 )
 
+// MaxIntensity is the largest pixel intensity recorded by the scanner.
+const MaxIntensity = 65535
+
 type GPR struct {
+	Header      Header
+	Wavelengths []int
+
+	// Columns holds the column titles in the order they were read.
+	Columns []string
+
 	Rows []Row
 }
 
+// withRows returns a GPR with the same header and columns as g holding rows.
+func (g *GPR) withRows(rows []Row) *GPR {
+	return &GPR{
+		Header:      g.Header.Clone(),
+		Wavelengths: g.Wavelengths,
+		Columns:     g.Columns,
+		Rows:        rows,
+	}
+}
+
+// Clone returns a copy of g whose rows and channels can be modified without
+// changing g.
+func (g *GPR) Clone() *GPR {
+	rows := make([]Row, len(g.Rows))
+	for i, row := range g.Rows {
+		row.Channels = append([]Channel(nil), row.Channels...)
+		rows[i] = row
+	}
+
+	return g.withRows(rows)
+}
+
 func (g *GPR) ByProtein() map[string][]Row {
 	m := make(map[string][]Row)
 
@@ -46,264 +70,109 @@ func (g *GPR) SortByID() *GPR {
 	return g
 }
 
-func (g *GPR) SortBy650Median() *GPR {
+func (g *GPR) SortByMedian(wavelength int) *GPR {
 	sort.Slice(g.Rows, func(i, j int) bool {
-		return g.Rows[i].F650MedianB650 > g.Rows[j].F650MedianB650
+		return g.Rows[i].Channel(wavelength).MedianMinusBackground > g.Rows[j].Channel(wavelength).MedianMinusBackground
 	})
 
 	return g
 }
 
-func (g *GPR) SortBy550Median() *GPR {
+func (g *GPR) SortByMean(wavelength int) *GPR {
 	sort.Slice(g.Rows, func(i, j int) bool {
-		return g.Rows[i].F550MedianB550 > g.Rows[j].F550MedianB550
+		return g.Rows[i].Channel(wavelength).MeanMinusBackground > g.Rows[j].Channel(wavelength).MeanMinusBackground
 	})
 
 	return g
 }
 
-func (g *GPR) SortBy650Mean() *GPR {
-	sort.Slice(g.Rows, func(i, j int) bool {
-		return g.Rows[i].F650MeanB650 > g.Rows[j].F650MeanB650
-	})
-
-	return g
-}
-
-func (g *GPR) SortBy550Mean() *GPR {
-	sort.Slice(g.Rows, func(i, j int) bool {
-		return g.Rows[i].F550MeanB550 > g.Rows[j].F550MeanB550
-	})
+// HasWavelength reports whether the file contains a channel scanned at the
+// given wavelength.
+func (g *GPR) HasWavelength(wavelength int) bool {
+	for _, w := range g.Wavelengths {
+		if w == wavelength {
+			return true
+		}
+	}
 
-	return g
+	return false
 }
 
+// Averaged returns the mean of the replicate spots of every protein.
 func (g *GPR) Averaged() *GPR {
-	m := map[string][]Row{}
-
-	for _, x := range g.Rows {
-		m[x.ID] = append(m[x.ID], x)
-	}
-
-	n := make([]Row, 0, len(m))
-
-	for _, v := range m {
-		if len(v) != 2 {
-			continue
-		}
-
-		n = append(n, Row{
-			ID:             v[0].ID,
-			F650MeanB650:   (v[0].F650MeanB650 + v[1].F650MeanB650) / 2,
-			F550MeanB550:   (v[0].F550MeanB550 + v[1].F550MeanB550) / 2,
-			F650MedianB650: (v[0].F650MedianB650 + v[1].F650MedianB650) / 2,
-			F550MedianB550: (v[0].F550MedianB550 + v[1].F550MedianB550) / 2,
-			SNR650:         (v[0].SNR650 + v[1].SNR650) / 2,
-			SNR550:         (v[0].SNR550 + v[1].SNR550) / 2,
-		})
-	}
-
-	return &GPR{Rows: n}
+	avg, _ := DefaultSummariser.Summarise(g)
+	return avg
 }
 
 type Row struct {
-	ID             string
-	X              int
-	Y              int
-	Block          int
-	Column         int
-	Row            int
-	Diameter       int
-	F650Median     float64
-	F550Median     float64
-	F650MeanB650   float64
-	F550MeanB550   float64
-	F650MedianB650 float64
-	F550MedianB550 float64
-	SNR650         float64
-	SNR550         float64
+	ID          string
+	Name        string
+	X           int
+	Y           int
+	Block       int
+	Column      int
+	Row         int
+	Diameter    int
+	Flags       Flag
+	Circularity float64
+	FPixels     int
+	BPixels     int
+	Channels    []Channel
+	Control     Control
+
+	// Replicates is the number of spots a summarised row was computed from.
+	Replicates int
+
+	// Annotations holds extra columns describing the spot, such as those
+	// joined from the array list.
+	Annotations map[string]string
+
+	// raw holds the fields as read, aligned with GPR.Columns, so that
+	// columns without a field are kept when writing.
+	raw []string
 }
 
-func Read(path string) (*GPR, error) {
-	if !strings.HasSuffix(path, ".gpr") {
-		return nil, errors.New("not a gpr file")
-	}
-
-	rows := make([]Row, 0, 10000)
-
-	p, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-
-	defer p.Close()
-
-	r := csv.NewReader(p)
-	r.Comma = '\t'
-	r.FieldsPerRecord = -1
-
-	var i int
-
-	for {
-		line, err := r.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-
-			return nil, err
-		}
-
-		if i < 33 {
-			i++
-			continue
+// Channel returns the measurements for the given wavelength, or the zero
+// Channel if the row was not scanned at that wavelength.
+func (r Row) Channel(wavelength int) Channel {
+	for _, c := range r.Channels {
+		if c.Wavelength == wavelength {
+			return c
 		}
-
-		if len(line) != 56 {
-			fmt.Println("invalid line length")
-			continue
-		}
-
-		switch line[2] {
-		case "17", "18":
-			continue
-		}
-
-		switch strings.ToLower(line[4]) {
-		case "empty", "blank":
-			continue
-		}
-
-		blockStr := line[0]
-		colStr := line[1]
-		rowStr := line[2]
-		protein := line[4]
-		xStr, yStr, diaStr := line[5], line[6], line[7]
-		f650MedianStr := line[8]
-		f550MedianStr := line[20]
-		f650MedianMinusStr := line[45]
-		f550MedianMinusStr := line[46]
-		f650MeanStr := line[47]
-		f550MeanStr := line[48]
-		snr650Str := line[51]
-		snr550Str := line[52]
-
-		block, err := strconv.Atoi(blockStr)
-		if err != nil {
-			return nil, err
-		}
-
-		column, err := strconv.Atoi(colStr)
-		if err != nil {
-			return nil, err
-		}
-
-		row, err := strconv.Atoi(rowStr)
-		if err != nil {
-			return nil, err
-		}
-
-		x, err := strconv.Atoi(xStr)
-		if err != nil {
-			return nil, err
-		}
-
-		y, err := strconv.Atoi(yStr)
-		if err != nil {
-			return nil, err
-		}
-
-		dia, err := strconv.Atoi(diaStr)
-		if err != nil {
-			return nil, err
-		}
-
-		f650Mean, err := strconv.ParseFloat(f650MeanStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		f550Mean, err := strconv.ParseFloat(f550MeanStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		f650Median, err := strconv.ParseFloat(f650MedianStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		f550Median, err := strconv.ParseFloat(f550MedianStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		f650MedianMinus, err := strconv.ParseFloat(f650MedianMinusStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		f550MedianMinus, err := strconv.ParseFloat(f550MedianMinusStr, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		snr650, err := strconv.ParseFloat(snr650Str, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		snr550, err := strconv.ParseFloat(snr550Str, 64)
-		if err != nil {
-			return nil, err
-		}
-
-		if f650Mean < 0 {
-			f650Mean = 1
-		}
-
-		if f550Mean < 0 {
-			f550Mean = 1
-		}
-
-		if f650MedianMinus < 0 {
-			f650MedianMinus = 1
-		}
-
-		if f550MedianMinus < 0 {
-			f550MedianMinus = 1
-		}
-
-		if snr650 < 0 {
-			snr650 = 0
-		}
-
-		if snr550 < 0 {
-			snr550 = 0
-		}
-
-		rows = append(rows, Row{
-			ID:             protein,
-			Block:          block,
-			Column:         column,
-			Row:            row,
-			X:              x,
-			Y:              y,
-			Diameter:       dia,
-			F550Median:     f550Median,
-			F650Median:     f650Median,
-			F650MeanB650:   f650Mean,
-			F550MeanB550:   f550Mean,
-			F650MedianB650: f650MedianMinus,
-			F550MedianB550: f550MedianMinus,
-			SNR650:         snr650,
-			SNR550:         snr550,
-		})
-
-		i++
 	}
 
-	return &GPR{
-		Rows: rows,
-	}, nil
+	return Channel{}
+}
+
+// Channel holds the measurements of a spot for a single scanned wavelength.
+type Channel struct {
+	Wavelength            int
+	Median                float64
+	Mean                  float64
+	MeanMinusBackground   float64
+	MedianMinusBackground float64
+	SNR                   float64
+
+	// PercentAbove2SD is the percentage of feature pixels more than two
+	// standard deviations above the background.
+	PercentAbove2SD float64
+
+	// BackgroundMedian and BackgroundMean are the local background around
+	// the spot.
+	BackgroundMedian float64
+	BackgroundMean   float64
+
+	// PercentSaturated is the percentage of feature pixels at the maximum
+	// intensity of the scanner.
+	PercentSaturated float64
+
+	// MeanSpread and MedianSpread describe the variation of
+	// MeanMinusBackground and MedianMinusBackground between the replicates
+	// of a summarised row.
+	MeanSpread   Spread
+	MedianSpread Spread
+
+	// Outlier holds the reason the channel was rejected as an outlier
+	// among the replicates of its protein, or is empty.
+	Outlier string
 }
@@ -0,0 +1,498 @@
+package gpr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTemp(t *testing.T, name, content string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gpr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func Test_Read(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Rows) != 24 {
+		t.Fatalf("expected 24 rows, got %d", len(g.Rows))
+	}
+
+	row := g.Rows[0]
+
+	if row.ID != "C0286" || row.Block != 1 || row.Column != 1 || row.Row != 1 {
+		t.Errorf("unexpected first row: %+v", row)
+	}
+
+	if row.X != 1800 || row.Y != 2600 || row.Diameter != 100 {
+		t.Errorf("unexpected position: %+v", row)
+	}
+
+	if len(g.Wavelengths) != 2 || g.Wavelengths[0] != 650 || g.Wavelengths[1] != 550 {
+		t.Errorf("unexpected wavelengths: %v", g.Wavelengths)
+	}
+
+	c650, c550 := row.Channel(650), row.Channel(550)
+
+	if c650.Median != 4769 || c550.Median != 6599 {
+		t.Errorf("unexpected medians: %v %v", c650.Median, c550.Median)
+	}
+
+	if c650.MedianMinusBackground != 4712 || c550.MedianMinusBackground != 6511 {
+		t.Errorf("unexpected median - background: %v %v", c650.MedianMinusBackground, c550.MedianMinusBackground)
+	}
+
+	if c650.MeanMinusBackground != 4690 || c550.MeanMinusBackground != 6507 {
+		t.Errorf("unexpected mean - background: %v %v", c650.MeanMinusBackground, c550.MeanMinusBackground)
+	}
+
+	if c650.SNR != 114.29 || c550.SNR != 591.45 {
+		t.Errorf("unexpected snr: %v %v", c650.SNR, c550.SNR)
+	}
+
+	if c := row.Channel(532); c.Wavelength != 0 {
+		t.Errorf("expected a zero channel for a missing wavelength, got %+v", c)
+	}
+
+	if row.Name != "c0286" {
+		t.Errorf("unexpected name %q", row.Name)
+	}
+
+	for _, row := range g.Rows {
+		if row.Control != Sample {
+			t.Errorf("expected every spot to be a sample without rules: %+v", row)
+		}
+	}
+}
+
+const reordered = "ATF\t1.0\r\n" +
+	"2\t17\r\n" +
+	"\"Type=GenePix Results 3\"\r\n" +
+	"\"Wavelengths=650\t550\"\r\n" +
+	"\"ID\"\t\"Name\"\t\"Block\"\t\"Row\"\t\"Column\"\t\"Extra\"\t\"X\"\t\"Y\"\t\"Dia.\"\t" +
+	"\"SNR 550\"\t\"SNR 650\"\t\"F550 Median\"\t\"F650 Median\"\t" +
+	"\"F550 Mean - B550\"\t\"F650 Mean - B650\"\t\"F550 Median - B550\"\t\"F650 Median - B650\"\r\n" +
+	"\"P1\"\t\"p1\"\t2\t3\t4\t\"x\"\t100\t200\t90\t1.5\t2.5\t300\t400\t250\t350\t260\t360\r\n"
+
+func Test_Read_ReorderedColumns(t *testing.T) {
+	path, cleanup := writeTemp(t, "reordered.gpr", reordered)
+	defer cleanup()
+
+	g, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(g.Rows))
+	}
+
+	row := g.Rows[0]
+
+	if row.ID != "P1" || row.Block != 2 || row.Row != 3 || row.Column != 4 {
+		t.Errorf("unexpected row: %+v", row)
+	}
+
+	if len(g.Wavelengths) != 2 || g.Wavelengths[0] != 550 || g.Wavelengths[1] != 650 {
+		t.Errorf("unexpected wavelengths: %v", g.Wavelengths)
+	}
+
+	c550, c650 := row.Channel(550), row.Channel(650)
+
+	if c550.SNR != 1.5 || c650.SNR != 2.5 {
+		t.Errorf("unexpected snr: %v %v", c550.SNR, c650.SNR)
+	}
+
+	if c550.Median != 300 || c650.Median != 400 {
+		t.Errorf("unexpected medians: %v %v", c550.Median, c650.Median)
+	}
+
+	if c550.MeanMinusBackground != 250 || c650.MeanMinusBackground != 350 || c550.MedianMinusBackground != 260 || c650.MedianMinusBackground != 360 {
+		t.Errorf("unexpected background subtracted values: %+v", row)
+	}
+}
+
+func Test_Read_MissingColumn(t *testing.T) {
+	content := strings.Replace(reordered, "\"SNR 550\"", "\"SNR 532\"", 1)
+
+	path, cleanup := writeTemp(t, "missing.gpr", content)
+	defer cleanup()
+
+	_, err := Read(path)
+	if err == nil {
+		t.Fatal("expected an error for a missing column")
+	}
+
+	if !strings.Contains(err.Error(), "SNR 550") {
+		t.Errorf("error does not name the missing column: %v", err)
+	}
+}
+
+func Test_Read_Wavelengths(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test2.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Wavelengths) != 2 || g.Wavelengths[0] != 635 || g.Wavelengths[1] != 532 {
+		t.Fatalf("unexpected wavelengths: %v", g.Wavelengths)
+	}
+
+	for _, row := range g.Rows {
+		if row.Channel(635).Median == 0 || row.Channel(532).Median == 0 {
+			t.Fatalf("missing channel values: %+v", row)
+		}
+	}
+}
+
+const singleChannel = "ATF\t1.0\r\n" +
+	"1\t11\r\n" +
+	"\"Wavelengths=532\"\r\n" +
+	"\"Block\"\t\"Column\"\t\"Row\"\t\"ID\"\t\"X\"\t\"Y\"\t\"Dia.\"\t" +
+	"\"F532 Median\"\t\"F532 Median - B532\"\t\"F532 Mean - B532\"\t\"SNR 532\"\r\n" +
+	"1\t1\t1\t\"P1\"\t100\t200\t90\t500\t400\t410\t12.5\r\n"
+
+func Test_Read_SingleChannel(t *testing.T) {
+	path, cleanup := writeTemp(t, "single.gpr", singleChannel)
+	defer cleanup()
+
+	g, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Wavelengths) != 1 || g.Wavelengths[0] != 532 {
+		t.Fatalf("unexpected wavelengths: %v", g.Wavelengths)
+	}
+
+	if c := g.Rows[0].Channel(532); c.MedianMinusBackground != 400 || c.SNR != 12.5 {
+		t.Errorf("unexpected channel: %+v", c)
+	}
+
+	labels, err := ParseLabels([]string{"IgG=532"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := labels.Check(g); err != nil {
+		t.Error(err)
+	}
+
+	labels, err = ParseLabels([]string{"IgG=550", "IgM=650"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := labels.Check(g); err == nil {
+		t.Error("expected an error for missing channels")
+	}
+}
+
+func Test_ParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"IgG=550", " IgM = 650"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(labels) != 2 || labels.Name(550) != "IgG" || labels.Name(650) != "IgM" || labels.Name(532) != "" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	for _, spec := range []string{"IgG", "=550", "IgG=abc"} {
+		if _, err := ParseLabels([]string{spec}); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func Test_Read_NotATF(t *testing.T) {
+	path, cleanup := writeTemp(t, "bad.gpr", "Block\tColumn\r\n1\t1\r\n")
+	defer cleanup()
+
+	if _, err := Read(path); err == nil {
+		t.Fatal("expected an error for a non ATF file")
+	}
+}
+
+func Test_Read_Header(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := g.Header
+
+	if h.Version != "1.0" {
+		t.Errorf("unexpected version %q", h.Version)
+	}
+
+	if len(h.Keys()) != 30 {
+		t.Errorf("expected 30 header records, got %d", len(h.Keys()))
+	}
+
+	if h.Type() != "GenePix Results 3" {
+		t.Errorf("unexpected type %q", h.Type())
+	}
+
+	if h.Scanner() != "GenePix 4000B [84948]" {
+		t.Errorf("unexpected scanner %q", h.Scanner())
+	}
+
+	if h.GalFile() != `C:\GenePix\gal\layout.gal` {
+		t.Errorf("unexpected gal file %q", h.GalFile())
+	}
+
+	ps, err := h.PixelSize()
+	if err != nil || ps != 10 {
+		t.Errorf("unexpected pixel size %v (%v)", ps, err)
+	}
+
+	ws, err := h.Wavelengths()
+	if err != nil || len(ws) != 2 || ws[0] != 650 || ws[1] != 550 {
+		t.Errorf("unexpected wavelengths %v (%v)", ws, err)
+	}
+
+	if files := h.ImageFiles(); len(files) != 2 {
+		t.Errorf("unexpected image files %v", files)
+	}
+
+	gain, err := h.PMTGain()
+	if err != nil || len(gain) != 2 || gain[0] != 600 || gain[1] != 550 {
+		t.Errorf("unexpected pmt gain %v (%v)", gain, err)
+	}
+
+	power, err := h.LaserPower()
+	if err != nil || len(power) != 2 || power[0] != 3.38 {
+		t.Errorf("unexpected laser power %v (%v)", power, err)
+	}
+
+	temp, err := h.Temperature()
+	if err != nil || temp != 33.19 {
+		t.Errorf("unexpected temperature %v (%v)", temp, err)
+	}
+
+	dt, err := h.DateTime()
+	if err != nil || dt.Year() != 2019 || dt.Month() != 11 || dt.Day() != 21 {
+		t.Errorf("unexpected date time %v (%v)", dt, err)
+	}
+
+	if v, ok := h.Get("ScanRegion"); !ok || v != "156,241,1940,6060" {
+		t.Errorf("unexpected raw value %q", v)
+	}
+
+	if v, ok := h.Get("Comment"); !ok || v != "" {
+		t.Errorf("unexpected empty value %q", v)
+	}
+
+	if _, err := h.value("Missing"); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+func Test_Read_Quality(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := g.Rows[0]
+
+	if row.Flags != FlagNone || row.Circularity != 100 || row.FPixels != 80 || row.BPixels != 560 {
+		t.Errorf("unexpected quality values: %+v", row)
+	}
+
+	if c := row.Channel(650); c.PercentAbove2SD != 90 {
+		t.Errorf("unexpected %% > B+2SD: %v", c.PercentAbove2SD)
+	}
+
+	var bad int
+	for _, row := range g.Rows {
+		if row.Flags == FlagBad {
+			bad++
+		}
+	}
+
+	if bad != 1 {
+		t.Errorf("expected 1 bad spot, got %d", bad)
+	}
+}
+
+func Test_Filter(t *testing.T) {
+	g := &GPR{
+		Rows: []Row{
+			{ID: "a", Flags: FlagGood},
+			{ID: "b", Flags: FlagBad},
+			{ID: "c", Flags: FlagNotFound},
+			{ID: "d"},
+			{ID: "e", Flags: FlagNotFound},
+			{ID: "f", Flags: FlagAbsent},
+		},
+	}
+
+	f, ex := g.Filter(ExcludeFlagged)
+
+	if len(f.Rows) != 2 || f.Rows[0].ID != "a" || f.Rows[1].ID != "d" {
+		t.Errorf("unexpected rows: %+v", f.Rows)
+	}
+
+	if ex.Total() != 4 {
+		t.Errorf("expected 4 exclusions, got %d", ex.Total())
+	}
+
+	if ex["Not Found"] != 2 || ex["Bad"] != 1 || ex["Absent"] != 1 {
+		t.Errorf("unexpected exclusions: %v", ex)
+	}
+
+	if s := ex.String(); s != "Absent: 1, Bad: 1, Not Found: 2" {
+		t.Errorf("unexpected exclusions string %q", s)
+	}
+
+	if len(g.Rows) != 6 {
+		t.Error("filter should not modify the original")
+	}
+}
+
+func Test_Reader(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Header.Type() != "GenePix Results 3" || len(r.Wavelengths) != 2 {
+		t.Errorf("unexpected header: %v %v", r.Header.Type(), r.Wavelengths)
+	}
+
+	var n int
+	for r.Next() {
+		if r.Row().ID == "" {
+			t.Errorf("row %d has no id", n)
+		}
+
+		n++
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 24 {
+		t.Errorf("expected 24 rows, got %d", n)
+	}
+}
+
+func Test_Reader_Options(t *testing.T) {
+	path := filepath.Join("testdata", "test1.gpr")
+	opts := []Option{WithBackground(Subtract{}), WithNegativePolicy(Clamp{Floor: 1})}
+
+	g, err := Read(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	r, err := NewReader(f, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []Row
+	for r.Next() {
+		rows = append(rows, r.Row())
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rows, g.Rows) {
+		t.Error("expected streamed rows to match the parsed rows")
+	}
+
+	for _, opt := range []Option{WithBackground(Normexp{}), WithBackground(MovingMinimum{}), WithNegativePolicy(Offset{})} {
+		if _, err := NewReader(strings.NewReader(singleChannel), opt); err == nil {
+			t.Error("expected a whole array option to fail")
+		}
+	}
+}
+
+func Test_Parse_Gzip(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Rows) != 24 {
+		t.Errorf("expected 24 rows, got %d", len(g.Rows))
+	}
+}
+
+func Test_Parse_InvalidRow(t *testing.T) {
+	content := singleChannel + "1\t2\t1\t\"P2\"\t100\r\n"
+
+	r, err := NewReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Next() {
+		t.Fatal("expected the first row to be read")
+	}
+
+	if r.Next() {
+		t.Fatal("expected the short row to stop iteration")
+	}
+
+	if r.Err() == nil || !strings.Contains(r.Err().Error(), "line 6") {
+		t.Errorf("unexpected error: %v", r.Err())
+	}
+}
@@ -0,0 +1,195 @@
+package gpr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderType        = "Type"
+	HeaderDateTime    = "DateTime"
+	HeaderSettings    = "Settings"
+	HeaderGalFile     = "GalFile"
+	HeaderPixelSize   = "PixelSize"
+	HeaderWavelengths = "Wavelengths"
+	HeaderImageFiles  = "ImageFiles"
+	HeaderCreator     = "Creator"
+	HeaderScanner     = "Scanner"
+	HeaderTemperature = "Temperature"
+	HeaderPMTGain     = "PMTGain"
+	HeaderLaserPower  = "LaserPower"
+)
+
+// DateTimeFormat is the layout GenePix uses for the DateTime header.
+const DateTimeFormat = "2006/01/02 15:04:05"
+
+var ErrNoHeader = errors.New("header not present")
+
+// Header holds the optional header records of an ATF file. Values are kept
+// as written in the file, multi-valued records are tab separated.
+type Header struct {
+	Version string
+	Raw     map[string]string
+
+	keys []string
+}
+
+// Keys returns the header keys in the order they appear in the file.
+func (h *Header) Keys() []string {
+	return h.keys
+}
+
+func (h *Header) Clone() Header {
+	c := Header{
+		Version: h.Version,
+		Raw:     make(map[string]string, len(h.Raw)),
+		keys:    append([]string(nil), h.keys...),
+	}
+
+	for k, v := range h.Raw {
+		c.Raw[k] = v
+	}
+
+	return c
+}
+
+func (h *Header) Get(key string) (string, bool) {
+	v, ok := h.Raw[key]
+	return v, ok
+}
+
+func (h *Header) Set(key, value string) {
+	if h.Raw == nil {
+		h.Raw = make(map[string]string)
+	}
+
+	if _, ok := h.Raw[key]; !ok {
+		h.keys = append(h.keys, key)
+	}
+
+	h.Raw[key] = value
+}
+
+func (h *Header) Type() string {
+	return h.Raw[HeaderType]
+}
+
+func (h *Header) Settings() string {
+	return h.Raw[HeaderSettings]
+}
+
+func (h *Header) GalFile() string {
+	return h.Raw[HeaderGalFile]
+}
+
+func (h *Header) Creator() string {
+	return h.Raw[HeaderCreator]
+}
+
+func (h *Header) Scanner() string {
+	return h.Raw[HeaderScanner]
+}
+
+func (h *Header) ImageFiles() []string {
+	return h.list(HeaderImageFiles)
+}
+
+func (h *Header) DateTime() (time.Time, error) {
+	v, err := h.value(HeaderDateTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(DateTimeFormat, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header: %w", HeaderDateTime, err)
+	}
+
+	return t, nil
+}
+
+// PixelSize returns the scan resolution in micrometres per pixel.
+func (h *Header) PixelSize() (float64, error) {
+	return h.float(HeaderPixelSize)
+}
+
+func (h *Header) Temperature() (float64, error) {
+	return h.float(HeaderTemperature)
+}
+
+func (h *Header) Wavelengths() ([]int, error) {
+	fs, err := h.floats(HeaderWavelengths)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := make([]int, len(fs))
+	for i, f := range fs {
+		ws[i] = int(f)
+	}
+
+	return ws, nil
+}
+
+func (h *Header) PMTGain() ([]float64, error) {
+	return h.floats(HeaderPMTGain)
+}
+
+func (h *Header) LaserPower() ([]float64, error) {
+	return h.floats(HeaderLaserPower)
+}
+
+func (h *Header) value(key string) (string, error) {
+	v, ok := h.Raw[key]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", key, ErrNoHeader)
+	}
+
+	return v, nil
+}
+
+func (h *Header) list(key string) []string {
+	v, ok := h.Raw[key]
+	if !ok || v == "" {
+		return nil
+	}
+
+	return strings.Split(v, "\t")
+}
+
+func (h *Header) float(key string) (float64, error) {
+	v, err := h.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header: %w", key, err)
+	}
+
+	return f, nil
+}
+
+func (h *Header) floats(key string) ([]float64, error) {
+	if _, err := h.value(key); err != nil {
+		return nil, err
+	}
+
+	parts := h.list(key)
+	fs := make([]float64, 0, len(parts))
+
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", key, err)
+		}
+
+		fs = append(fs, f)
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,120 @@
+package gpr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key identifies the same spot or protein on different arrays.
+type Key int
+
+const (
+	// ByID matches rows by protein ID, for arrays that have been summarised
+	// or printed with different layouts.
+	ByID Key = iota + 1
+
+	// ByPosition matches rows by Block, Column and Row, for arrays printed
+	// with the same layout.
+	ByPosition
+)
+
+func (k Key) String() string {
+	switch k {
+	case ByID:
+		return "id"
+	case ByPosition:
+		return "position"
+	default:
+		return ""
+	}
+}
+
+func ParseKey(name string) (Key, error) {
+	switch strings.ToLower(name) {
+	case "id":
+		return ByID, nil
+	case "position":
+		return ByPosition, nil
+	default:
+		return 0, fmt.Errorf("unknown key %q", name)
+	}
+}
+
+// Of returns the key of a row.
+func (k Key) Of(r Row) string {
+	if k == ByPosition {
+		return fmt.Sprintf("%d:%d:%d", r.Block, r.Column, r.Row)
+	}
+
+	return r.ID
+}
+
+// Pair holds the rows of two arrays sharing a key.
+type Pair struct {
+	Key   string
+	Left  Row
+	Right Row
+}
+
+// Join is the result of matching the rows of two arrays by key.
+type Join struct {
+	// Inner holds the rows found in both arrays, in the order of the left.
+	Inner []Pair
+
+	// Left and Right hold the rows found only in that array.
+	Left  []Row
+	Right []Row
+}
+
+// Aligned reports whether every row was found in both arrays.
+func (j *Join) Aligned() bool {
+	return len(j.Left) == 0 && len(j.Right) == 0
+}
+
+func (j *Join) String() string {
+	return fmt.Sprintf("%d matched, %d only left, %d only right", len(j.Inner), len(j.Left), len(j.Right))
+}
+
+// JoinRows matches the rows of left and right by key. Keys must be unique
+// within each array.
+func JoinRows(left, right *GPR, key Key) (*Join, error) {
+	index := make(map[string]int, len(right.Rows))
+	for i, r := range right.Rows {
+		k := key.Of(r)
+		if _, ok := index[k]; ok {
+			return nil, fmt.Errorf("duplicate %s %q in right array", key, k)
+		}
+
+		index[k] = i
+	}
+
+	j := &Join{}
+	matched := make([]bool, len(right.Rows))
+	seen := make(map[string]bool, len(left.Rows))
+
+	for _, l := range left.Rows {
+		k := key.Of(l)
+		if seen[k] {
+			return nil, fmt.Errorf("duplicate %s %q in left array", key, k)
+		}
+
+		seen[k] = true
+
+		i, ok := index[k]
+		if !ok {
+			j.Left = append(j.Left, l)
+			continue
+		}
+
+		matched[i] = true
+		j.Inner = append(j.Inner, Pair{Key: k, Left: l, Right: right.Rows[i]})
+	}
+
+	for i, r := range right.Rows {
+		if !matched[i] {
+			j.Right = append(j.Right, r)
+		}
+	}
+
+	return j, nil
+}
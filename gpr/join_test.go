@@ -0,0 +1,62 @@
+package gpr
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_JoinRows(t *testing.T) {
+	left := &GPR{Rows: []Row{{ID: "A"}, {ID: "B"}, {ID: "C"}}}
+	right := &GPR{Rows: []Row{{ID: "C"}, {ID: "D"}, {ID: "A"}}}
+
+	j, err := JoinRows(left, right, ByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j.Aligned() {
+		t.Error("expected the arrays not to be aligned")
+	}
+
+	if len(j.Inner) != 2 || j.Inner[0].Key != "A" || j.Inner[1].Key != "C" || j.Inner[1].Right.ID != "C" {
+		t.Errorf("unexpected inner join: %+v", j.Inner)
+	}
+
+	if len(j.Left) != 1 || j.Left[0].ID != "B" || len(j.Right) != 1 || j.Right[0].ID != "D" {
+		t.Errorf("unexpected mismatches: %v", j)
+	}
+
+	right.Rows = append(right.Rows, Row{ID: "A"})
+	if _, err := JoinRows(left, right, ByID); err == nil {
+		t.Error("expected an error for duplicate keys")
+	}
+}
+
+func Test_JoinRows_ByPosition(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := JoinRows(g, g, ByID); err == nil {
+		t.Error("expected an error for replicate IDs")
+	}
+
+	other := g.Clone().SortByID()
+	other.Rows = other.Rows[1:]
+
+	j, err := JoinRows(g, other, ByPosition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(j.Inner) != len(g.Rows)-1 || len(j.Left) != 1 || len(j.Right) != 0 {
+		t.Errorf("unexpected join: %v", j)
+	}
+
+	for _, p := range j.Inner {
+		if p.Left.ID != p.Right.ID {
+			t.Errorf("rows %q and %q joined at %s", p.Left.ID, p.Right.ID, p.Key)
+		}
+	}
+}
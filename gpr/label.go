@@ -0,0 +1,65 @@
+package gpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Label names the reagent detected at a scanned wavelength, for example
+// IgG=550.
+type Label struct {
+	Name       string
+	Wavelength int
+}
+
+func (l Label) String() string {
+	return fmt.Sprintf("%s=%d", l.Name, l.Wavelength)
+}
+
+type Labels []Label
+
+// ParseLabels parses labels in the form NAME=WAVELENGTH.
+func ParseLabels(specs []string) (Labels, error) {
+	labels := make(Labels, 0, len(specs))
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid label %q, expected NAME=WAVELENGTH", spec)
+		}
+
+		w, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid wavelength in label %q: %w", spec, err)
+		}
+
+		labels = append(labels, Label{Name: strings.TrimSpace(parts[0]), Wavelength: w})
+	}
+
+	return labels, nil
+}
+
+// Name returns the name of the label for the wavelength, or an empty string
+// if no label uses it.
+func (l Labels) Name(wavelength int) string {
+	for _, label := range l {
+		if label.Wavelength == wavelength {
+			return label.Name
+		}
+	}
+
+	return ""
+}
+
+// Check returns an error if any label refers to a wavelength that is not
+// present in g.
+func (l Labels) Check(g *GPR) error {
+	for _, label := range l {
+		if !g.HasWavelength(label.Wavelength) {
+			return fmt.Errorf("no %d channel for %s, file has %v", label.Wavelength, label.Name, g.Wavelengths)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package gpr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// NegativePolicy decides what happens to negative background subtracted
+// means and medians, and negative SNRs, after a file is read.
+type NegativePolicy interface {
+	Apply(g *GPR)
+	String() string
+}
+
+// rowPolicy is a negative value policy that can be applied to each row on
+// its own, as the rows are read.
+type rowPolicy interface {
+	applyRow(r *Row)
+}
+
+// KeepRaw leaves negative values as they were read.
+type KeepRaw struct{}
+
+func (KeepRaw) Apply(g *GPR) {}
+
+func (KeepRaw) applyRow(r *Row) {}
+
+func (KeepRaw) String() string {
+	return "raw"
+}
+
+// Clamp replaces negative background subtracted values with Floor and
+// negative SNRs with SNRFloor.
+type Clamp struct {
+	Floor    float64
+	SNRFloor float64
+}
+
+func (c Clamp) Apply(g *GPR) {
+	for i := range g.Rows {
+		c.applyRow(&g.Rows[i])
+	}
+}
+
+func (c Clamp) applyRow(r *Row) {
+	for j := range r.Channels {
+		ch := &r.Channels[j]
+
+		if ch.MeanMinusBackground < 0 {
+			ch.MeanMinusBackground = c.Floor
+		}
+
+		if ch.MedianMinusBackground < 0 {
+			ch.MedianMinusBackground = c.Floor
+		}
+
+		if ch.SNR < 0 {
+			ch.SNR = c.SNRFloor
+		}
+	}
+}
+
+func (c Clamp) String() string {
+	return fmt.Sprintf("clamp (floor %v, snr floor %v)", c.Floor, c.SNRFloor)
+}
+
+// Missing replaces negative values with NaN so they are treated as missing.
+type Missing struct{}
+
+func (m Missing) Apply(g *GPR) {
+	for i := range g.Rows {
+		m.applyRow(&g.Rows[i])
+	}
+}
+
+func (Missing) applyRow(r *Row) {
+	nan := math.NaN()
+
+	for j := range r.Channels {
+		ch := &r.Channels[j]
+
+		if ch.MeanMinusBackground < 0 {
+			ch.MeanMinusBackground = nan
+		}
+
+		if ch.MedianMinusBackground < 0 {
+			ch.MedianMinusBackground = nan
+		}
+
+		if ch.SNR < 0 {
+			ch.SNR = nan
+		}
+	}
+}
+
+func (Missing) String() string {
+	return "missing"
+}
+
+// Offset shifts the background subtracted values of each channel so that
+// the smallest value in the array becomes Floor. The smallest value is taken
+// over the sample spots that pass Filters, ignoring missing values. Channels
+// without negative values are left unchanged. SNRs are not modified.
+type Offset struct {
+	Floor   float64
+	Filters []Filter
+}
+
+func (o Offset) Apply(g *GPR) {
+	samples, _ := g.Filter(append([]Filter{SamplesOnly}, o.Filters...)...)
+
+	for _, w := range g.Wavelengths {
+		meanMin, medianMin := math.Inf(1), math.Inf(1)
+
+		for _, row := range samples.Rows {
+			c := row.Channel(w)
+
+			if c.MeanMinusBackground < meanMin {
+				meanMin = c.MeanMinusBackground
+			}
+
+			if c.MedianMinusBackground < medianMin {
+				medianMin = c.MedianMinusBackground
+			}
+		}
+
+		for i := range g.Rows {
+			for j := range g.Rows[i].Channels {
+				ch := &g.Rows[i].Channels[j]
+				if ch.Wavelength != w {
+					continue
+				}
+
+				if meanMin < 0 {
+					ch.MeanMinusBackground += o.Floor - meanMin
+				}
+
+				if medianMin < 0 {
+					ch.MedianMinusBackground += o.Floor - medianMin
+				}
+			}
+		}
+	}
+}
+
+func (o Offset) String() string {
+	return fmt.Sprintf("offset (floor %v)", o.Floor)
+}
+
+// NegativePolicies lists the names accepted by ParseNegativePolicy.
+var NegativePolicies = []string{"raw", "clamp", "missing", "offset"}
+
+// ParseNegativePolicy returns the policy with the given name. The clamp
+// policy uses a floor of 1 and an SNR floor of 0, offset uses a floor of 1
+// and takes the smallest value over the sample spots that pass filters.
+func ParseNegativePolicy(name string, filters ...Filter) (NegativePolicy, error) {
+	switch strings.ToLower(name) {
+	case "raw":
+		return KeepRaw{}, nil
+	case "clamp":
+		return Clamp{Floor: 1, SNRFloor: 0}, nil
+	case "missing":
+		return Missing{}, nil
+	case "offset":
+		return Offset{Floor: 1, Filters: filters}, nil
+	default:
+		return nil, fmt.Errorf("unknown negative value policy %q", name)
+	}
+}
@@ -0,0 +1,104 @@
+package gpr
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func negativeRow(g *GPR) Row {
+	for _, row := range g.Rows {
+		if row.ID == "C3030" && row.Column == 2 {
+			return row
+		}
+	}
+
+	return Row{}
+}
+
+func Test_NegativePolicy(t *testing.T) {
+	path := filepath.Join("testdata", "test1.gpr")
+
+	g, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := negativeRow(g).Channel(650); c.MedianMinusBackground != -14 {
+		t.Errorf("expected raw value -14, got %v", c.MedianMinusBackground)
+	}
+
+	clamp, err := ParseNegativePolicy("clamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err = Read(path, WithNegativePolicy(clamp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := negativeRow(g).Channel(650); c.MedianMinusBackground != 1 || c.MeanMinusBackground != 31 {
+		t.Errorf("unexpected clamped values: %+v", c)
+	}
+
+	g, err = Read(path, WithNegativePolicy(Missing{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := negativeRow(g).Channel(550); !math.IsNaN(c.MedianMinusBackground) || c.MeanMinusBackground != 24 {
+		t.Errorf("unexpected missing values: %+v", c)
+	}
+
+	g, err = Read(path, WithNegativePolicy(Offset{Floor: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := negativeRow(g).Channel(650); c.MedianMinusBackground != 1 || c.MeanMinusBackground != 31 {
+		t.Errorf("unexpected offset values: %+v", c)
+	}
+
+	if c := g.Rows[0].Channel(650); c.MedianMinusBackground != 4712+15 {
+		t.Errorf("expected the offset to shift every spot, got %v", c.MedianMinusBackground)
+	}
+
+	if _, err := ParseNegativePolicy("zero"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func Test_Offset_Filters(t *testing.T) {
+	spot := func(v float64, control Control, flags Flag) Row {
+		return Row{Control: control, Flags: flags, Channels: []Channel{{Wavelength: 532, MeanMinusBackground: v, MedianMinusBackground: v}}}
+	}
+
+	g := &GPR{
+		Wavelengths: []int{532},
+		Rows: []Row{
+			spot(-5, Sample, FlagNone),
+			spot(-10, Sample, FlagBad),
+			spot(-20, NegativeControl, FlagNone),
+			spot(math.NaN(), Sample, FlagNone),
+			spot(10, Sample, FlagNone),
+		},
+	}
+
+	offset, err := ParseNegativePolicy("offset", ExcludeFlagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset.Apply(g)
+
+	for i, want := range []float64{1, -4, -14} {
+		if c := g.Rows[i].Channel(532); c.MedianMinusBackground != want || c.MeanMinusBackground != want {
+			t.Errorf("row %d: expected %v, got %+v", i, want, c)
+		}
+	}
+
+	if c := g.Rows[3].Channel(532); !math.IsNaN(c.MedianMinusBackground) {
+		t.Errorf("expected a missing value to stay missing, got %v", c.MedianMinusBackground)
+	}
+}
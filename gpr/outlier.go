@@ -0,0 +1,252 @@
+package gpr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// OutlierTest finds the replicates of one protein and channel that should
+// be rejected, returning the reason for each rejected index of xs.
+type OutlierTest interface {
+	Outliers(xs []float64) map[int]string
+	String() string
+}
+
+// MADTest rejects replicates whose robust z-score, based on the median
+// absolute deviation, is greater than Threshold. It needs at least three
+// replicates.
+type MADTest struct {
+	Threshold float64
+}
+
+func (m MADTest) Outliers(xs []float64) map[int]string {
+	if len(stats.Values(xs)) < 3 {
+		return nil
+	}
+
+	median, mad := stats.Median(xs), stats.MAD(xs)
+	if mad == 0 {
+		return nil
+	}
+
+	out := map[int]string{}
+	for i, x := range xs {
+		if z := 0.6745 * (x - median) / mad; math.Abs(z) > m.Threshold {
+			out[i] = fmt.Sprintf("robust z %.2f exceeds %v", z, m.Threshold)
+		}
+	}
+
+	return out
+}
+
+func (m MADTest) String() string {
+	return fmt.Sprintf("mad (threshold %v)", m.Threshold)
+}
+
+// GrubbsTest repeatedly rejects the replicate furthest from the mean while
+// Grubbs' test is significant at Alpha. It needs at least three replicates.
+type GrubbsTest struct {
+	Alpha float64
+}
+
+func (g GrubbsTest) Outliers(xs []float64) map[int]string {
+	out := map[int]string{}
+
+	for {
+		var kept []float64
+		for i, x := range xs {
+			if _, ok := out[i]; !ok && !math.IsNaN(x) {
+				kept = append(kept, x)
+			}
+		}
+
+		n := float64(len(kept))
+		if n < 3 {
+			return out
+		}
+
+		mean, sd := stats.Mean(kept), stats.SD(kept)
+		if sd == 0 {
+			return out
+		}
+
+		worst, stat := -1, 0.0
+		for i, x := range xs {
+			if _, ok := out[i]; ok || math.IsNaN(x) {
+				continue
+			}
+
+			if s := math.Abs(x-mean) / sd; s > stat {
+				worst, stat = i, s
+			}
+		}
+
+		t := stats.StudentTQuantile(1-g.Alpha/(2*n), n-2)
+		critical := (n - 1) / math.Sqrt(n) * math.Sqrt(t*t/(n-2+t*t))
+
+		if stat <= critical {
+			return out
+		}
+
+		out[worst] = fmt.Sprintf("grubbs G %.2f exceeds %.2f", stat, critical)
+	}
+}
+
+func (g GrubbsTest) String() string {
+	return fmt.Sprintf("grubbs (alpha %v)", g.Alpha)
+}
+
+// FoldTest rejects the brightest replicate while the largest replicate is
+// more than Fold times the smallest, as dust and other artifacts increase
+// the signal of a spot. It needs at least two replicates.
+type FoldTest struct {
+	Fold float64
+}
+
+func (f FoldTest) Outliers(xs []float64) map[int]string {
+	out := map[int]string{}
+
+	for {
+		var idx []int
+		for i, x := range xs {
+			if _, ok := out[i]; !ok && !math.IsNaN(x) {
+				idx = append(idx, i)
+			}
+		}
+
+		if len(idx) < 2 {
+			return out
+		}
+
+		sort.Slice(idx, func(i, j int) bool {
+			return xs[idx[i]] < xs[idx[j]]
+		})
+
+		lo, hi := xs[idx[0]], xs[idx[len(idx)-1]]
+		if lo <= 0 || hi/lo <= f.Fold {
+			return out
+		}
+
+		out[idx[len(idx)-1]] = fmt.Sprintf("%.2f fold above lowest replicate", hi/lo)
+	}
+}
+
+func (f FoldTest) String() string {
+	return fmt.Sprintf("fold (max %v)", f.Fold)
+}
+
+// OutlierTests lists the names accepted by ParseOutlierTest.
+var OutlierTests = []string{"mad", "grubbs", "fold"}
+
+// ParseOutlierTest returns the test with the given name. A threshold of zero
+// uses the default of 3.5 for mad, 0.05 for grubbs and 2 for fold.
+func ParseOutlierTest(name string, threshold float64) (OutlierTest, error) {
+	switch strings.ToLower(name) {
+	case "mad":
+		if threshold == 0 {
+			threshold = 3.5
+		}
+
+		return MADTest{Threshold: threshold}, nil
+	case "grubbs":
+		if threshold == 0 {
+			threshold = 0.05
+		}
+
+		return GrubbsTest{Alpha: threshold}, nil
+	case "fold":
+		if threshold == 0 {
+			threshold = 2
+		}
+
+		return FoldTest{Fold: threshold}, nil
+	default:
+		return nil, fmt.Errorf("unknown outlier test %q", name)
+	}
+}
+
+// Rejection records a replicate spot rejected as an outlier in one channel.
+type Rejection struct {
+	ID         string
+	Block      int
+	Column     int
+	Row        int
+	Wavelength int
+	Value      float64
+	Reason     string
+}
+
+// RejectOutliers applies the test to the background subtracted medians of
+// the replicates of every protein and channel. Rejected channels are marked
+// with the reason, which excludes them when summarising, and are returned.
+func (g *GPR) RejectOutliers(test OutlierTest) (*GPR, []Rejection) {
+	c := g.Clone()
+	rows := c.Rows
+	byID := map[string][]int{}
+
+	for i, row := range rows {
+		byID[row.ID] = append(byID[row.ID], i)
+	}
+
+	var rejections []Rejection
+
+	for _, id := range sortedKeys(byID) {
+		idx := byID[id]
+
+		for _, w := range g.Wavelengths {
+			xs := make([]float64, len(idx))
+			for j, i := range idx {
+				xs[j] = rows[i].Channel(w).MedianMinusBackground
+			}
+
+			out := test.Outliers(xs)
+
+			for _, j := range sortedIndexes(out) {
+				row := &rows[idx[j]]
+				for k := range row.Channels {
+					if row.Channels[k].Wavelength == w {
+						row.Channels[k].Outlier = out[j]
+					}
+				}
+
+				rejections = append(rejections, Rejection{
+					ID:         row.ID,
+					Block:      row.Block,
+					Column:     row.Column,
+					Row:        row.Row,
+					Wavelength: w,
+					Value:      xs[j],
+					Reason:     out[j],
+				})
+			}
+		}
+	}
+
+	return c, rejections
+}
+
+func sortedKeys(m map[string][]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedIndexes(m map[int]string) []int {
+	idx := make([]int, 0, len(m))
+	for i := range m {
+		idx = append(idx, i)
+	}
+
+	sort.Ints(idx)
+
+	return idx
+}
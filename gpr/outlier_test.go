@@ -0,0 +1,71 @@
+package gpr
+
+import (
+	"testing"
+)
+
+func Test_OutlierTests(t *testing.T) {
+	tests := []struct {
+		test     OutlierTest
+		xs       []float64
+		expected []int
+	}{
+		{MADTest{Threshold: 3.5}, []float64{100, 102, 98, 101, 400}, []int{4}},
+		{MADTest{Threshold: 3.5}, []float64{100, 400}, nil},
+		{GrubbsTest{Alpha: 0.05}, []float64{100, 102, 98, 101, 99, 400}, []int{5}},
+		{GrubbsTest{Alpha: 0.05}, []float64{100, 102, 98}, nil},
+		{FoldTest{Fold: 2}, []float64{100, 250}, []int{1}},
+		{FoldTest{Fold: 2}, []float64{100, 150, 900, 1000}, []int{2, 3}},
+		{FoldTest{Fold: 2}, []float64{100, 150}, nil},
+	}
+
+	for _, tt := range tests {
+		out := tt.test.Outliers(tt.xs)
+		idx := sortedIndexes(out)
+
+		if len(idx) != len(tt.expected) {
+			t.Errorf("%s %v: expected %v rejected, got %v", tt.test, tt.xs, tt.expected, out)
+			continue
+		}
+
+		for i := range idx {
+			if idx[i] != tt.expected[i] {
+				t.Errorf("%s %v: expected %v rejected, got %v", tt.test, tt.xs, tt.expected, out)
+			}
+		}
+	}
+}
+
+func Test_RejectOutliers(t *testing.T) {
+	var rows []Row
+	rows = append(rows, replicates("A", 100, 104, 420)...)
+	rows = append(rows, replicates("B", 10, 11, 12)...)
+
+	for i := range rows {
+		rows[i].Row = i + 1
+	}
+
+	g := &GPR{Wavelengths: []int{635}, Rows: rows}
+
+	rejected, rejections := g.RejectOutliers(FoldTest{Fold: 2})
+	if len(rejections) != 1 || rejections[0].ID != "A" || rejections[0].Row != 3 || rejections[0].Value != 420 {
+		t.Fatalf("unexpected rejections: %+v", rejections)
+	}
+
+	if g.Rows[2].Channel(635).Outlier != "" {
+		t.Error("expected original rows to be unchanged")
+	}
+
+	if rejected.Rows[2].Channel(635).Outlier == "" {
+		t.Error("expected rejected channel to be marked")
+	}
+
+	avg, _ := DefaultSummariser.Summarise(rejected)
+	if c := avg.Rows[0].Channel(635); c.MedianMinusBackground != 102 {
+		t.Errorf("expected outlier to be ignored, got %v", c.MedianMinusBackground)
+	}
+
+	if _, err := ParseOutlierTest("dixon", 0); err == nil {
+		t.Error("expected error for unknown test")
+	}
+}
@@ -0,0 +1,425 @@
+package gpr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/atf"
+)
+
+const (
+	colBlock    = "Block"
+	colColumn   = "Column"
+	colRow      = "Row"
+	colName     = "Name"
+	colID       = "ID"
+	colX        = "X"
+	colY        = "Y"
+	colDiameter = "Dia."
+
+	colFlags       = "Flags"
+	colCircularity = "Circularity"
+	colFPixels     = "F Pixels"
+	colBPixels     = "B Pixels"
+)
+
+var (
+	requiredColumns = []string{
+		colBlock,
+		colColumn,
+		colRow,
+		colID,
+		colX,
+		colY,
+		colDiameter,
+	}
+
+	medianColumnRegex = regexp.MustCompile(`^F(\d+) Median$`)
+)
+
+func colMedian(w int) string                { return fmt.Sprintf("F%d Median", w) }
+func colMedianMinusBackground(w int) string { return fmt.Sprintf("F%d Median - B%d", w, w) }
+func colMeanMinusBackground(w int) string   { return fmt.Sprintf("F%d Mean - B%d", w, w) }
+func colSNR(w int) string                   { return fmt.Sprintf("SNR %d", w) }
+func colPercentAbove2SD(w int) string       { return fmt.Sprintf("%% > B%d+2SD", w) }
+func colPercentSaturated(w int) string      { return fmt.Sprintf("F%d %% Sat.", w) }
+func colMean(w int) string                  { return fmt.Sprintf("F%d Mean", w) }
+func colBackgroundMedian(w int) string      { return fmt.Sprintf("B%d Median", w) }
+func colBackgroundMean(w int) string        { return fmt.Sprintf("B%d Mean", w) }
+
+// rawColumns are the foreground and background columns needed to correct
+// the background.
+func rawColumns(w int) []string {
+	return []string{colMedian(w), colMean(w), colBackgroundMedian(w), colBackgroundMean(w)}
+}
+
+func channelColumns(w int) []string {
+	return []string{
+		colMedian(w),
+		colMedianMinusBackground(w),
+		colMeanMinusBackground(w),
+		colSNR(w),
+	}
+}
+
+// wavelengths returns the wavelengths of the channels present in the
+// column titles, in the order they appear.
+func wavelengths(titles []string) []int {
+	var ws []int
+
+	for _, title := range titles {
+		m := medianColumnRegex.FindStringSubmatch(strings.TrimSpace(title))
+		if m == nil {
+			continue
+		}
+
+		w, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+
+		ws = append(ws, w)
+	}
+
+	return ws
+}
+
+// Reader reads the rows of a GPR file one at a time. The preamble, header
+// and column titles are read by NewReader.
+type Reader struct {
+	Header      Header
+	Wavelengths []int
+	Columns     []string
+
+	r          *atf.Reader
+	idx        map[string]int
+	rules      Rules
+	background rowCorrection
+	negative   rowPolicy
+	row        Row
+	err        error
+}
+
+// NewReader reads the ATF preamble, header records and column titles from r.
+// Gzip compressed input is detected and decompressed. The background
+// correction and negative value policy are applied to each row as it is
+// read, those that need every row of the array, such as normexp, movingmin
+// and offset, are refused and must be applied by Parse or Read.
+func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
+	o := newOptions(opts)
+
+	background, ok := o.background.(rowCorrection)
+	if !ok {
+		return nil, fmt.Errorf("background correction %s needs every row of the array, use Parse", o.background)
+	}
+
+	negative, ok := o.negative.(rowPolicy)
+	if !ok {
+		return nil, fmt.Errorf("negative value policy %s needs every row of the array, use Parse", o.negative)
+	}
+
+	rd, err := newReader(r, o.rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, genepix := o.background.(GenePix); !genepix {
+		if err := checkRaw(&GPR{Wavelengths: rd.Wavelengths, Columns: rd.Columns}); err != nil {
+			return nil, err
+		}
+	}
+
+	rd.background, rd.negative = background, negative
+
+	return rd, nil
+}
+
+// newReader reads the column titles without correcting the rows.
+func newReader(r io.Reader, rules Rules) (*Reader, error) {
+	ar, err := atf.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header := Header{Version: ar.Version}
+	for _, f := range ar.Header {
+		header.Set(f.Key, f.Value)
+	}
+
+	ws := wavelengths(ar.Columns)
+	if len(ws) == 0 {
+		return nil, errors.New("no channels found in column titles")
+	}
+
+	required := append([]string{}, requiredColumns...)
+	for _, w := range ws {
+		required = append(required, channelColumns(w)...)
+	}
+
+	idx, err := atf.Index(ar.Columns, required...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		Header:      header,
+		Wavelengths: ws,
+		Columns:     ar.Columns,
+		r:           ar,
+		idx:         idx,
+		rules:       rules,
+	}, nil
+}
+
+// Next advances to the next row, returning false at the end of the input or
+// on error.
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	line, err := r.r.Read()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+
+		return false
+	}
+
+	row, err := r.parse(line)
+	if err != nil {
+		r.err = fmt.Errorf("line %d: %w", r.r.Line(), err)
+		return false
+	}
+
+	if r.background != nil {
+		r.background.correctRow(&row)
+		r.negative.applyRow(&row)
+	}
+
+	row.Control = r.rules.Classify(row)
+	r.row = row
+
+	return true
+}
+
+// Row returns the row read by the last call to Next.
+func (r *Reader) Row() Row {
+	return r.row
+}
+
+// Err returns the first error encountered while reading rows.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) parse(line []string) (Row, error) {
+	rec := record{line: line, idx: r.idx}
+
+	row := Row{
+		ID:       rec.str(colID),
+		Name:     rec.optionalStr(colName),
+		Block:    rec.int(colBlock),
+		Column:   rec.int(colColumn),
+		Row:      rec.int(colRow),
+		X:        rec.int(colX),
+		Y:        rec.int(colY),
+		Diameter: rec.int(colDiameter),
+		Channels: make([]Channel, len(r.Wavelengths)),
+		raw:      append([]string(nil), line...),
+
+		// quality columns depend on the GenePix version
+		Flags:       Flag(rec.optionalInt(colFlags)),
+		Circularity: rec.optionalFloat(colCircularity),
+		FPixels:     rec.optionalInt(colFPixels),
+		BPixels:     rec.optionalInt(colBPixels),
+	}
+
+	for i, w := range r.Wavelengths {
+		row.Channels[i] = Channel{
+			Wavelength:            w,
+			Median:                rec.float(colMedian(w)),
+			MedianMinusBackground: rec.float(colMedianMinusBackground(w)),
+			MeanMinusBackground:   rec.float(colMeanMinusBackground(w)),
+			SNR:                   rec.float(colSNR(w)),
+			PercentAbove2SD:       rec.optionalFloat(colPercentAbove2SD(w)),
+			PercentSaturated:      rec.optionalFloat(colPercentSaturated(w)),
+			Mean:                  rec.optionalFloat(colMean(w)),
+			BackgroundMedian:      rec.optionalFloat(colBackgroundMedian(w)),
+			BackgroundMean:        rec.optionalFloat(colBackgroundMean(w)),
+		}
+	}
+
+	return row, rec.err
+}
+
+// Option configures NewReader, Parse and Read.
+type Option func(*options)
+
+type options struct {
+	background BackgroundCorrection
+	negative   NegativePolicy
+	rules      Rules
+}
+
+func newOptions(opts []Option) options {
+	o := options{background: GenePix{}, negative: KeepRaw{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithBackground sets the background correction applied once every row has
+// been read, before the negative value policy. The values computed by
+// GenePix are kept by default.
+func WithBackground(c BackgroundCorrection) Option {
+	return func(o *options) {
+		o.background = c
+	}
+}
+
+// WithNegativePolicy sets the policy applied to negative values once every
+// row has been read. Values are kept as read by default.
+func WithNegativePolicy(p NegativePolicy) Option {
+	return func(o *options) {
+		o.negative = p
+	}
+}
+
+// WithRules sets the rules used to classify control spots. Without rules
+// every spot is a sample.
+func WithRules(rules Rules) Option {
+	return func(o *options) {
+		o.rules = rules
+	}
+}
+
+// Parse reads every row of a GPR file from r.
+func Parse(r io.Reader, opts ...Option) (*GPR, error) {
+	o := newOptions(opts)
+
+	// corrections are applied once every row has been read
+	rd, err := newReader(r, o.rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+
+	for rd.Next() {
+		rows = append(rows, rd.Row())
+	}
+
+	if err := rd.Err(); err != nil {
+		return nil, err
+	}
+
+	g := &GPR{
+		Header:      rd.Header,
+		Wavelengths: rd.Wavelengths,
+		Columns:     rd.Columns,
+		Rows:        rows,
+	}
+
+	if err := o.background.Correct(g); err != nil {
+		return nil, err
+	}
+
+	o.negative.Apply(g)
+
+	return g, nil
+}
+
+// Read parses the GPR file at path.
+func Read(path string, opts ...Option) (*GPR, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	g, err := Parse(f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return g, nil
+}
+
+// record reads typed values from a data line by column title. The first
+// parse error is kept and later reads return zero values.
+type record struct {
+	line []string
+	idx  map[string]int
+	err  error
+}
+
+func (r *record) str(name string) string {
+	return r.line[r.idx[name]]
+}
+
+func (r *record) optionalStr(name string) string {
+	if _, ok := r.idx[name]; !ok {
+		return ""
+	}
+
+	return r.str(name)
+}
+
+func (r *record) int(name string) int {
+	if r.err != nil {
+		return 0
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(r.str(name)))
+	if err != nil {
+		r.err = fmt.Errorf("column %q: %w", name, err)
+	}
+
+	return v
+}
+
+func (r *record) optionalInt(name string) int {
+	if _, ok := r.idx[name]; !ok {
+		return 0
+	}
+
+	return r.int(name)
+}
+
+func (r *record) optionalFloat(name string) float64 {
+	if _, ok := r.idx[name]; !ok {
+		return 0
+	}
+
+	return r.float(name)
+}
+
+// float reads a number, an empty field is a missing value.
+func (r *record) float(name string) float64 {
+	if r.err != nil {
+		return 0
+	}
+
+	s := strings.TrimSpace(r.str(name))
+	if s == "" {
+		return math.NaN()
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		r.err = fmt.Errorf("column %q: %w", name, err)
+	}
+
+	return v
+}
@@ -0,0 +1,278 @@
+package gpr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Control is the role a spot plays in the print layout.
+type Control int
+
+const (
+	Sample Control = iota
+	NegativeControl
+	PositiveControl
+	BufferControl
+	Excluded
+)
+
+func (c Control) String() string {
+	switch c {
+	case Sample:
+		return "sample"
+	case NegativeControl:
+		return "negative"
+	case PositiveControl:
+		return "positive"
+	case BufferControl:
+		return "buffer"
+	case Excluded:
+		return "excluded"
+	default:
+		return fmt.Sprintf("control %d", int(c))
+	}
+}
+
+func ParseControl(s string) (Control, error) {
+	for _, c := range []Control{Sample, NegativeControl, PositiveControl, BufferControl, Excluded} {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+
+	return Sample, fmt.Errorf("unknown control %q", s)
+}
+
+// Range is an inclusive range of block, column or row numbers. The zero
+// Range matches every number.
+type Range struct {
+	Min, Max int
+}
+
+func (r Range) Contains(n int) bool {
+	if r.Min == 0 && r.Max == 0 {
+		return true
+	}
+
+	return n >= r.Min && n <= r.Max
+}
+
+func (r Range) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ParseRange parses a single number or a range such as 17-18. Numbers start
+// at 1, the zero Range can not be given.
+func ParseRange(s string) (Range, error) {
+	parts := strings.SplitN(s, "-", 2)
+
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+
+	max := min
+	if len(parts) == 2 {
+		max, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+	}
+
+	if min < 1 || max < min {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+
+	return Range{Min: min, Max: max}, nil
+}
+
+// Rule assigns a Control to the spots it matches. A spot matches when it
+// falls within every range and matches every pattern that is set.
+type Rule struct {
+	Control Control
+	Blocks  Range
+	Columns Range
+	Rows    Range
+	ID      *regexp.Regexp
+	Name    *regexp.Regexp
+}
+
+func (r Rule) Match(row Row) bool {
+	if !r.Blocks.Contains(row.Block) || !r.Columns.Contains(row.Column) || !r.Rows.Contains(row.Row) {
+		return false
+	}
+
+	if r.ID != nil && !r.ID.MatchString(row.ID) {
+		return false
+	}
+
+	if r.Name != nil && !r.Name.MatchString(row.Name) {
+		return false
+	}
+
+	return true
+}
+
+// Rules classify spots, the first matching rule wins and spots matching no
+// rule are samples.
+type Rules []Rule
+
+func (rs Rules) Classify(row Row) Control {
+	if c, ok := rs.find(row); ok {
+		return c
+	}
+
+	return Sample
+}
+
+func (rs Rules) find(row Row) (Control, bool) {
+	for _, r := range rs {
+		if r.Match(row) {
+			return r.Control, true
+		}
+	}
+
+	return Sample, false
+}
+
+// Classify sets the control of every row in g matched by a rule, used when
+// the identity of spots has changed since they were read. Rows matching no
+// rule keep their control.
+func (g *GPR) Classify(rules Rules) {
+	for i := range g.Rows {
+		if c, ok := rules.find(g.Rows[i]); ok {
+			g.Rows[i].Control = c
+		}
+	}
+}
+
+// DefaultRules excludes spots with an ID of "empty" or "blank", the
+// convention used when printing unused positions.
+var DefaultRules = Rules{
+	{Control: Excluded, ID: regexp.MustCompile(`(?i)^(empty|blank)$`)},
+}
+
+// LegacyRules also excludes rows 17 and 18, the layout of the original 56
+// column IgG/IgM chips that was assumed before rules could be given. The
+// commands use them unless told otherwise.
+var LegacyRules = Rules{
+	{Control: Excluded, Rows: Range{17, 18}},
+	{Control: Excluded, ID: regexp.MustCompile(`(?i)^(empty|blank)$`)},
+}
+
+// BuiltinRules are the rules that can be named in place of a rules file.
+var BuiltinRules = map[string]Rules{
+	"default": DefaultRules,
+	"legacy":  LegacyRules,
+}
+
+// ParseRules reads rules, one per line, in the form
+//
+//	CONTROL [block=RANGE] [column=RANGE] [row=RANGE] [id=REGEXP] [name=REGEXP]
+//
+// where CONTROL is one of negative, positive, buffer, excluded or sample.
+// Blank lines and lines starting with # are ignored.
+func ParseRules(r io.Reader) (Rules, error) {
+	var rules Rules
+
+	scan := bufio.NewScanner(r)
+
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseRule(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func ReadRules(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ParseRules(f)
+}
+
+// LoadRules returns the built in rules called name, or reads the rules file
+// at name.
+func LoadRules(name string) (Rules, error) {
+	if rules, ok := BuiltinRules[name]; ok {
+		return rules, nil
+	}
+
+	return ReadRules(name)
+}
+
+func parseRule(fields []string) (Rule, error) {
+	control, err := ParseControl(fields[0])
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule := Rule{Control: control}
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return Rule{}, fmt.Errorf("invalid condition %q, expected KEY=VALUE", field)
+		}
+
+		key, value := strings.ToLower(parts[0]), parts[1]
+
+		switch key {
+		case "block":
+			rule.Blocks, err = ParseRange(value)
+		case "column":
+			rule.Columns, err = ParseRange(value)
+		case "row":
+			rule.Rows, err = ParseRange(value)
+		case "id":
+			rule.ID, err = regexp.Compile(value)
+		case "name":
+			rule.Name, err = regexp.Compile(value)
+		default:
+			err = fmt.Errorf("unknown condition %q", key)
+		}
+
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+
+	return rule, nil
+}
+
+// SamplesOnly excludes every spot that is not a sample, giving its control
+// as the reason.
+func SamplesOnly(r Row) (string, bool) {
+	if r.Control != Sample {
+		return r.Control.String(), true
+	}
+
+	return "", false
+}
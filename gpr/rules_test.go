@@ -0,0 +1,92 @@
+package gpr
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Rules(t *testing.T) {
+	rules, err := ReadRules(filepath.Join("testdata", "controls.rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Read(filepath.Join("testdata", "test1.gpr"), WithRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[Control]int{}
+	for _, row := range g.Rows {
+		counts[row.Control]++
+	}
+
+	expected := map[Control]int{
+		Sample:          14,
+		PositiveControl: 4,
+		NegativeControl: 2,
+		BufferControl:   2,
+		Excluded:        2,
+	}
+
+	for c, n := range expected {
+		if counts[c] != n {
+			t.Errorf("expected %d %s spots, got %d", n, c, counts[c])
+		}
+	}
+
+	f, ex := g.Filter(SamplesOnly)
+	if len(f.Rows) != 14 || ex.Total() != 10 || ex["positive"] != 4 {
+		t.Errorf("unexpected samples: %d rows, exclusions %v", len(f.Rows), ex)
+	}
+}
+
+func Test_Rules_Legacy(t *testing.T) {
+	for _, name := range []string{"legacy", filepath.Join("testdata", "legacy.rules")} {
+		rules, err := LoadRules(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testLegacy(t, rules)
+	}
+}
+
+func testLegacy(t *testing.T, rules Rules) {
+	t.Helper()
+
+	rows := []Row{
+		{ID: "P1", Row: 16},
+		{ID: "P1", Row: 17},
+		{ID: "P1", Row: 18},
+		{ID: "Empty", Row: 1},
+		{ID: "BLANK", Row: 1},
+		{ID: "blanket", Row: 1},
+	}
+
+	expected := []Control{Sample, Excluded, Excluded, Excluded, Excluded, Sample}
+
+	for i, row := range rows {
+		if c := rules.Classify(row); c != expected[i] {
+			t.Errorf("row %d: expected %s, got %s", i, expected[i], c)
+		}
+	}
+}
+
+func Test_ParseRules_Invalid(t *testing.T) {
+	for _, line := range []string{
+		"unknown row=1",
+		"excluded row",
+		"excluded row=a",
+		"excluded row=18-17",
+		"excluded row=0",
+		"excluded column=0-2",
+		"excluded id=(",
+		"excluded colour=red",
+	} {
+		if _, err := ParseRules(strings.NewReader(line)); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
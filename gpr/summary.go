@@ -0,0 +1,209 @@
+package gpr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Statistic is the measure of central tendency used to combine replicate
+// spots.
+type Statistic int
+
+const (
+	Mean Statistic = iota + 1
+	Median
+	TrimmedMean
+)
+
+func (s Statistic) String() string {
+	switch s {
+	case Mean:
+		return "mean"
+	case Median:
+		return "median"
+	case TrimmedMean:
+		return "trimmed"
+	default:
+		return ""
+	}
+}
+
+// Statistics lists the names accepted by ParseStatistic.
+var Statistics = []string{"mean", "median", "trimmed"}
+
+func ParseStatistic(name string) (Statistic, error) {
+	switch strings.ToLower(name) {
+	case "mean":
+		return Mean, nil
+	case "median":
+		return Median, nil
+	case "trimmed":
+		return TrimmedMean, nil
+	default:
+		return 0, fmt.Errorf("unknown statistic %q", name)
+	}
+}
+
+// Spread describes the variation between the replicate spots a summarised
+// value was computed from.
+type Spread struct {
+	SD float64
+	CV float64
+}
+
+// Summariser combines the replicate spots of each protein into a single row.
+type Summariser struct {
+	Statistic Statistic
+
+	// Trim is the fraction of values dropped from each end by TrimmedMean.
+	Trim float64
+
+	// Replicates is the number of spots expected for each protein, zero
+	// accepts any number.
+	Replicates int
+}
+
+// DefaultSummariser takes the mean of any number of replicates.
+var DefaultSummariser = Summariser{Statistic: Mean}
+
+func (s Summariser) String() string {
+	if s.Statistic == TrimmedMean {
+		return fmt.Sprintf("%s (%v)", s.Statistic, s.Trim)
+	}
+
+	return s.Statistic.String()
+}
+
+// ReplicateCount records a protein printed a different number of times than
+// expected.
+type ReplicateCount struct {
+	ID       string
+	Count    int
+	Expected int
+}
+
+func (r ReplicateCount) String() string {
+	return fmt.Sprintf("%s: %d replicates, expected %d", r.ID, r.Count, r.Expected)
+}
+
+func (s Summariser) value(xs []float64) float64 {
+	switch s.Statistic {
+	case Median:
+		return stats.Median(xs)
+	case TrimmedMean:
+		return stats.TrimmedMean(xs, s.Trim)
+	default:
+		return stats.Mean(xs)
+	}
+}
+
+var missingChannel = Channel{
+	Median:                math.NaN(),
+	Mean:                  math.NaN(),
+	MeanMinusBackground:   math.NaN(),
+	MedianMinusBackground: math.NaN(),
+	SNR:                   math.NaN(),
+	PercentAbove2SD:       math.NaN(),
+	PercentSaturated:      math.NaN(),
+	BackgroundMedian:      math.NaN(),
+	BackgroundMean:        math.NaN(),
+}
+
+// Summarise returns a GPR holding one row per protein, combining its
+// replicate spots with the chosen statistic. Channels rejected as outliers
+// are ignored. Proteins with a replicate count other than the expected one
+// are still summarised and are also returned. Each row keeps the position of
+// its first replicate.
+func (s Summariser) Summarise(g *GPR) (*GPR, []ReplicateCount) {
+	var ids []string
+	m := map[string][]Row{}
+
+	for _, row := range g.Rows {
+		if _, ok := m[row.ID]; !ok {
+			ids = append(ids, row.ID)
+		}
+
+		m[row.ID] = append(m[row.ID], row)
+	}
+
+	sort.Strings(ids)
+
+	var unexpected []ReplicateCount
+	rows := make([]Row, 0, len(ids))
+
+	for _, id := range ids {
+		reps := m[id]
+
+		if s.Replicates > 0 && len(reps) != s.Replicates {
+			unexpected = append(unexpected, ReplicateCount{ID: id, Count: len(reps), Expected: s.Replicates})
+		}
+
+		rows = append(rows, s.summariseRow(g.Wavelengths, reps))
+	}
+
+	return g.withRows(rows), unexpected
+}
+
+func (s Summariser) summariseRow(wavelengths []int, reps []Row) Row {
+	channels := make([]Channel, len(wavelengths))
+	n := len(reps)
+
+	for i, w := range wavelengths {
+		median := make([]float64, n)
+		fmean := make([]float64, n)
+		bmedian := make([]float64, n)
+		bmean := make([]float64, n)
+		mean := make([]float64, n)
+		medianB := make([]float64, n)
+		snr := make([]float64, n)
+		above := make([]float64, n)
+		saturated := make([]float64, n)
+
+		for j, r := range reps {
+			c := r.Channel(w)
+			if c.Outlier != "" {
+				c = missingChannel
+			}
+
+			median[j] = c.Median
+			fmean[j] = c.Mean
+			bmedian[j] = c.BackgroundMedian
+			bmean[j] = c.BackgroundMean
+			mean[j] = c.MeanMinusBackground
+			medianB[j] = c.MedianMinusBackground
+			snr[j] = c.SNR
+			above[j] = c.PercentAbove2SD
+			saturated[j] = c.PercentSaturated
+		}
+
+		channels[i] = Channel{
+			Wavelength:            w,
+			Median:                s.value(median),
+			Mean:                  s.value(fmean),
+			BackgroundMedian:      s.value(bmedian),
+			BackgroundMean:        s.value(bmean),
+			MeanMinusBackground:   s.value(mean),
+			MedianMinusBackground: s.value(medianB),
+			SNR:                   s.value(snr),
+			PercentAbove2SD:       s.value(above),
+			PercentSaturated:      s.value(saturated),
+			MeanSpread:            Spread{SD: stats.SD(mean), CV: stats.CV(mean)},
+			MedianSpread:          Spread{SD: stats.SD(medianB), CV: stats.CV(medianB)},
+		}
+	}
+
+	return Row{
+		ID:         reps[0].ID,
+		Name:       reps[0].Name,
+		Control:    reps[0].Control,
+		Block:      reps[0].Block,
+		Column:     reps[0].Column,
+		Row:        reps[0].Row,
+		Replicates: n,
+		Channels:   channels,
+	}
+}
@@ -0,0 +1,53 @@
+package gpr
+
+import (
+	"math"
+	"testing"
+)
+
+func replicates(id string, values ...float64) []Row {
+	rows := make([]Row, len(values))
+	for i, v := range values {
+		rows[i] = Row{ID: id, Channels: []Channel{{Wavelength: 635, MedianMinusBackground: v, MeanMinusBackground: v}}}
+	}
+
+	return rows
+}
+
+func Test_Summarise(t *testing.T) {
+	var rows []Row
+	rows = append(rows, replicates("A", 10, 20, 30)...)
+	rows = append(rows, replicates("B", 10, 20)...)
+	rows = append(rows, replicates("C", 1, 2, 3, 100)...)
+
+	g := &GPR{Wavelengths: []int{635}, Rows: rows}
+
+	avg, unexpected := Summariser{Statistic: Mean, Replicates: 3}.Summarise(g)
+	if len(avg.Rows) != 3 {
+		t.Fatalf("expected 3 proteins, got %d", len(avg.Rows))
+	}
+
+	if len(unexpected) != 2 || unexpected[0].ID != "B" || unexpected[0].Count != 2 || unexpected[1].ID != "C" || unexpected[1].Count != 4 {
+		t.Errorf("unexpected replicate counts: %v", unexpected)
+	}
+
+	a := avg.Rows[0]
+	if c := a.Channel(635); a.ID != "A" || a.Replicates != 3 || c.MedianMinusBackground != 20 || c.MedianSpread.SD != 10 || c.MedianSpread.CV != 0.5 {
+		t.Errorf("unexpected summary: %+v", a)
+	}
+
+	med, _ := Summariser{Statistic: Median}.Summarise(g)
+	if c := med.Rows[2].Channel(635); c.MedianMinusBackground != 2.5 {
+		t.Errorf("expected median 2.5, got %v", c.MedianMinusBackground)
+	}
+
+	trimmed, _ := Summariser{Statistic: TrimmedMean, Trim: 0.25}.Summarise(g)
+	if c := trimmed.Rows[2].Channel(635); c.MedianMinusBackground != 2.5 {
+		t.Errorf("expected trimmed mean 2.5, got %v", c.MedianMinusBackground)
+	}
+
+	single, _ := DefaultSummariser.Summarise(&GPR{Wavelengths: []int{635}, Rows: replicates("D", 5)})
+	if c := single.Rows[0].Channel(635); c.MedianMinusBackground != 5 || !math.IsNaN(c.MedianSpread.SD) {
+		t.Errorf("unexpected single replicate summary: %+v", c)
+	}
+}
@@ -0,0 +1,197 @@
+package gpr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/atf"
+)
+
+// quotedColumns are always written as quoted strings, as is every other
+// column that holds text rather than numbers.
+var quotedColumns = map[string]bool{
+	colName: true,
+	colID:   true,
+}
+
+// Write writes g to w as an ATF file. The header records and columns of g
+// are kept, with the record counts updated to match. Columns that are not
+// modelled by Row are written as they were read.
+func Write(w io.Writer, g *GPR) error {
+	bw := bufio.NewWriter(w)
+
+	cols := g.Columns
+	if len(cols) == 0 {
+		cols = defaultColumns(g.Wavelengths)
+	}
+
+	version := g.Header.Version
+	if version == "" {
+		version = "1.0"
+	}
+
+	keys := g.Header.Keys()
+
+	fmt.Fprintf(bw, "ATF\t%s\r\n", version)
+	fmt.Fprintf(bw, "%d\t%d\r\n", len(keys), len(cols))
+
+	for _, key := range keys {
+		v, _ := g.Header.Get(key)
+		fmt.Fprintf(bw, "%s\r\n", atf.Quote(key+"="+v))
+	}
+
+	titles := make([]string, len(cols))
+	for i, col := range cols {
+		titles[i] = atf.Quote(col)
+	}
+
+	fmt.Fprintf(bw, "%s\r\n", strings.Join(titles, "\t"))
+
+	values := fieldValues(g.Wavelengths)
+	lines := make([][]string, len(g.Rows))
+
+	for j, row := range g.Rows {
+		line := make([]string, len(cols))
+
+		for i, col := range cols {
+			var v, raw string
+
+			if len(row.raw) == len(cols) {
+				raw = row.raw[i]
+			}
+
+			v = raw
+
+			// modelled values are only written when they no longer match
+			// the value read, keeping the original formatting otherwise
+			if f, ok := values[col]; ok {
+				if x := f(row); !sameValue(x, raw) {
+					v = x
+				}
+			}
+
+			line[i] = v
+		}
+
+		lines[j] = line
+	}
+
+	quote := make([]bool, len(cols))
+	for i, col := range cols {
+		quote[i] = quotedColumns[col] || text(lines, i)
+	}
+
+	for _, line := range lines {
+		for i := range line {
+			if quote[i] {
+				line[i] = atf.Quote(line[i])
+			}
+		}
+
+		if _, err := fmt.Fprintf(bw, "%s\r\n", strings.Join(line, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func sameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+
+	y, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if err != nil {
+		return false
+	}
+
+	return x == y
+}
+
+// text reports whether column i holds values but none of them are numbers.
+// GenePix writes errors such as "Error" unquoted in numeric columns.
+func text(lines [][]string, i int) bool {
+	found := false
+
+	for _, line := range lines {
+		v := strings.TrimSpace(line[i])
+		if v == "" {
+			continue
+		}
+
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return false
+		}
+
+		found = true
+	}
+
+	return found
+}
+
+// formatFloat formats a value, leaving missing values empty.
+func formatFloat(f float64) string {
+	if math.IsNaN(f) {
+		return ""
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// fieldValues maps the titles of the columns modelled by Row to functions
+// returning their value.
+func fieldValues(ws []int) map[string]func(Row) string {
+	m := map[string]func(Row) string{
+		colBlock:       func(r Row) string { return strconv.Itoa(r.Block) },
+		colColumn:      func(r Row) string { return strconv.Itoa(r.Column) },
+		colRow:         func(r Row) string { return strconv.Itoa(r.Row) },
+		colName:        func(r Row) string { return r.Name },
+		colID:          func(r Row) string { return r.ID },
+		colX:           func(r Row) string { return strconv.Itoa(r.X) },
+		colY:           func(r Row) string { return strconv.Itoa(r.Y) },
+		colDiameter:    func(r Row) string { return strconv.Itoa(r.Diameter) },
+		colFlags:       func(r Row) string { return strconv.Itoa(int(r.Flags)) },
+		colCircularity: func(r Row) string { return formatFloat(r.Circularity) },
+		colFPixels:     func(r Row) string { return strconv.Itoa(r.FPixels) },
+		colBPixels:     func(r Row) string { return strconv.Itoa(r.BPixels) },
+	}
+
+	for _, w := range ws {
+		w := w
+
+		m[colMedian(w)] = func(r Row) string { return formatFloat(r.Channel(w).Median) }
+		m[colMedianMinusBackground(w)] = func(r Row) string { return formatFloat(r.Channel(w).MedianMinusBackground) }
+		m[colMeanMinusBackground(w)] = func(r Row) string { return formatFloat(r.Channel(w).MeanMinusBackground) }
+		m[colSNR(w)] = func(r Row) string { return formatFloat(r.Channel(w).SNR) }
+		m[colPercentAbove2SD(w)] = func(r Row) string { return formatFloat(r.Channel(w).PercentAbove2SD) }
+		m[colPercentSaturated(w)] = func(r Row) string { return formatFloat(r.Channel(w).PercentSaturated) }
+		m[colMean(w)] = func(r Row) string { return formatFloat(r.Channel(w).Mean) }
+		m[colBackgroundMedian(w)] = func(r Row) string { return formatFloat(r.Channel(w).BackgroundMedian) }
+		m[colBackgroundMean(w)] = func(r Row) string { return formatFloat(r.Channel(w).BackgroundMean) }
+	}
+
+	return m
+}
+
+// defaultColumns returns the columns written for a GPR that was not read
+// from a file.
+func defaultColumns(ws []int) []string {
+	cols := append([]string{}, requiredColumns...)
+
+	for _, w := range ws {
+		cols = append(cols, channelColumns(w)...)
+		cols = append(cols, colPercentAbove2SD(w))
+	}
+
+	return append(cols, colFlags)
+}
@@ -0,0 +1,211 @@
+package gpr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func roundTrip(t *testing.T, g *GPR) *GPR {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func Test_Write_RoundTrip(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := roundTrip(t, g)
+
+	if !reflect.DeepEqual(g.Header, out.Header) {
+		t.Errorf("header changed:\n%+v\n%+v", g.Header, out.Header)
+	}
+
+	if !reflect.DeepEqual(g.Columns, out.Columns) {
+		t.Errorf("columns changed:\n%v\n%v", g.Columns, out.Columns)
+	}
+
+	if !reflect.DeepEqual(g.Wavelengths, out.Wavelengths) {
+		t.Errorf("wavelengths changed: %v %v", g.Wavelengths, out.Wavelengths)
+	}
+
+	if len(g.Rows) != len(out.Rows) {
+		t.Fatalf("expected %d rows, got %d", len(g.Rows), len(out.Rows))
+	}
+
+	for i := range g.Rows {
+		if !reflect.DeepEqual(g.Rows[i], out.Rows[i]) {
+			t.Errorf("row %d changed:\n%+v\n%+v", i, g.Rows[i], out.Rows[i])
+		}
+	}
+}
+
+func Test_Write_Formatting(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every line written must appear unchanged in the original file
+	original := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\r\n") {
+		original[line] = true
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if !original[line] {
+			t.Errorf("line not in original file: %q", line)
+		}
+	}
+}
+
+func Test_Write_Modified(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Rows[0].ID = "renamed"
+	g.Rows[0].Flags = FlagBad
+	g.Rows[0].Channels[0].MedianMinusBackground = 12.5
+	g.Header.Set("Comment", "re-flagged")
+	g.Header.Set("Reviewer", "nadams")
+
+	out := roundTrip(t, g)
+
+	if len(out.Header.Keys()) != 31 {
+		t.Errorf("expected 31 header records, got %d", len(out.Header.Keys()))
+	}
+
+	if v, _ := out.Header.Get("Comment"); v != "re-flagged" {
+		t.Errorf("unexpected comment %q", v)
+	}
+
+	if v, _ := out.Header.Get("Reviewer"); v != "nadams" {
+		t.Errorf("unexpected reviewer %q", v)
+	}
+
+	row := out.Rows[0]
+
+	if row.ID != "renamed" || row.Flags != FlagBad || row.Channel(650).MedianMinusBackground != 12.5 {
+		t.Errorf("modifications were not written: %+v", row)
+	}
+
+	ratio := indexOf(out.Columns, "Ratio of Medians (650/550)")
+	if ratio < 0 {
+		t.Fatal("missing ratio column")
+	}
+
+	if row.raw[ratio] != g.Rows[0].raw[ratio] {
+		t.Errorf("unmodelled column changed: %q %q", row.raw[ratio], g.Rows[0].raw[ratio])
+	}
+}
+
+func Test_Write_Missing(t *testing.T) {
+	g, err := Read(filepath.Join("testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ratio := indexOf(g.Columns, "Ratio of Medians (650/550)")
+	if ratio < 0 {
+		t.Fatal("missing ratio column")
+	}
+
+	g.Rows[0].Channels[0].MedianMinusBackground = math.NaN()
+	for i := range g.Rows {
+		g.Rows[i].raw[ratio] = "n/a"
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "NaN") {
+		t.Error("missing value written as NaN")
+	}
+
+	if !strings.Contains(buf.String(), "\t\"n/a\"\t") {
+		t.Error("text column was not quoted")
+	}
+
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := out.Rows[0].Channel(650).MedianMinusBackground; !math.IsNaN(v) {
+		t.Errorf("expected a missing value, got %v", v)
+	}
+
+	if out.Rows[1].raw[ratio] != "n/a" {
+		t.Errorf("unexpected ratio %q", out.Rows[1].raw[ratio])
+	}
+}
+
+func Test_Write_NoColumns(t *testing.T) {
+	g := &GPR{
+		Wavelengths: []int{532},
+		Rows: []Row{
+			{ID: `a "quoted" id`, Block: 1, Column: 2, Row: 3, Channels: []Channel{{Wavelength: 532, Median: 100, SNR: 2.5}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "ATF\t1.0\r\n0\t13\r\n") {
+		t.Errorf("unexpected preamble: %q", buf.String())
+	}
+
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := out.Rows[0]
+
+	if row.ID != g.Rows[0].ID || row.Column != 2 || row.Channel(532).Median != 100 || row.Channel(532).SNR != 2.5 {
+		t.Errorf("unexpected row: %+v", row)
+	}
+}
+
+func indexOf(s []string, v string) int {
+	for i, x := range s {
+		if x == v {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,429 @@
+// Package heatmap draws arrays with each spot coloured by a metric.
+package heatmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Category is a discrete value of a metric drawn in a fixed colour.
+type Category struct {
+	Value float64
+	Label string
+	Color color.RGBA
+}
+
+// Metric is the value each spot is coloured by, or its category.
+type Metric struct {
+	Name       string
+	Value      func(gpr.Row) float64
+	Categories []Category
+}
+
+func (m Metric) String() string {
+	return m.Name
+}
+
+// FlagCategories colour the flags assigned by GenePix.
+var FlagCategories = []Category{
+	{float64(gpr.FlagGood), gpr.FlagGood.String(), color.RGBA{26, 152, 80, 255}},
+	{float64(gpr.FlagNone), gpr.FlagNone.String(), color.RGBA{200, 200, 200, 255}},
+	{float64(gpr.FlagNotFound), gpr.FlagNotFound.String(), color.RGBA{254, 178, 76, 255}},
+	{float64(gpr.FlagAbsent), gpr.FlagAbsent.String(), color.RGBA{158, 154, 200, 255}},
+	{float64(gpr.FlagBad), gpr.FlagBad.String(), color.RGBA{215, 48, 39, 255}},
+}
+
+// Metrics lists the names accepted by ParseMetric.
+var Metrics = []string{"median", "mean", "snr", "background", "flags"}
+
+// ParseMetric returns the named metric of the given channel.
+func ParseMetric(name string, wavelength int) (Metric, error) {
+	switch strings.ToLower(name) {
+	case "median":
+		return Metric{Name: fmt.Sprintf("F%d Median - B%d", wavelength, wavelength), Value: func(r gpr.Row) float64 {
+			return r.Channel(wavelength).MedianMinusBackground
+		}}, nil
+	case "mean":
+		return Metric{Name: fmt.Sprintf("F%d Mean - B%d", wavelength, wavelength), Value: func(r gpr.Row) float64 {
+			return r.Channel(wavelength).MeanMinusBackground
+		}}, nil
+	case "snr":
+		return Metric{Name: fmt.Sprintf("SNR %d", wavelength), Value: func(r gpr.Row) float64 {
+			return r.Channel(wavelength).SNR
+		}}, nil
+	case "background":
+		return Metric{Name: fmt.Sprintf("B%d Median", wavelength), Value: func(r gpr.Row) float64 {
+			return r.Channel(wavelength).BackgroundMedian
+		}}, nil
+	case "flags":
+		return Metric{Name: "Flags", Value: func(r gpr.Row) float64 {
+			return float64(r.Flags)
+		}, Categories: FlagCategories}, nil
+	default:
+		return Metric{}, fmt.Errorf("unknown metric %q", name)
+	}
+}
+
+// Options control how a heatmap is drawn.
+type Options struct {
+	Metric Metric
+	Scale  Scale
+
+	// Log colours the log10 of the metric.
+	Log bool
+
+	// Min and Max are the limits of the colour scale, percentiles if nil.
+	Min, Max *float64
+
+	Title string
+
+	// Spot is the size of a spot in pixels, 12 if zero.
+	Spot int
+
+	// Face is the font of the title and legend, the gg default if nil.
+	Face font.Face
+}
+
+const (
+	margin       = 20
+	titleHeight  = 30
+	legendWidth  = 150
+	legendHeight = 200
+	blockGap     = 1
+)
+
+// cell is the position of a spot on the drawing grid.
+type cell struct {
+	x, y int
+}
+
+// layout places each row of g on a grid, with blocks arranged as printed.
+func layout(g *gpr.GPR) ([]cell, int, int) {
+	type extent struct {
+		cols, rows int
+		x, y       int
+		seen       bool
+	}
+
+	blocks := map[int]*extent{}
+	var maxCols, maxRows int
+
+	for _, r := range g.Rows {
+		e, ok := blocks[r.Block]
+		if !ok {
+			e = &extent{}
+			blocks[r.Block] = e
+		}
+
+		if r.Column > e.cols {
+			e.cols = r.Column
+		}
+
+		if r.Row > e.rows {
+			e.rows = r.Row
+		}
+
+		if !e.seen || r.X < e.x {
+			e.x = r.X
+		}
+
+		if !e.seen || r.Y < e.y {
+			e.y = r.Y
+		}
+
+		e.seen = true
+
+		if e.cols > maxCols {
+			maxCols = e.cols
+		}
+
+		if e.rows > maxRows {
+			maxRows = e.rows
+		}
+	}
+
+	numbers := make([]int, 0, len(blocks))
+	var xs, ys []int
+
+	for n, e := range blocks {
+		numbers = append(numbers, n)
+		xs = append(xs, e.x)
+		ys = append(ys, e.y)
+	}
+
+	sort.Ints(numbers)
+
+	position := map[int]cell{}
+
+	if positioned(xs) || positioned(ys) {
+		// blocks closer than half a block share a printed column or row
+		xi := clusters(xs)
+		yi := clusters(ys)
+
+		for _, n := range numbers {
+			position[n] = cell{xi[blocks[n].x], yi[blocks[n].y]}
+		}
+	} else {
+		per := int(math.Ceil(math.Sqrt(float64(len(numbers)))))
+		for i, n := range numbers {
+			position[n] = cell{i % per, i / per}
+		}
+	}
+
+	var width, height int
+	cells := make([]cell, len(g.Rows))
+
+	for i, r := range g.Rows {
+		p := position[r.Block]
+		c := cell{
+			x: p.x*(maxCols+blockGap) + r.Column - 1,
+			y: p.y*(maxRows+blockGap) + r.Row - 1,
+		}
+
+		cells[i] = c
+
+		if c.x+1 > width {
+			width = c.x + 1
+		}
+
+		if c.y+1 > height {
+			height = c.y + 1
+		}
+	}
+
+	return cells, width, height
+}
+
+// positioned reports whether the coordinates differ.
+func positioned(vs []int) bool {
+	for _, v := range vs {
+		if v != vs[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clusters numbers the groups of coordinates in ascending order.
+func clusters(vs []int) map[int]int {
+	sorted := append([]int{}, vs...)
+	sort.Ints(sorted)
+
+	var largest int
+	for i := 1; i < len(sorted); i++ {
+		if d := sorted[i] - sorted[i-1]; d > largest {
+			largest = d
+		}
+	}
+
+	index := map[int]int{}
+	n := 0
+
+	for i, v := range sorted {
+		if i > 0 && v-sorted[i-1] > largest/4 {
+			n++
+		}
+
+		index[v] = n
+	}
+
+	return index
+}
+
+// Render draws the heatmap of g.
+func Render(g *gpr.GPR, opts Options) (image.Image, error) {
+	if len(g.Rows) == 0 {
+		return nil, fmt.Errorf("no spots to draw")
+	}
+
+	if opts.Metric.Value == nil {
+		return nil, fmt.Errorf("no metric to draw")
+	}
+
+	if len(opts.Scale.Stops) == 0 {
+		opts.Scale = Viridis
+	}
+
+	spot := opts.Spot
+	if spot == 0 {
+		spot = 12
+	}
+
+	values := make([]float64, len(g.Rows))
+	for i, r := range g.Rows {
+		v := opts.Metric.Value(r)
+		if opts.Log {
+			if v > 0 {
+				v = math.Log10(v)
+			} else {
+				v = math.NaN()
+			}
+		}
+
+		values[i] = v
+	}
+
+	lo, hi, err := limits(values, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cells, cols, rows := layout(g)
+
+	width := 2*margin + cols*spot + legendWidth
+	height := 2*margin + titleHeight + rows*spot
+	if h := 2*margin + titleHeight + legendHeight; h > height {
+		height = h
+	}
+
+	dc := gg.NewContext(width, height)
+	if opts.Face != nil {
+		dc.SetFontFace(opts.Face)
+	}
+
+	dc.SetColor(color.White)
+	dc.Clear()
+
+	dc.SetColor(color.Black)
+	title := opts.Title
+	if title == "" {
+		title = opts.Metric.Name
+	}
+
+	dc.DrawStringAnchored(title, margin, margin+titleHeight/2, 0, 0.5)
+
+	top := float64(margin + titleHeight)
+	r := float64(spot) * 0.42
+
+	for i, c := range cells {
+		dc.SetColor(colorOf(opts, values[i], lo, hi))
+		dc.DrawCircle(margin+float64(c.x*spot)+float64(spot)/2, top+float64(c.y*spot)+float64(spot)/2, r)
+		dc.Fill()
+	}
+
+	legend(dc, opts, float64(margin+cols*spot+margin), top, lo, hi)
+
+	return dc.Image(), nil
+}
+
+// limits returns the range of the colour scale for the values.
+func limits(values []float64, opts Options) (float64, float64, error) {
+	limit := func(v *float64, q float64) (float64, error) {
+		switch {
+		case v == nil:
+			return stats.Quantile(values, q), nil
+		case !opts.Log:
+			return *v, nil
+		case *v <= 0:
+			return 0, fmt.Errorf("colour scale limit %g must be positive on a log scale", *v)
+		default:
+			return math.Log10(*v), nil
+		}
+	}
+
+	lo, err := limit(opts.Min, 0.01)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hi, err := limit(opts.Max, 0.99)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lo, hi, nil
+}
+
+func colorOf(opts Options, v, lo, hi float64) color.RGBA {
+	if math.IsNaN(v) {
+		return missingColor
+	}
+
+	if len(opts.Metric.Categories) > 0 {
+		for _, c := range opts.Metric.Categories {
+			if c.Value == v {
+				return c.Color
+			}
+		}
+
+		return missingColor
+	}
+
+	if hi == lo {
+		return opts.Scale.Color(0.5)
+	}
+
+	return opts.Scale.Color((v - lo) / (hi - lo))
+}
+
+// legend draws the categories of the metric or the colour scale.
+func legend(dc *gg.Context, opts Options, x, y, lo, hi float64) {
+	dc.SetColor(color.Black)
+
+	if len(opts.Metric.Categories) > 0 {
+		for i, c := range opts.Metric.Categories {
+			cy := y + float64(i)*20
+
+			dc.SetColor(c.Color)
+			dc.DrawRectangle(x, cy, 14, 14)
+			dc.Fill()
+
+			dc.SetColor(color.Black)
+			dc.DrawStringAnchored(c.Label, x+20, cy+7, 0, 0.5)
+		}
+
+		return
+	}
+
+	const bar = 20
+	h := float64(legendHeight - 20)
+
+	for i := 0; i < int(h); i++ {
+		dc.SetColor(opts.Scale.Color(1 - float64(i)/h))
+		dc.DrawRectangle(x, y+float64(i), bar, 1)
+		dc.Fill()
+	}
+
+	label := func(v float64) string {
+		if opts.Log {
+			v = math.Pow(10, v)
+		}
+
+		return fmt.Sprintf("%.4g", v)
+	}
+
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored(label(hi), x+bar+6, y, 0, 0.5)
+	dc.DrawStringAnchored(label((lo+hi)/2), x+bar+6, y+h/2, 0, 0.5)
+	dc.DrawStringAnchored(label(lo), x+bar+6, y+h, 0, 0.5)
+
+	dc.SetColor(missingColor)
+	dc.DrawRectangle(x, y+h+8, bar, 10)
+	dc.Fill()
+
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored("missing", x+bar+6, y+h+13, 0, 0.5)
+}
+
+// WritePNG renders the heatmap of g as a PNG.
+func WritePNG(w io.Writer, g *gpr.GPR, opts Options) error {
+	img, err := Render(g, opts)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
@@ -0,0 +1,101 @@
+package heatmap
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+func Test_Scale(t *testing.T) {
+	if c := Grey.Color(0.5); c != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("unexpected colour %v", c)
+	}
+
+	if c := Viridis.Color(2); c != Viridis.Stops[len(Viridis.Stops)-1] {
+		t.Errorf("expected the scale to be clamped, got %v", c)
+	}
+
+	if c := Heat.Color(math.NaN()); c != missingColor {
+		t.Errorf("expected the missing colour, got %v", c)
+	}
+
+	if _, err := ParseScale("rainbow"); err == nil {
+		t.Error("expected an unknown scale to fail")
+	}
+}
+
+func Test_Render(t *testing.T) {
+	g, err := gpr.Read(filepath.Join("..", "gpr", "testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cells, cols, rows := layout(g)
+	if cols != 9 || rows != 3 {
+		t.Fatalf("expected a grid of 9x3, got %dx%d", cols, rows)
+	}
+
+	for i, r := range g.Rows {
+		if r.Block == 2 && r.Column == 1 && r.Row == 1 && cells[i] != (cell{5, 0}) {
+			t.Errorf("expected block 2 to the right of block 1, got %v", cells[i])
+		}
+	}
+
+	metric, err := ParseMetric("flags", 550)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePNG(&buf, g, Options{Metric: metric}); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 2*margin+9*12+legendWidth || b.Dy() != 2*margin+titleHeight+legendHeight {
+		t.Errorf("unexpected size %v", b)
+	}
+
+	// the centre of the first spot of block 1
+	want := colorOf(Options{Metric: metric}, float64(g.Rows[0].Flags), 0, 0)
+
+	if r, gr, b, _ := img.At(margin+6, margin+titleHeight+6).RGBA(); uint8(r>>8) != want.R || uint8(gr>>8) != want.G || uint8(b>>8) != want.B {
+		t.Errorf("unexpected colour of the first spot, expected %v", want)
+	}
+
+	if _, err := Render(g, Options{}); err == nil {
+		t.Error("expected rendering without a metric to fail")
+	}
+}
+
+func Test_Limits(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = math.Log10(float64(i + 1))
+	}
+
+	limit := func(v float64) *float64 { return &v }
+
+	lo, hi, err := limits(values, Options{Log: true, Max: limit(1000)})
+	if err != nil || math.IsInf(lo, 0) || lo != stats.Quantile(values, 0.01) || hi != 3 {
+		t.Errorf("expected only the maximum to be set, got %v %v %v", lo, hi, err)
+	}
+
+	if _, _, err := limits(values, Options{Log: true, Min: limit(0)}); err == nil {
+		t.Error("expected a negative limit on a log scale to fail")
+	}
+
+	if lo, hi, _ := limits(values, Options{Min: limit(0)}); lo != 0 || hi != stats.Quantile(values, 0.99) {
+		t.Errorf("unexpected limits %v %v", lo, hi)
+	}
+}
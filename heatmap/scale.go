@@ -0,0 +1,89 @@
+package heatmap
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Scale maps a value between 0 and 1 to a colour.
+type Scale struct {
+	Name  string
+	Stops []color.RGBA
+}
+
+// Color interpolates the colour at t, which is clamped to [0, 1].
+func (s Scale) Color(t float64) color.RGBA {
+	if math.IsNaN(t) {
+		return missingColor
+	}
+
+	t = math.Max(0, math.Min(1, t))
+
+	n := len(s.Stops) - 1
+	pos := t * float64(n)
+
+	i := int(math.Floor(pos))
+	if i >= n {
+		return s.Stops[n]
+	}
+
+	f := pos - float64(i)
+	a, b := s.Stops[i], s.Stops[i+1]
+
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*f))
+	}
+
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
+
+func (s Scale) String() string {
+	return s.Name
+}
+
+var (
+	missingColor = color.RGBA{R: 64, G: 64, B: 64, A: 255}
+
+	Viridis = Scale{Name: "viridis", Stops: []color.RGBA{
+		{68, 1, 84, 255},
+		{59, 82, 139, 255},
+		{33, 145, 140, 255},
+		{94, 201, 98, 255},
+		{253, 231, 37, 255},
+	}}
+
+	Heat = Scale{Name: "heat", Stops: []color.RGBA{
+		{0, 0, 0, 255},
+		{180, 0, 0, 255},
+		{255, 140, 0, 255},
+		{255, 255, 80, 255},
+		{255, 255, 255, 255},
+	}}
+
+	Grey = Scale{Name: "grey", Stops: []color.RGBA{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+	}}
+
+	// Diverging runs from blue through white to red.
+	Diverging = Scale{Name: "diverging", Stops: []color.RGBA{
+		{33, 102, 172, 255},
+		{247, 247, 247, 255},
+		{178, 24, 43, 255},
+	}}
+)
+
+// Scales lists the names accepted by ParseScale.
+var Scales = []string{"viridis", "heat", "grey", "diverging"}
+
+func ParseScale(name string) (Scale, error) {
+	for _, s := range []Scale{Viridis, Heat, Grey, Diverging} {
+		if strings.EqualFold(name, s.Name) {
+			return s, nil
+		}
+	}
+
+	return Scale{}, fmt.Errorf("unknown colour scale %q", name)
+}
@@ -0,0 +1,276 @@
+// Package hits calls the reactive proteins of each array.
+package hits
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/experiment"
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Thresholds are the criteria of a hit, zero thresholds are not applied.
+type Thresholds struct {
+	// SD is the standard deviations above the mean of the controls of a cutoff.
+	SD float64
+
+	// Controls are the spots the cutoff is derived from, zero without.
+	Controls []gpr.Control
+
+	MinSNR float64
+
+	// MinFold is the minimum ratio to the mean of the Reference group.
+	MinFold   float64
+	Reference string
+
+	// Agreement is the minimum fraction of replicates above the cutoff.
+	Agreement float64
+}
+
+func (t Thresholds) String() string {
+	if len(t.Controls) == 0 {
+		return "above background"
+	}
+
+	names := make([]string, len(t.Controls))
+	for i, c := range t.Controls {
+		names[i] = c.String()
+	}
+
+	return fmt.Sprintf("mean + %g SD of %s spots", t.SD, strings.Join(names, ", "))
+}
+
+// Cutoff is the value a protein must exceed to be a hit on an array.
+type Cutoff struct {
+	Array string
+	Value float64
+	Mean  float64
+	SD    float64
+	N     int
+
+	// Failed gives the reason no cutoff could be derived.
+	Failed string
+}
+
+// Call is the outcome of testing a protein on an array.
+type Call struct {
+	Array     string
+	Group     string
+	ID        string
+	Value     float64
+	Cutoff    float64
+	SNR       float64
+	Fold      float64
+	Agreement float64
+	Hit       bool
+
+	// NoReference is set when the fold could not be taken because the
+	// protein has no positive reference mean, the call is neither a hit nor
+	// a miss.
+	NoReference bool
+
+	// Reason lists the criteria the protein failed.
+	Reason string
+}
+
+// Cutoff derives the cutoff of a channel of an array.
+func (t Thresholds) Cutoff(g *gpr.GPR, w int) Cutoff {
+	if len(t.Controls) == 0 {
+		return Cutoff{}
+	}
+
+	controls := map[gpr.Control]bool{}
+	for _, c := range t.Controls {
+		controls[c] = true
+	}
+
+	var xs []float64
+	for _, r := range g.Rows {
+		if controls[r.Control] {
+			xs = append(xs, r.Channel(w).MedianMinusBackground)
+		}
+	}
+
+	xs = stats.Values(xs)
+
+	c := Cutoff{N: len(xs), Mean: stats.Mean(xs), SD: stats.SD(xs)}
+
+	switch {
+	case len(xs) == 0:
+		c.Failed = "no control spots"
+	case len(xs) == 1:
+		c.Failed = "one control spot"
+	default:
+		c.Value = c.Mean + t.SD*c.SD
+	}
+
+	return c
+}
+
+// Call calls hits on every summarised array, with cutoffs and agreement taken
+// from the array of the same name in spots.
+func (t Thresholds) Call(spots, summarised *experiment.Experiment, w int) ([]Call, []Cutoff, error) {
+	var reference map[string]float64
+
+	if t.MinFold > 0 {
+		ref := summarised.Group(t.Reference)
+		if len(ref.Arrays) == 0 {
+			return nil, nil, fmt.Errorf("no arrays in reference group %q", t.Reference)
+		}
+
+		m := ref.Matrix(experiment.MedianMinusBackground(w))
+
+		reference = make(map[string]float64, len(m.Proteins))
+		for i, id := range m.Proteins {
+			reference[id] = stats.Mean(m.Row(i))
+		}
+	}
+
+	var calls []Call
+	var cutoffs []Cutoff
+
+	for _, a := range summarised.Arrays {
+		all, ok := spots.Get(a.Name)
+		if !ok {
+			return nil, nil, fmt.Errorf("no spots for array %s", a.Name)
+		}
+
+		cutoff := t.Cutoff(all.Data, w)
+		cutoff.Array = a.Name
+		cutoffs = append(cutoffs, cutoff)
+
+		if cutoff.Failed != "" {
+			continue
+		}
+
+		replicates := map[string][]float64{}
+		for _, r := range all.Data.Rows {
+			if r.Control == gpr.Sample {
+				replicates[r.ID] = append(replicates[r.ID], r.Channel(w).MedianMinusBackground)
+			}
+		}
+
+		for _, r := range a.Data.Rows {
+			c := r.Channel(w)
+
+			call := Call{
+				Array:     a.Name,
+				Group:     a.Meta.Group,
+				ID:        r.ID,
+				Value:     c.MedianMinusBackground,
+				Cutoff:    cutoff.Value,
+				SNR:       c.SNR,
+				Fold:      math.NaN(),
+				Agreement: agreement(replicates[r.ID], cutoff.Value),
+			}
+
+			if reference != nil {
+				ref, ok := reference[r.ID]
+				if !ok || !(ref > 0) {
+					call.NoReference = true
+					call.Reason = "no reference"
+					calls = append(calls, call)
+
+					continue
+				}
+
+				call.Fold = call.Value / ref
+			}
+
+			var failed []string
+
+			if !(call.Value > call.Cutoff) {
+				failed = append(failed, "below cutoff")
+			}
+
+			if t.MinSNR > 0 && !(call.SNR >= t.MinSNR) {
+				failed = append(failed, "low SNR")
+			}
+
+			if t.MinFold > 0 && !(call.Fold >= t.MinFold) {
+				failed = append(failed, "low fold")
+			}
+
+			if t.Agreement > 0 && !(call.Agreement >= t.Agreement) {
+				failed = append(failed, "replicates disagree")
+			}
+
+			call.Hit = len(failed) == 0
+			call.Reason = strings.Join(failed, ", ")
+			calls = append(calls, call)
+		}
+	}
+
+	return calls, cutoffs, nil
+}
+
+// agreement returns the fraction of replicate values above the cutoff.
+func agreement(xs []float64, cutoff float64) float64 {
+	xs = stats.Values(xs)
+	if len(xs) == 0 {
+		return math.NaN()
+	}
+
+	var n int
+	for _, x := range xs {
+		if x > cutoff {
+			n++
+		}
+	}
+
+	return float64(n) / float64(len(xs))
+}
+
+// Frequency counts the arrays of each group a protein was called a hit on.
+type Frequency struct {
+	ID     string
+	Hits   map[string]int
+	Arrays map[string]int
+}
+
+// Total returns the number of arrays the protein was a hit on.
+func (f Frequency) Total() (hits, arrays int) {
+	for g, n := range f.Arrays {
+		hits += f.Hits[g]
+		arrays += n
+	}
+
+	return hits, arrays
+}
+
+// Frequencies returns the hit frequency of every protein, sorted by ID. Calls
+// without a reference are not counted.
+func Frequencies(calls []Call) []Frequency {
+	m := map[string]*Frequency{}
+	var ids []string
+
+	for _, c := range calls {
+		if c.NoReference {
+			continue
+		}
+
+		f, ok := m[c.ID]
+		if !ok {
+			f = &Frequency{ID: c.ID, Hits: map[string]int{}, Arrays: map[string]int{}}
+			m[c.ID] = f
+			ids = append(ids, c.ID)
+		}
+
+		f.Arrays[c.Group]++
+		if c.Hit {
+			f.Hits[c.Group]++
+		}
+	}
+
+	sort.Strings(ids)
+
+	fs := make([]Frequency, len(ids))
+	for i, id := range ids {
+		fs[i] = *m[id]
+	}
+
+	return fs
+}
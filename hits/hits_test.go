@@ -0,0 +1,196 @@
+package hits
+
+import (
+	"math"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/experiment"
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/norm"
+)
+
+func spot(id string, control gpr.Control, value, snr float64) gpr.Row {
+	return gpr.Row{ID: id, Control: control, Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: value, SNR: snr}}}
+}
+
+func Test_Call(t *testing.T) {
+	spots := &experiment.Experiment{}
+	summarised := &experiment.Experiment{}
+
+	add := func(name, group string, rows ...gpr.Row) {
+		g := &gpr.GPR{Wavelengths: []int{635}, Rows: rows}
+		samples, _ := g.Filter(gpr.SamplesOnly)
+		avg, _ := gpr.DefaultSummariser.Summarise(samples)
+
+		spots.Add(name, g, experiment.Metadata{Group: group})
+		summarised.Add(name, avg, experiment.Metadata{Group: group})
+	}
+
+	add("1", "treated",
+		spot("neg", gpr.NegativeControl, 10, 0), spot("neg", gpr.NegativeControl, 30, 0),
+		spot("A", gpr.Sample, 100, 10), spot("A", gpr.Sample, 120, 12),
+		spot("B", gpr.Sample, 30, 1), spot("B", gpr.Sample, 70, 3),
+		spot("C", gpr.Sample, 10, 5), spot("C", gpr.Sample, 150, 5),
+	)
+	add("2", "control",
+		spot("buffer", gpr.BufferControl, 5, 0), spot("buffer", gpr.BufferControl, 15, 0),
+		spot("A", gpr.Sample, 20, 3), spot("A", gpr.Sample, 20, 3),
+		spot("B", gpr.Sample, 50, 4), spot("B", gpr.Sample, 50, 4),
+		spot("C", gpr.Sample, 10, 1), spot("C", gpr.Sample, 10, 1),
+	)
+	add("3", "control", spot("A", gpr.Sample, 20, 3))
+
+	th := Thresholds{SD: 1, Controls: []gpr.Control{gpr.NegativeControl, gpr.BufferControl}}
+
+	calls, cutoffs, err := th.Call(spots, summarised, 635)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cutoffs) != 3 || math.Abs(cutoffs[0].Value-(20+math.Sqrt(200))) > 1e-9 || cutoffs[0].N != 2 || cutoffs[2].Failed == "" {
+		t.Fatalf("unexpected cutoffs %+v", cutoffs)
+	}
+
+	hit := func(calls []Call, array, id string) Call {
+		for _, c := range calls {
+			if c.Array == array && c.ID == id {
+				return c
+			}
+		}
+
+		t.Fatalf("no call for %s on %s", id, array)
+		return Call{}
+	}
+
+	if c := hit(calls, "1", "A"); !c.Hit || c.Agreement != 1 {
+		t.Errorf("expected a hit: %+v", c)
+	}
+
+	if c := hit(calls, "1", "B"); !c.Hit || c.Agreement != 0.5 {
+		t.Errorf("expected a hit: %+v", c)
+	}
+
+	if c := hit(calls, "2", "C"); c.Hit || c.Reason != "below cutoff" {
+		t.Errorf("expected no hit: %+v", c)
+	}
+
+	th.MinSNR = 2.5
+	th.Agreement = 0.75
+	th.MinFold = 2
+	th.Reference = "control"
+
+	calls, _, err = th.Call(spots, summarised, 635)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := hit(calls, "1", "A"); !c.Hit || c.Fold != 5.5 {
+		t.Errorf("expected a hit: %+v", c)
+	}
+
+	if c := hit(calls, "1", "B"); c.Hit || c.Reason != "low SNR, low fold, replicates disagree" {
+		t.Errorf("expected no hit: %+v", c)
+	}
+
+	if c := hit(calls, "1", "C"); c.Hit || c.Reason != "replicates disagree" {
+		t.Errorf("expected no hit: %+v", c)
+	}
+
+	freq := Frequencies(calls)
+	if len(freq) != 3 || freq[0].ID != "A" || freq[0].Hits["treated"] != 1 || freq[0].Arrays["control"] != 1 {
+		t.Errorf("unexpected frequencies %+v", freq)
+	}
+
+	if hits, arrays := freq[0].Total(); hits != 1 || arrays != 2 {
+		t.Errorf("expected 1 hit on 2 arrays, got %d on %d", hits, arrays)
+	}
+
+	th.Reference = "missing"
+	if _, _, err := th.Call(spots, summarised, 635); err == nil {
+		t.Error("expected an error for a missing reference group")
+	}
+}
+
+func Test_Call_NoReference(t *testing.T) {
+	spots := &experiment.Experiment{}
+	summarised := &experiment.Experiment{}
+
+	add := func(name, group string, rows ...gpr.Row) {
+		g := &gpr.GPR{Wavelengths: []int{635}, Rows: rows}
+		samples, _ := g.Filter(gpr.SamplesOnly)
+		avg, _ := gpr.DefaultSummariser.Summarise(samples)
+
+		spots.Add(name, g, experiment.Metadata{Group: group})
+		summarised.Add(name, avg, experiment.Metadata{Group: group})
+	}
+
+	add("1", "treated",
+		spot("neg", gpr.NegativeControl, 10, 0), spot("neg", gpr.NegativeControl, 30, 0),
+		spot("A", gpr.Sample, 100, 10), spot("B", gpr.Sample, 100, 10), spot("C", gpr.Sample, 100, 10),
+	)
+	add("2", "control", spot("A", gpr.Sample, 20, 3), spot("B", gpr.Sample, -5, 3))
+
+	th := Thresholds{SD: 1, Controls: []gpr.Control{gpr.NegativeControl}, MinFold: 2, Reference: "control"}
+
+	calls, _, err := th.Call(spots, summarised, 635)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 3 || !calls[0].Hit || calls[0].NoReference {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+
+	for _, c := range calls[1:] {
+		if c.Hit || !c.NoReference || c.Reason != "no reference" || !math.IsNaN(c.Fold) {
+			t.Errorf("expected no reference: %+v", c)
+		}
+	}
+
+	if freq := Frequencies(calls); len(freq) != 1 || freq[0].ID != "A" {
+		t.Errorf("expected calls without a reference to be left out, got %+v", freq)
+	}
+}
+
+func Test_Call_Quantile(t *testing.T) {
+	rows := func(f float64) []gpr.Row {
+		return []gpr.Row{
+			spot("neg", gpr.NegativeControl, 15*f, 0), spot("neg", gpr.NegativeControl, 25*f, 0),
+			spot("A", gpr.Sample, 100*f, 10), spot("A", gpr.Sample, 120*f, 10),
+			spot("B", gpr.Sample, 10*f, 1), spot("B", gpr.Sample, 30*f, 1),
+			spot("C", gpr.Sample, 20*f, 1), spot("C", gpr.Sample, 40*f, 1),
+		}
+	}
+
+	arrays := []*gpr.GPR{{Wavelengths: []int{635}, Rows: rows(1)}, {Wavelengths: []int{635}, Rows: rows(3)}}
+	normalized, _ := norm.Normalize(arrays, norm.Quantile{})
+
+	spots := &experiment.Experiment{}
+	summarised := &experiment.Experiment{}
+
+	for i, g := range normalized {
+		samples, _ := g.Filter(gpr.SamplesOnly)
+		avg, _ := gpr.DefaultSummariser.Summarise(samples)
+
+		spots.Add(string(rune('1'+i)), g, experiment.Metadata{Group: "treated"})
+		summarised.Add(string(rune('1'+i)), avg, experiment.Metadata{Group: "treated"})
+	}
+
+	th := Thresholds{SD: 1, Controls: []gpr.Control{gpr.NegativeControl}}
+
+	calls, cutoffs, err := th.Call(spots, summarised, 635)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the arrays differ only in scale, so normalized they agree
+	if len(cutoffs) != 2 || cutoffs[0].Failed != "" || math.Abs(cutoffs[0].Value-cutoffs[1].Value) > 1e-9 {
+		t.Fatalf("expected equal cutoffs, got %+v", cutoffs)
+	}
+
+	for i := 0; i < len(calls)/2; i++ {
+		if a, b := calls[i], calls[i+len(calls)/2]; a.ID != b.ID || a.Hit != b.Hit {
+			t.Errorf("expected the same call for %s on both arrays, got %+v and %+v", a.ID, a, b)
+		}
+	}
+}
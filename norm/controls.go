@@ -0,0 +1,103 @@
+package norm
+
+import (
+	"fmt"
+	"math"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// PositiveControls scales each array so its unsaturated positive controls
+// match their median across the arrays, matched by ID.
+type PositiveControls struct {
+	// MaxSaturated is the largest percentage of saturated pixels of a control.
+	MaxSaturated float64
+}
+
+func (p PositiveControls) saturated(c gpr.Channel) bool {
+	return c.PercentSaturated > p.MaxSaturated || c.Median >= gpr.MaxIntensity
+}
+
+// levels returns the median intensity of each positive control of g, or the
+// reason there are none.
+func (p PositiveControls) levels(g *gpr.GPR, wavelength int) (map[string]float64, string) {
+	var found, saturated int
+	spots := map[string][]float64{}
+
+	for _, row := range g.Rows {
+		if row.Control != gpr.PositiveControl {
+			continue
+		}
+
+		c := row.Channel(wavelength)
+		if math.IsNaN(c.MedianMinusBackground) {
+			continue
+		}
+
+		found++
+		if p.saturated(c) {
+			saturated++
+			continue
+		}
+
+		spots[row.ID] = append(spots[row.ID], c.MedianMinusBackground)
+	}
+
+	switch {
+	case found == 0:
+		return nil, "no positive controls"
+	case len(spots) == 0:
+		return nil, fmt.Sprintf("all %d positive controls saturated", saturated)
+	}
+
+	levels := make(map[string]float64, len(spots))
+	for id, xs := range spots {
+		levels[id] = stats.Median(xs)
+	}
+
+	return levels, ""
+}
+
+func (p PositiveControls) Check(arrays []*gpr.GPR, wavelength int) []string {
+	failed := make([]string, len(arrays))
+	for i, g := range arrays {
+		_, failed[i] = p.levels(g, wavelength)
+	}
+
+	return failed
+}
+
+func (p PositiveControls) Normalize(arrays []*gpr.GPR, wavelength int) {
+	levels := make([]map[string]float64, len(arrays))
+	byID := map[string][]float64{}
+
+	for i, g := range arrays {
+		levels[i], _ = p.levels(g, wavelength)
+		for id, x := range levels[i] {
+			byID[id] = append(byID[id], x)
+		}
+	}
+
+	reference := make(map[string]float64, len(byID))
+	for id, xs := range byID {
+		reference[id] = stats.Median(xs)
+	}
+
+	for i, g := range arrays {
+		var ratios []float64
+		for id, x := range levels[i] {
+			if x > 0 && reference[id] > 0 {
+				ratios = append(ratios, reference[id]/x)
+			}
+		}
+
+		if len(ratios) > 0 {
+			scale(g, wavelength, stats.Median(ratios))
+		}
+	}
+}
+
+func (p PositiveControls) String() string {
+	return fmt.Sprintf("controls (max saturated %v%%)", p.MaxSaturated)
+}
@@ -0,0 +1,431 @@
+// Package norm normalizes intensities across arrays.
+package norm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Method normalizes one channel of a set of arrays in place, fitted to the
+// sample spots.
+type Method interface {
+	Normalize(arrays []*gpr.GPR, wavelength int)
+	String() string
+}
+
+// Checker returns the reason each array is unfit to be normalized, or "".
+type Checker interface {
+	Check(arrays []*gpr.GPR, wavelength int) []string
+}
+
+// Factor is the change of the median intensity of a channel of an array.
+type Factor struct {
+	Array      int
+	Wavelength int
+	Scale      float64
+
+	// Failed is the reason the array failed the checks of the method.
+	Failed string
+}
+
+// Normalize returns copies of the arrays with each wavelength normalized
+// across them, and the factors applied.
+func Normalize(arrays []*gpr.GPR, m Method) ([]*gpr.GPR, []Factor) {
+	out := make([]*gpr.GPR, len(arrays))
+	for i, g := range arrays {
+		out[i] = g.Clone()
+	}
+
+	var factors []Factor
+
+	for _, w := range wavelengths(arrays) {
+		var idx []int
+		var set []*gpr.GPR
+
+		for i, g := range out {
+			if g.HasWavelength(w) {
+				idx = append(idx, i)
+				set = append(set, g)
+			}
+		}
+
+		before := make([]float64, len(set))
+		for i, g := range set {
+			before[i] = stats.Median(values(channels(g, w), medianMinusBackground))
+		}
+
+		failed := make([]string, len(set))
+		if c, ok := m.(Checker); ok {
+			failed = c.Check(set, w)
+		}
+
+		m.Normalize(set, w)
+
+		for i, g := range set {
+			after := stats.Median(values(channels(g, w), medianMinusBackground))
+			factors = append(factors, Factor{Array: idx[i], Wavelength: w, Scale: after / before[i], Failed: failed[i]})
+		}
+	}
+
+	return out, factors
+}
+
+func wavelengths(arrays []*gpr.GPR) []int {
+	var ws []int
+	seen := map[int]bool{}
+
+	for _, g := range arrays {
+		for _, w := range g.Wavelengths {
+			if !seen[w] {
+				seen[w] = true
+				ws = append(ws, w)
+			}
+		}
+	}
+
+	return ws
+}
+
+// measure selects a value of a channel.
+type measure func(*gpr.Channel) *float64
+
+func medianMinusBackground(c *gpr.Channel) *float64 {
+	return &c.MedianMinusBackground
+}
+
+func meanMinusBackground(c *gpr.Channel) *float64 {
+	return &c.MeanMinusBackground
+}
+
+// intensities are the measures adjusted by normalizing.
+var intensities = []measure{medianMinusBackground, meanMinusBackground}
+
+// channels returns the channels of the sample spots of g.
+func channels(g *gpr.GPR, wavelength int) []*gpr.Channel {
+	return selectChannels(g, wavelength, func(c gpr.Control) bool { return c == gpr.Sample })
+}
+
+// controls returns the channels of every other spot of g.
+func controls(g *gpr.GPR, wavelength int) []*gpr.Channel {
+	return selectChannels(g, wavelength, func(c gpr.Control) bool { return c != gpr.Sample })
+}
+
+func selectChannels(g *gpr.GPR, wavelength int, keep func(gpr.Control) bool) []*gpr.Channel {
+	var chs []*gpr.Channel
+
+	for i := range g.Rows {
+		if !keep(g.Rows[i].Control) {
+			continue
+		}
+
+		for j := range g.Rows[i].Channels {
+			if c := &g.Rows[i].Channels[j]; c.Wavelength == wavelength {
+				chs = append(chs, c)
+			}
+		}
+	}
+
+	return chs
+}
+
+func values(chs []*gpr.Channel, m measure) []float64 {
+	xs := make([]float64, len(chs))
+	for i, c := range chs {
+		xs[i] = *m(c)
+	}
+
+	return xs
+}
+
+// interpolate returns the value at x of the line through ascending xs, ys.
+func interpolate(xs, ys []float64, x float64) float64 {
+	i := sort.SearchFloat64s(xs, x)
+
+	switch {
+	case i == 0:
+		return ys[0]
+	case i == len(xs):
+		return ys[len(ys)-1]
+	}
+
+	t := (x - xs[i-1]) / (xs[i] - xs[i-1])
+	return ys[i-1] + t*(ys[i]-ys[i-1])
+}
+
+// scale multiplies the intensities of every spot of g by f.
+func scale(g *gpr.GPR, wavelength int, f float64) {
+	for i := range g.Rows {
+		for j := range g.Rows[i].Channels {
+			if c := &g.Rows[i].Channels[j]; c.Wavelength == wavelength {
+				for _, m := range intensities {
+					*m(c) *= f
+				}
+			}
+		}
+	}
+}
+
+// scaleTo scales each array so its statistic matches the median across them.
+func scaleTo(arrays []*gpr.GPR, wavelength int, stat func([]float64) float64) {
+	xs := make([]float64, len(arrays))
+	for i, g := range arrays {
+		xs[i] = stat(values(channels(g, wavelength), medianMinusBackground))
+	}
+
+	target := stats.Median(xs)
+
+	for i, x := range xs {
+		if x > 0 && !math.IsNaN(target) {
+			scale(arrays[i], wavelength, target/x)
+		}
+	}
+}
+
+// None leaves the arrays unchanged.
+type None struct{}
+
+func (None) Normalize(arrays []*gpr.GPR, wavelength int) {}
+
+func (None) String() string {
+	return "none"
+}
+
+// MedianScaling scales each array so its median intensity matches the
+// median across the arrays.
+type MedianScaling struct{}
+
+func (MedianScaling) Normalize(arrays []*gpr.GPR, wavelength int) {
+	scaleTo(arrays, wavelength, stats.Median)
+}
+
+func (MedianScaling) String() string {
+	return "median"
+}
+
+// TotalIntensity scales each array so its summed intensity matches the
+// median sum across the arrays.
+type TotalIntensity struct{}
+
+func (TotalIntensity) Normalize(arrays []*gpr.GPR, wavelength int) {
+	scaleTo(arrays, wavelength, sum)
+}
+
+func (TotalIntensity) String() string {
+	return "total"
+}
+
+func sum(xs []float64) float64 {
+	var s float64
+	for _, x := range xs {
+		if !math.IsNaN(x) {
+			s += x
+		}
+	}
+
+	return s
+}
+
+// Quantile gives every array the mean distribution of the arrays. Control
+// spots are shifted as the sample spots either side of them.
+type Quantile struct{}
+
+func (Quantile) Normalize(arrays []*gpr.GPR, wavelength int) {
+	chs := make([][]*gpr.Channel, len(arrays))
+	ctrls := make([][]*gpr.Channel, len(arrays))
+
+	for i, g := range arrays {
+		chs[i] = channels(g, wavelength)
+		ctrls[i] = controls(g, wavelength)
+	}
+
+	for _, m := range intensities {
+		sorted := make([][]float64, len(arrays))
+		size := 0
+
+		for i := range arrays {
+			sorted[i] = stats.Values(values(chs[i], m))
+			if len(sorted[i]) > size {
+				size = len(sorted[i])
+			}
+		}
+
+		if size == 0 {
+			continue
+		}
+
+		reference := make([]float64, size)
+		for k := range reference {
+			q := 0.0
+			if size > 1 {
+				q = float64(k) / float64(size-1)
+			}
+
+			var qs []float64
+			for _, s := range sorted {
+				if len(s) > 0 {
+					qs = append(qs, stats.Quantile(s, q))
+				}
+			}
+
+			reference[k] = stats.Mean(qs)
+		}
+
+		for i := range arrays {
+			n := len(sorted[i])
+
+			if n == 0 {
+				continue
+			}
+
+			targets := map[float64]float64{}
+			var xs, shifts []float64
+
+			for lo := 0; lo < n; {
+				hi := lo
+				for hi+1 < n && sorted[i][hi+1] == sorted[i][lo] {
+					hi++
+				}
+
+				q := 0.0
+				if n > 1 {
+					q = (float64(lo) + float64(hi)) / 2 / float64(n-1)
+				}
+
+				x := sorted[i][lo]
+				targets[x] = stats.Quantile(reference, q)
+				xs = append(xs, x)
+				shifts = append(shifts, targets[x]-x)
+				lo = hi + 1
+			}
+
+			for _, c := range chs[i] {
+				if v := m(c); !math.IsNaN(*v) {
+					*v = targets[*v]
+				}
+			}
+
+			for _, c := range ctrls[i] {
+				if v := m(c); !math.IsNaN(*v) {
+					*v += interpolate(xs, shifts, *v)
+				}
+			}
+		}
+	}
+}
+
+func (Quantile) String() string {
+	return "quantile"
+}
+
+// Loess removes the intensity dependent trend of the log ratio of each array
+// to the median array, with spots matched by position.
+type Loess struct {
+	Span float64
+}
+
+type spot struct {
+	block, column, row int
+}
+
+func (l Loess) Normalize(arrays []*gpr.GPR, wavelength int) {
+	logs := map[spot][]float64{}
+
+	for _, g := range arrays {
+		for _, row := range g.Rows {
+			if v := row.Channel(wavelength).MedianMinusBackground; v > 0 && row.Control == gpr.Sample {
+				k := spot{row.Block, row.Column, row.Row}
+				logs[k] = append(logs[k], math.Log2(v))
+			}
+		}
+	}
+
+	for _, g := range arrays {
+		var fitted []*gpr.Channel
+		var a, m []float64
+
+		for i := range g.Rows {
+			row := &g.Rows[i]
+			if row.Control != gpr.Sample {
+				continue
+			}
+
+			for j := range row.Channels {
+				c := &row.Channels[j]
+				if c.Wavelength != wavelength || !(c.MedianMinusBackground > 0) {
+					continue
+				}
+
+				ref := stats.Median(logs[spot{row.Block, row.Column, row.Row}])
+				x := math.Log2(c.MedianMinusBackground)
+
+				fitted = append(fitted, c)
+				a = append(a, (x+ref)/2)
+				m = append(m, x-ref)
+			}
+		}
+
+		if len(fitted) < 3 {
+			continue
+		}
+
+		fit := stats.Lowess(a, m, l.Span, 3)
+
+		order := make([]int, len(a))
+		for i := range order {
+			order[i] = i
+		}
+
+		sort.Slice(order, func(i, j int) bool { return a[order[i]] < a[order[j]] })
+
+		xs, ys := make([]float64, len(order)), make([]float64, len(order))
+		for i, o := range order {
+			xs[i], ys[i] = a[o], fit[o]
+		}
+
+		for _, c := range controls(g, wavelength) {
+			if c.MedianMinusBackground > 0 {
+				fitted = append(fitted, c)
+				fit = append(fit, interpolate(xs, ys, math.Log2(c.MedianMinusBackground)))
+			}
+		}
+
+		for i, c := range fitted {
+			f := math.Exp2(-fit[i])
+			for _, m := range intensities {
+				*m(c) *= f
+			}
+		}
+	}
+}
+
+func (l Loess) String() string {
+	return fmt.Sprintf("loess (span %v)", l.Span)
+}
+
+// Methods lists the names accepted by Parse.
+var Methods = []string{"none", "median", "quantile", "total", "loess", "controls"}
+
+// Parse returns the normalization method with the given name.
+func Parse(name string) (Method, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return None{}, nil
+	case "median":
+		return MedianScaling{}, nil
+	case "quantile":
+		return Quantile{}, nil
+	case "total":
+		return TotalIntensity{}, nil
+	case "loess":
+		return Loess{Span: 0.3}, nil
+	case "controls":
+		return PositiveControls{MaxSaturated: 5}, nil
+	default:
+		return nil, fmt.Errorf("unknown normalization method %q", name)
+	}
+}
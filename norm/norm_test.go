@@ -0,0 +1,191 @@
+package norm
+
+import (
+	"math"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func array(values ...float64) *gpr.GPR {
+	g := &gpr.GPR{Wavelengths: []int{635}}
+
+	for i, v := range values {
+		g.Rows = append(g.Rows, gpr.Row{
+			ID:       string(rune('A' + i)),
+			Block:    1,
+			Column:   i + 1,
+			Row:      1,
+			Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: v, MeanMinusBackground: v}},
+		})
+	}
+
+	return g
+}
+
+func medians(g *gpr.GPR) []float64 {
+	xs := make([]float64, len(g.Rows))
+	for i, row := range g.Rows {
+		xs[i] = row.Channel(635).MedianMinusBackground
+	}
+
+	return xs
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func Test_MedianScaling(t *testing.T) {
+	arrays := []*gpr.GPR{array(10, 20, 30), array(20, 40, 60), array(40, 80, 120)}
+
+	out, factors := Normalize(arrays, MedianScaling{})
+
+	for i, g := range out {
+		if xs := medians(g); !near(xs[0], 20) || !near(xs[1], 40) || !near(xs[2], 60) {
+			t.Errorf("array %d: unexpected values %v", i, xs)
+		}
+	}
+
+	if len(factors) != 3 || !near(factors[0].Scale, 2) || !near(factors[1].Scale, 1) || !near(factors[2].Scale, 0.5) {
+		t.Errorf("unexpected factors %+v", factors)
+	}
+
+	if arrays[0].Rows[0].Channel(635).MedianMinusBackground != 10 {
+		t.Error("expected input arrays to be unchanged")
+	}
+}
+
+func Test_TotalIntensity(t *testing.T) {
+	out, _ := Normalize([]*gpr.GPR{array(10, 10, 40), array(20, 20, 80)}, TotalIntensity{})
+
+	a, b := medians(out[0]), medians(out[1])
+	if !near(a[2], b[2]) || !near(a[2]+a[0]+a[1], 90) {
+		t.Errorf("unexpected values %v %v", a, b)
+	}
+}
+
+// withControl adds a negative control spot to g.
+func withControl(g *gpr.GPR, v float64) *gpr.GPR {
+	g.Rows = append(g.Rows, gpr.Row{
+		ID:       "neg",
+		Block:    2,
+		Column:   1,
+		Row:      1,
+		Control:  gpr.NegativeControl,
+		Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: v, MeanMinusBackground: v}},
+	})
+
+	return g
+}
+
+func Test_Quantile(t *testing.T) {
+	out, _ := Normalize([]*gpr.GPR{withControl(array(1, 5, 3), 4), withControl(array(20, 40, 60), 50)}, Quantile{})
+
+	a, b := medians(out[0]), medians(out[1])
+	expected := []float64{10.5, 32.5, 21.5}
+	for i := range expected {
+		if !near(a[i], expected[i]) {
+			t.Errorf("unexpected values %v", a)
+		}
+	}
+
+	if !near(b[0], 10.5) || !near(b[1], 21.5) || !near(b[2], 32.5) {
+		t.Errorf("unexpected values %v", b)
+	}
+
+	// controls are shifted as the samples either side
+	if !near(a[3], 27) || !near(b[3], 27) {
+		t.Errorf("unexpected controls %v %v", a[3], b[3])
+	}
+}
+
+func Test_Loess(t *testing.T) {
+	var x, y []float64
+	for i := 1; i <= 40; i++ {
+		v := float64(i * 25)
+		x = append(x, v)
+		y = append(y, 3*v)
+	}
+
+	out, factors := Normalize([]*gpr.GPR{withControl(array(x...), 100), withControl(array(y...), 300)}, Loess{Span: 0.5})
+
+	a, b := medians(out[0]), medians(out[1])
+	for i := range a {
+		if math.Abs(a[i]-b[i])/a[i] > 1e-6 {
+			t.Errorf("spot %d: expected equal values, got %v and %v", i, a[i], b[i])
+		}
+	}
+
+	if len(factors) != 2 || math.Abs(factors[1].Scale*3/factors[0].Scale-1) > 1e-6 {
+		t.Errorf("unexpected factors %+v", factors)
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	for _, name := range Methods {
+		m, err := Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.String() == "" {
+			t.Errorf("%s: empty description", name)
+		}
+	}
+
+	if _, err := Parse("cyclic"); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func withControls(g *gpr.GPR, values ...float64) *gpr.GPR {
+	for i, v := range values {
+		g.Rows = append(g.Rows, gpr.Row{
+			ID:       "IgG",
+			Control:  gpr.PositiveControl,
+			Block:    2,
+			Column:   i + 1,
+			Row:      1,
+			Channels: []gpr.Channel{{Wavelength: 635, Median: v + 100, MedianMinusBackground: v, MeanMinusBackground: v}},
+		})
+	}
+
+	return g
+}
+
+func Test_PositiveControls(t *testing.T) {
+	saturated := withControls(array(10, 20, 30), 1000, 1000)
+	for i := range saturated.Rows[3:] {
+		saturated.Rows[3+i].Channels[0].PercentSaturated = 50
+	}
+
+	arrays := []*gpr.GPR{
+		withControls(array(10, 20, 30), 1000, 1000),
+		withControls(array(10, 20, 30), 2000, 2000),
+		withControls(array(10, 20, 30), 4000, 4000),
+		array(10, 20, 30),
+		saturated,
+	}
+
+	out, factors := Normalize(arrays, PositiveControls{MaxSaturated: 5})
+
+	if xs := medians(out[0]); !near(xs[0], 20) || !near(xs[3], 2000) {
+		t.Errorf("unexpected values %v", xs)
+	}
+
+	if xs := medians(out[2]); !near(xs[0], 5) {
+		t.Errorf("unexpected values %v", xs)
+	}
+
+	for i, f := range factors {
+		failed := i >= 3
+		if (f.Failed != "") != failed {
+			t.Errorf("array %d: unexpected check result %q", i, f.Failed)
+		}
+	}
+
+	if xs := medians(out[4]); !near(xs[0], 10) {
+		t.Errorf("expected failed array to be unscaled, got %v", xs)
+	}
+}
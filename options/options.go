@@ -0,0 +1,76 @@
+// Package options holds the command line options shared by the commands.
+package options
+
+import (
+	"github.com/tealeg/xlsx"
+
+	"gitlab.node-3.net/nadams/gpr/appender"
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+)
+
+// Input selects how arrays are read and corrected.
+type Input struct {
+	Labels     []string `name:"label" help:"Reagent detected at each wavelength, as NAME=WAVELENGTH." default:"IgG=550,IgM=650"`
+	Rules      string   `name:"rules" help:"Rules classifying control and excluded spots: legacy excludes rows 17-18 and empty or blank IDs, default only excludes empty or blank IDs, or give a rules file." default:"legacy"`
+	Background string   `name:"background" help:"How the background is corrected (genepix, none, subtract, half, normexp, movingmin)." enum:"genepix,none,subtract,half,normexp,movingmin" default:"genepix"`
+	Spatial    string   `name:"spatial" help:"Correction of spatial patterns across each array (none, block, loess)." enum:"none,block,loess" default:"none"`
+}
+
+// Reading is a parsed Input.
+type Reading struct {
+	Labels     gpr.Labels
+	Rules      gpr.Rules
+	Background gpr.BackgroundCorrection
+	Spatial    spatial.Correction
+}
+
+// Parse parses the options.
+func (in *Input) Parse() (Reading, error) {
+	labels, err := gpr.ParseLabels(in.Labels)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	rules, err := gpr.LoadRules(in.Rules)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	background, err := gpr.ParseBackgroundCorrection(in.Background)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	correction, err := spatial.Parse(in.Spatial)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	return Reading{Labels: labels, Rules: rules, Background: background, Spatial: correction}, nil
+}
+
+// Setting is an option recorded with the results.
+type Setting struct {
+	Name  string
+	Value interface{}
+}
+
+// AddSettings records the options used to produce a workbook.
+func AddSettings(spreadsheet *xlsx.File, settings []Setting) error {
+	sheet, err := spreadsheet.AddSheet("Settings")
+	if err != nil {
+		return err
+	}
+
+	apndr := appender.NewRowAppender(sheet)
+	apndr.Append("Setting", "Value")
+	apndr.NewRow()
+
+	for _, s := range settings {
+		apndr.Append(s.Name, s.Value)
+		apndr.NewRow()
+	}
+
+	return nil
+}
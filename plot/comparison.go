@@ -0,0 +1,243 @@
+package plot
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// minQ bounds the q values of a volcano plot so zero can be drawn.
+const minQ = 1e-16
+
+// Volcano plots the log2 fold change of each protein against the -log10 of
+// its q value, naming the top significant proteins.
+func Volcano(title string, ids []string, fold, q []float64, top int, maxQ, minFold float64) *Plot {
+	significant := Series{Name: "Significant", Color: Red}
+	other := Series{Name: "Not significant", Color: Grey}
+
+	type point struct {
+		id      string
+		x, y, q float64
+	}
+
+	var points []point
+
+	for i := range ids {
+		x := math.NaN()
+		if fold[i] > 0 {
+			x = math.Log2(fold[i])
+		}
+
+		y := math.NaN()
+		if !math.IsNaN(q[i]) {
+			y = -math.Log10(math.Max(q[i], minQ))
+		}
+
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) {
+			continue
+		}
+
+		points = append(points, point{ids[i], x, y, q[i]})
+	}
+
+	// the most significant first, the largest change breaking ties
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].y != points[j].y {
+			return points[i].y > points[j].y
+		}
+
+		return math.Abs(points[i].x) > math.Abs(points[j].x)
+	})
+
+	for _, p := range points {
+		s := &other
+		label := ""
+
+		if p.q <= maxQ && math.Abs(p.x) >= minFold {
+			s = &significant
+
+			if len(significant.X) < top {
+				label = p.id
+			}
+		}
+
+		s.X = append(s.X, p.x)
+		s.Y = append(s.Y, p.y)
+		s.Labels = append(s.Labels, label)
+	}
+
+	rules := []Rule{{Intercept: -math.Log10(maxQ), Color: Red}}
+	if minFold > 0 {
+		rules = append(rules, Rule{Intercept: -minFold, Vertical: true, Color: Red}, Rule{Intercept: minFold, Vertical: true, Color: Red})
+	}
+
+	return &Plot{
+		Title:  title,
+		XLabel: "log2 fold change",
+		YLabel: "-log10 q",
+		Series: []Series{other, significant},
+		Rules:  rules,
+		Notes: []string{
+			fmt.Sprintf("q <= %g, |log2 fold| >= %g", maxQ, minFold),
+			fmt.Sprintf("%d of %d significant", len(significant.X), len(points)),
+		},
+	}
+}
+
+// Ranking is a bar chart of named values in descending order.
+type Ranking struct {
+	Title  string
+	XLabel string
+
+	Names  []string
+	Values []float64
+	Errors []float64
+
+	// Width is the width of the image, 640 if zero.
+	Width int
+
+	// Face is the font of the labels, the gg default if nil.
+	Face font.Face
+}
+
+const barHeight = 20
+
+// Rank returns the n highest values, with their errors if given.
+func Rank(title, label string, names []string, values, errors []float64, n int) *Ranking {
+	order := make([]int, 0, len(names))
+	for i := range names {
+		if !math.IsNaN(values[i]) {
+			order = append(order, i)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return values[order[i]] > values[order[j]]
+	})
+
+	if n > 0 && len(order) > n {
+		order = order[:n]
+	}
+
+	r := &Ranking{Title: title, XLabel: label}
+
+	for _, i := range order {
+		r.Names = append(r.Names, names[i])
+		r.Values = append(r.Values, values[i])
+
+		if errors != nil {
+			r.Errors = append(r.Errors, errors[i])
+		}
+	}
+
+	return r
+}
+
+// Render draws the chart.
+func (r *Ranking) Render() (image.Image, error) {
+	if len(r.Names) == 0 {
+		return nil, fmt.Errorf("%s: nothing to plot", r.Title)
+	}
+
+	width := r.Width
+	if width == 0 {
+		width = 640
+	}
+
+	// names are measured before the image is sized
+	measure := gg.NewContext(1, 1)
+	if r.Face != nil {
+		measure.SetFontFace(r.Face)
+	}
+
+	var name float64
+	for _, n := range r.Names {
+		w, _ := measure.MeasureString(n)
+		name = math.Max(name, w)
+	}
+
+	margin := math.Ceil(name) + 20
+	height := top + bottom + barHeight*len(r.Names)
+
+	hi := 0.0
+	lo := 0.0
+
+	for i, v := range r.Values {
+		e := 0.0
+		if r.Errors != nil && !math.IsNaN(r.Errors[i]) {
+			e = r.Errors[i]
+		}
+
+		hi = math.Max(hi, v+e)
+		lo = math.Min(lo, v-e)
+	}
+
+	if hi == lo {
+		hi = lo + 1
+	}
+
+	hi += (hi - lo) * 0.05
+
+	dc := gg.NewContext(width, height)
+	if r.Face != nil {
+		dc.SetFontFace(r.Face)
+	}
+
+	dc.SetColor(color.White)
+	dc.Clear()
+
+	pw := float64(width) - margin - right
+	ph := float64(barHeight * len(r.Names))
+
+	px := func(x float64) float64 { return margin + (x-lo)/(hi-lo)*pw }
+
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored(r.Title, float64(width)/2, top/2, 0.5, 0.5)
+	dc.DrawStringAnchored(r.XLabel, margin+pw/2, float64(height)-12, 0.5, 0.5)
+
+	dc.SetLineWidth(1)
+	dc.DrawLine(margin, top+ph, margin+pw, top+ph)
+	dc.Stroke()
+
+	for _, t := range ticks(lo, hi, 6) {
+		dc.DrawLine(px(t), top+ph, px(t), top+ph+4)
+		dc.Stroke()
+		dc.DrawStringAnchored(fmt.Sprintf("%g", t), px(t), top+ph+14, 0.5, 0.5)
+	}
+
+	for i, v := range r.Values {
+		y := float64(top + i*barHeight)
+
+		dc.SetColor(Blue)
+		dc.DrawRectangle(math.Min(px(0), px(v)), y+3, math.Abs(px(v)-px(0)), barHeight-6)
+		dc.Fill()
+
+		if r.Errors != nil && !math.IsNaN(r.Errors[i]) {
+			dc.SetColor(color.Black)
+			dc.DrawLine(px(v-r.Errors[i]), y+barHeight/2, px(v+r.Errors[i]), y+barHeight/2)
+			dc.Stroke()
+		}
+
+		dc.SetColor(color.Black)
+		dc.DrawStringAnchored(r.Names[i], margin-6, y+barHeight/2, 1, 0.5)
+	}
+
+	return dc.Image(), nil
+}
+
+// WritePNG renders the chart as a PNG.
+func (r *Ranking) WritePNG(w io.Writer) error {
+	img, err := r.Render()
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
@@ -0,0 +1,205 @@
+package plot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// log2 returns the log2 background subtracted median of a channel, or NaN.
+func log2(r gpr.Row, w int) float64 {
+	if v := r.Channel(w).MedianMinusBackground; v > 0 {
+		return math.Log2(v)
+	}
+
+	return math.NaN()
+}
+
+// Scatter plots the intensity of every spot of g at one label against another.
+func Scatter(name string, g *gpr.GPR, x, y gpr.Label) *Plot {
+	samples := Series{Name: "Samples", Color: Blue}
+	controls := Series{Name: "Controls", Color: Red}
+
+	for _, r := range g.Rows {
+		s := &samples
+		if r.Control != gpr.Sample {
+			s = &controls
+		}
+
+		s.X = append(s.X, log2(r, x.Wavelength))
+		s.Y = append(s.Y, log2(r, y.Wavelength))
+	}
+
+	return &Plot{
+		Title:  fmt.Sprintf("%s %s vs %s", name, y.Name, x.Name),
+		XLabel: fmt.Sprintf("log2 %s", x.Name),
+		YLabel: fmt.Sprintf("log2 %s", y.Name),
+		Series: []Series{samples, controls},
+		Rules:  []Rule{{Slope: 1}},
+		Notes:  []string{fmt.Sprintf("Pearson r = %.3f", stats.Pearson(samples.X, samples.Y))},
+	}
+}
+
+// MA plots the log2 ratio of two summarised arrays against their mean log2
+// intensity.
+func MA(a, b *gpr.GPR, an, bn string, l gpr.Label) (*Plot, error) {
+	j, err := gpr.JoinRows(a, b, gpr.ByID)
+	if err != nil {
+		return nil, err
+	}
+
+	var points Series
+	points.Color = Blue
+
+	for _, p := range j.Inner {
+		la, lb := log2(p.Left, l.Wavelength), log2(p.Right, l.Wavelength)
+		if math.IsNaN(la) || math.IsNaN(lb) {
+			continue
+		}
+
+		points.X = append(points.X, (la+lb)/2)
+		points.Y = append(points.Y, la-lb)
+	}
+
+	p := &Plot{
+		Title:  fmt.Sprintf("%s vs %s %s", an, bn, l.Name),
+		XLabel: "A = mean log2 intensity",
+		YLabel: fmt.Sprintf("M = log2 %s / %s", an, bn),
+		Series: []Series{points},
+		Rules:  []Rule{{}},
+		Notes: []string{
+			fmt.Sprintf("n = %d", len(points.X)),
+			fmt.Sprintf("median M = %.3f", stats.Median(points.Y)),
+		},
+	}
+
+	if len(points.X) > 2 {
+		p.Series = append(p.Series, trend(points.X, points.Y))
+	}
+
+	return p, nil
+}
+
+// trend returns the lowess fit of y on x as a line.
+func trend(x, y []float64) Series {
+	fit := stats.Lowess(x, y, 0.4, 2)
+
+	order := make([]int, len(x))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return x[order[i]] < x[order[j]]
+	})
+
+	line := Series{Color: Red, Line: true}
+	for _, o := range order {
+		line.X = append(line.X, x[o])
+		line.Y = append(line.Y, fit[o])
+	}
+
+	return line
+}
+
+// Concordance plots the first printed replicate of each sample protein
+// against the second, ordered by block, row and column.
+func Concordance(name string, g *gpr.GPR, l gpr.Label) *Plot {
+	var points Series
+	points.Color = Blue
+
+	byProtein := g.ByProtein()
+	ids := make([]string, 0, len(byProtein))
+
+	for id := range byProtein {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		reps := byProtein[id]
+		if len(reps) < 2 || reps[0].Control != gpr.Sample {
+			continue
+		}
+
+		sort.Slice(reps, func(i, j int) bool {
+			a, b := reps[i], reps[j]
+			if a.Block != b.Block {
+				return a.Block < b.Block
+			}
+
+			if a.Row != b.Row {
+				return a.Row < b.Row
+			}
+
+			return a.Column < b.Column
+		})
+
+		points.X = append(points.X, log2(reps[0], l.Wavelength))
+		points.Y = append(points.Y, log2(reps[1], l.Wavelength))
+	}
+
+	return &Plot{
+		Title:  fmt.Sprintf("%s %s replicate concordance", name, l.Name),
+		XLabel: "log2 replicate 1",
+		YLabel: "log2 replicate 2",
+		Series: []Series{points},
+		Rules:  []Rule{{Slope: 1}},
+		Notes: []string{
+			fmt.Sprintf("Pearson r = %.3f", stats.Pearson(points.X, points.Y)),
+			fmt.Sprintf("Spearman r = %.3f", stats.Spearman(points.X, points.Y)),
+		},
+	}
+}
+
+// Histogram plots the distribution of the log2 sample intensities of g.
+func Histogram(name string, g *gpr.GPR, l gpr.Label, bins int) *Plot {
+	var vs []float64
+	for _, r := range g.Rows {
+		if r.Control == gpr.Sample {
+			vs = append(vs, log2(r, l.Wavelength))
+		}
+	}
+
+	vs = stats.Values(vs)
+
+	p := &Plot{
+		Title:  fmt.Sprintf("%s %s intensities", name, l.Name),
+		XLabel: fmt.Sprintf("log2 %s", l.Name),
+		YLabel: "Spots",
+		Notes: []string{
+			fmt.Sprintf("n = %d", len(vs)),
+			fmt.Sprintf("median = %.3f", stats.Median(vs)),
+		},
+	}
+
+	if len(vs) == 0 || bins < 1 {
+		return p
+	}
+
+	lo, hi := vs[0], vs[len(vs)-1]
+	width := (hi - lo) / float64(bins)
+	if width == 0 {
+		width = 1
+	}
+
+	counts := make([]int, bins)
+	for _, v := range vs {
+		i := int((v - lo) / width)
+		if i >= bins {
+			i = bins - 1
+		}
+
+		counts[i]++
+	}
+
+	for i, c := range counts {
+		p.Bars = append(p.Bars, Bar{Lo: lo + float64(i)*width, Hi: lo + float64(i+1)*width, Value: float64(c)})
+	}
+
+	return p
+}
@@ -0,0 +1,374 @@
+// Package plot draws the diagnostic charts of arrays as PNG images.
+package plot
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+var (
+	Blue  = color.RGBA{31, 119, 180, 255}
+	Red   = color.RGBA{214, 39, 40, 255}
+	Grey  = color.RGBA{150, 150, 150, 255}
+	Green = color.RGBA{44, 160, 44, 255}
+)
+
+// Series is a set of points, drawn as a line when Line is set.
+type Series struct {
+	Name   string
+	X, Y   []float64
+	Color  color.Color
+	Line   bool
+	Labels []string
+}
+
+// Rule is the line y = Intercept + Slope·x, or x = Intercept when Vertical.
+type Rule struct {
+	Intercept float64
+	Slope     float64
+	Vertical  bool
+	Color     color.Color
+}
+
+// Bar is a bar covering Lo to Hi on the x axis.
+type Bar struct {
+	Lo, Hi float64
+	Value  float64
+}
+
+// Plot is a chart with numeric axes.
+type Plot struct {
+	Title  string
+	XLabel string
+	YLabel string
+
+	Series []Series
+	Rules  []Rule
+	Bars   []Bar
+
+	// Notes are written in the top left corner of the chart.
+	Notes []string
+
+	// Width and Height are the size of the image, 640 by 480 if zero.
+	Width, Height int
+
+	// Face is the font of the labels, the gg default if nil.
+	Face font.Face
+}
+
+const (
+	left   = 70
+	right  = 20
+	top    = 40
+	bottom = 50
+	radius = 2.5
+)
+
+// bounds returns the range of the data, padded by 5%.
+func (p *Plot) bounds() (x0, x1, y0, y1 float64, ok bool) {
+	x0, y0 = math.Inf(1), math.Inf(1)
+	x1, y1 = math.Inf(-1), math.Inf(-1)
+
+	add := func(x, y float64) {
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			return
+		}
+
+		x0, x1 = math.Min(x0, x), math.Max(x1, x)
+		y0, y1 = math.Min(y0, y), math.Max(y1, y)
+	}
+
+	for _, s := range p.Series {
+		for i := range s.X {
+			add(s.X[i], s.Y[i])
+		}
+	}
+
+	for _, b := range p.Bars {
+		add(b.Lo, 0)
+		add(b.Hi, b.Value)
+	}
+
+	if math.IsInf(x0, 1) {
+		return 0, 0, 0, 0, false
+	}
+
+	// thresholds drawn as vertical or horizontal rules stay in view
+	for _, r := range p.Rules {
+		switch {
+		case r.Vertical:
+			x0, x1 = math.Min(x0, r.Intercept), math.Max(x1, r.Intercept)
+		case r.Slope == 0:
+			y0, y1 = math.Min(y0, r.Intercept), math.Max(y1, r.Intercept)
+		}
+	}
+
+	pad := func(lo, hi float64) (float64, float64) {
+		if lo == hi {
+			return lo - 1, hi + 1
+		}
+
+		d := (hi - lo) * 0.05
+		return lo - d, hi + d
+	}
+
+	x0, x1 = pad(x0, x1)
+
+	if len(p.Bars) > 0 {
+		// bars stand on the axis
+		_, y1 = pad(0, y1)
+		y0 = 0
+	} else {
+		y0, y1 = pad(y0, y1)
+	}
+
+	// notes and the legend are given room above the data
+	if len(p.Notes) > 0 || len(p.Series) > 1 {
+		y1 += (y1 - y0) * 0.15
+	}
+
+	return x0, x1, y0, y1, true
+}
+
+// ticks returns round values spanning lo to hi.
+func ticks(lo, hi float64, n int) []float64 {
+	span := hi - lo
+	if span <= 0 || n < 1 {
+		return nil
+	}
+
+	step := math.Pow(10, math.Floor(math.Log10(span/float64(n))))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if span/(step*m) <= float64(n) {
+			step *= m
+			break
+		}
+	}
+
+	var ts []float64
+	for t := math.Ceil(lo/step) * step; t <= hi+step*1e-9; t += step {
+		// avoid printing -0 and rounding noise
+		ts = append(ts, math.Round(t/step)*step+0)
+	}
+
+	return ts
+}
+
+// Render draws the chart.
+func (p *Plot) Render() (image.Image, error) {
+	x0, x1, y0, y1, ok := p.bounds()
+	if !ok {
+		return nil, fmt.Errorf("%s: nothing to plot", p.Title)
+	}
+
+	width, height := p.Width, p.Height
+	if width == 0 {
+		width = 640
+	}
+
+	if height == 0 {
+		height = 480
+	}
+
+	dc := gg.NewContext(width, height)
+	if p.Face != nil {
+		dc.SetFontFace(p.Face)
+	}
+
+	dc.SetColor(color.White)
+	dc.Clear()
+
+	pw := float64(width - left - right)
+	ph := float64(height - top - bottom)
+
+	px := func(x float64) float64 { return left + (x-x0)/(x1-x0)*pw }
+	py := func(y float64) float64 { return top + ph - (y-y0)/(y1-y0)*ph }
+
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored(p.Title, float64(width)/2, top/2, 0.5, 0.5)
+	dc.DrawStringAnchored(p.XLabel, left+pw/2, float64(height)-12, 0.5, 0.5)
+
+	dc.Push()
+	dc.RotateAbout(-math.Pi/2, 14, top+ph/2)
+	dc.DrawStringAnchored(p.YLabel, 14, top+ph/2, 0.5, 0.5)
+	dc.Pop()
+
+	dc.SetLineWidth(1)
+	dc.DrawRectangle(left, top, pw, ph)
+	dc.Stroke()
+
+	for _, t := range ticks(x0, x1, 6) {
+		dc.DrawLine(px(t), top+ph, px(t), top+ph+4)
+		dc.Stroke()
+		dc.DrawStringAnchored(fmt.Sprintf("%g", t), px(t), top+ph+14, 0.5, 0.5)
+	}
+
+	for _, t := range ticks(y0, y1, 6) {
+		dc.DrawLine(left-4, py(t), left, py(t))
+		dc.Stroke()
+		dc.DrawStringAnchored(fmt.Sprintf("%g", t), left-6, py(t), 1, 0.5)
+	}
+
+	// everything else is clipped to the plotting area
+	dc.DrawRectangle(left, top, pw, ph)
+	dc.Clip()
+
+	for _, b := range p.Bars {
+		dc.SetColor(Blue)
+		dc.DrawRectangle(px(b.Lo), py(b.Value), px(b.Hi)-px(b.Lo), py(0)-py(b.Value))
+		dc.FillPreserve()
+		dc.SetColor(color.White)
+		dc.Stroke()
+	}
+
+	for _, r := range p.Rules {
+		dc.SetColor(colorOr(r.Color, Grey))
+		dc.SetDash(4, 4)
+
+		if r.Vertical {
+			dc.DrawLine(px(r.Intercept), top, px(r.Intercept), top+ph)
+		} else {
+			dc.DrawLine(px(x0), py(r.Intercept+r.Slope*x0), px(x1), py(r.Intercept+r.Slope*x1))
+		}
+
+		dc.Stroke()
+		dc.SetDash()
+	}
+
+	for _, s := range p.Series {
+		dc.SetColor(colorOr(s.Color, Blue))
+
+		if s.Line {
+			dc.SetLineWidth(2)
+			started := false
+
+			for i := range s.X {
+				if math.IsNaN(s.X[i]) || math.IsNaN(s.Y[i]) {
+					continue
+				}
+
+				if started {
+					dc.LineTo(px(s.X[i]), py(s.Y[i]))
+				} else {
+					dc.MoveTo(px(s.X[i]), py(s.Y[i]))
+					started = true
+				}
+			}
+
+			dc.Stroke()
+			dc.SetLineWidth(1)
+
+			continue
+		}
+
+		for i := range s.X {
+			if math.IsNaN(s.X[i]) || math.IsNaN(s.Y[i]) {
+				continue
+			}
+
+			dc.DrawCircle(px(s.X[i]), py(s.Y[i]), radius)
+			dc.Fill()
+		}
+	}
+
+	dc.SetColor(color.Black)
+
+	for _, s := range p.Series {
+		for i, l := range s.Labels {
+			if l == "" || i >= len(s.X) || math.IsNaN(s.X[i]) || math.IsNaN(s.Y[i]) {
+				continue
+			}
+
+			// labels on the right half are written to the left of their point
+			if x := px(s.X[i]); x > left+pw/2 {
+				dc.DrawStringAnchored(l, x-radius-2, py(s.Y[i])-radius-2, 1, 0)
+			} else {
+				dc.DrawStringAnchored(l, x+radius+2, py(s.Y[i])-radius-2, 0, 0)
+			}
+		}
+	}
+
+	_, lh := dc.MeasureString("M")
+
+	// box draws a translucent background behind lines of text
+	box := func(x, w float64, n int) {
+		dc.SetColor(color.NRGBA{255, 255, 255, 200})
+		dc.DrawRectangle(x-4, top+4, w+8, float64(n)*lh*1.5+4)
+		dc.Fill()
+		dc.SetColor(color.Black)
+	}
+
+	widest := func(lines []string) float64 {
+		var w float64
+		for _, l := range lines {
+			lw, _ := dc.MeasureString(l)
+			w = math.Max(w, lw)
+		}
+
+		return w
+	}
+
+	if len(p.Notes) > 0 {
+		box(left+8, widest(p.Notes), len(p.Notes))
+	}
+
+	y := top + 8 + lh
+	for _, n := range p.Notes {
+		dc.DrawString(n, left+8, y)
+		y += lh * 1.5
+	}
+
+	var names []string
+	var colors []color.Color
+
+	for _, s := range p.Series {
+		if s.Name != "" {
+			names = append(names, s.Name)
+			colors = append(colors, colorOr(s.Color, Blue))
+		}
+	}
+
+	if len(names) > 1 {
+		w := widest(names)
+		x := left + pw - 8 - w
+		box(x-14, w+14, len(names))
+
+		y = top + 8 + lh
+		for i, n := range names {
+			dc.SetColor(colors[i])
+			dc.DrawCircle(x-8, y-lh/2, 4)
+			dc.Fill()
+
+			dc.SetColor(color.Black)
+			dc.DrawString(n, x, y)
+			y += lh * 1.5
+		}
+	}
+
+	return dc.Image(), nil
+}
+
+func colorOr(c, def color.Color) color.Color {
+	if c == nil {
+		return def
+	}
+
+	return c
+}
+
+// WritePNG renders the chart as a PNG.
+func (p *Plot) WritePNG(w io.Writer) error {
+	img, err := p.Render()
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
@@ -0,0 +1,156 @@
+package plot
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func Test_Ticks(t *testing.T) {
+	if ts := ticks(-0.3, 4.2, 5); !reflect.DeepEqual(ts, []float64{0, 1, 2, 3, 4}) {
+		t.Errorf("unexpected ticks %v", ts)
+	}
+
+	if ts := ticks(0, 0.1, 5); !reflect.DeepEqual(ts, []float64{0, 0.02, 0.04, 0.06, 0.08, 0.1}) {
+		t.Errorf("unexpected ticks %v", ts)
+	}
+}
+
+func Test_Plots(t *testing.T) {
+	g, err := gpr.Read(filepath.Join("..", "gpr", "testdata", "test1.gpr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ = g.Filter(gpr.ExcludeFlagged)
+	igg := gpr.Label{Name: "IgG", Wavelength: 550}
+	igm := gpr.Label{Name: "IgM", Wavelength: 650}
+
+	h := Histogram("test1", g, igg, 5)
+
+	var n float64
+	for _, b := range h.Bars {
+		n += b.Value
+	}
+
+	if len(h.Bars) != 5 || n != 20 {
+		t.Errorf("expected 20 spots in 5 bins, got %v", h.Bars)
+	}
+
+	c := Concordance("test1", g, igm)
+	if len(c.Series) != 1 || len(c.Series[0].X) != 10 || len(c.Notes) != 2 {
+		t.Errorf("unexpected concordance %+v", c)
+	}
+
+	s, _ := gpr.DefaultSummariser.Summarise(g)
+
+	ma, err := MA(s, s.Clone(), "a", "b", igg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range ma.Series[0].Y {
+		if m != 0 {
+			t.Errorf("expected no difference between identical arrays, got %v", m)
+		}
+	}
+
+	if _, err := MA(g, g, "a", "b", igg); err == nil {
+		t.Error("expected arrays with replicates to fail")
+	}
+
+	var buf bytes.Buffer
+	if err := Scatter("test1", g, igg, igm).WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 480 {
+		t.Errorf("unexpected size %v", b)
+	}
+
+	if _, err := Histogram("empty", g, gpr.Label{Name: "None", Wavelength: 1}, 5).Render(); err == nil {
+		t.Error("expected an empty plot to fail")
+	}
+}
+
+func Test_Volcano(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	fold := []float64{4, 0.25, 1.1, 8, 2}
+	q := []float64{0.01, 0.001, 0.001, 0, 0.2}
+
+	v := Volcano("test", ids, fold, q, 2, 0.05, 1)
+
+	other, significant := v.Series[0], v.Series[1]
+	if len(significant.X) != 3 || len(other.X) != 2 {
+		t.Fatalf("expected 3 significant proteins, got %v", significant)
+	}
+
+	// d has q of zero, drawn at the bound
+	if significant.Y[0] != 16 || !reflect.DeepEqual(significant.Labels, []string{"d", "b", ""}) {
+		t.Errorf("unexpected significant proteins %+v", significant)
+	}
+
+	for _, l := range other.Labels {
+		if l != "" {
+			t.Errorf("expected insignificant proteins to be unnamed, got %q", l)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := v.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Rank(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	values := []float64{3, math.NaN(), 7, 5}
+	errors := []float64{1, 1, 2, 3}
+
+	r := Rank("test", "value", names, values, errors, 2)
+	if !reflect.DeepEqual(r.Names, []string{"c", "d"}) || !reflect.DeepEqual(r.Errors, []float64{2, 3}) {
+		t.Errorf("unexpected ranking %+v", r)
+	}
+
+	img, err := r.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dy() != top+bottom+2*barHeight {
+		t.Errorf("unexpected size %v", b)
+	}
+
+	if _, err := Rank("empty", "value", nil, nil, nil, 2).Render(); err == nil {
+		t.Error("expected an empty ranking to fail")
+	}
+}
+
+func Test_Concordance_Order(t *testing.T) {
+	spot := func(block, column, row int, v float64) gpr.Row {
+		return gpr.Row{ID: "a", Block: block, Column: column, Row: row, Channels: []gpr.Channel{{Wavelength: 532, MedianMinusBackground: v}}}
+	}
+
+	// replicates listed out of print order, with positions that do not sort
+	// on X and Y together
+	g := &gpr.GPR{Wavelengths: []int{532}, Rows: []gpr.Row{
+		spot(2, 1, 1, 8),
+		spot(1, 2, 1, 4),
+		spot(1, 1, 2, 16),
+	}}
+
+	c := Concordance("test", g, gpr.Label{Name: "IgG", Wavelength: 532})
+	if x, y := c.Series[0].X, c.Series[0].Y; len(x) != 1 || x[0] != 2 || y[0] != 4 {
+		t.Errorf("expected the first two printed replicates, got %v %v", x, y)
+	}
+}
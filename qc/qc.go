@@ -0,0 +1,246 @@
+// Package qc judges the quality of arrays.
+package qc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/spatial"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Status is the judgement of a metric or array.
+type Status int
+
+const (
+	NotMeasured Status = iota
+	Pass
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case NotMeasured:
+		return "n/a"
+	case Pass:
+		return "pass"
+	case Warn:
+		return "warn"
+	case Fail:
+		return "fail"
+	default:
+		return ""
+	}
+}
+
+// Threshold gives the values above which a metric warns or fails.
+type Threshold struct {
+	Warn float64
+	Fail float64
+}
+
+// ParseThreshold parses a threshold given as WARN,FAIL.
+func ParseThreshold(s string) (Threshold, error) {
+	var t Threshold
+
+	if _, err := fmt.Sscanf(strings.Replace(s, ",", " ", 1), "%g %g", &t.Warn, &t.Fail); err != nil {
+		return Threshold{}, fmt.Errorf("invalid threshold %q, expected WARN,FAIL: %w", s, err)
+	}
+
+	if t.Fail > 0 && t.Warn > t.Fail {
+		return Threshold{}, fmt.Errorf("invalid threshold %q, warning above failure", s)
+	}
+
+	return t, nil
+}
+
+func (t Threshold) String() string {
+	return fmt.Sprintf("%g,%g", t.Warn, t.Fail)
+}
+
+// Judge returns the status of a value.
+func (t Threshold) Judge(v float64) Status {
+	switch {
+	case math.IsNaN(v):
+		return NotMeasured
+	case t.Fail > 0 && v > t.Fail:
+		return Fail
+	case t.Warn > 0 && v > t.Warn:
+		return Warn
+	default:
+		return Pass
+	}
+}
+
+// Thresholds are the limits applied to each metric.
+type Thresholds struct {
+	// Flagged and NotFound are percentages of spots.
+	Flagged  Threshold
+	NotFound Threshold
+
+	// CV is the median coefficient of variation of replicate spots.
+	CV Threshold
+
+	// Saturated is the mean percentage of saturated pixels in a spot.
+	Saturated Threshold
+
+	// Background is the median local background.
+	Background Threshold
+
+	// NegativeSNR is the median SNR of negative control spots.
+	NegativeSNR Threshold
+
+	// Gradient, Block and Edge are log2 fold changes of spatial patterns.
+	Gradient Threshold
+	Block    Threshold
+	Edge     Threshold
+}
+
+var DefaultThresholds = Thresholds{
+	Flagged:     Threshold{Warn: 10, Fail: 25},
+	NotFound:    Threshold{Warn: 10, Fail: 25},
+	CV:          Threshold{Warn: 0.2, Fail: 0.4},
+	Saturated:   Threshold{Warn: 1, Fail: 5},
+	Background:  Threshold{Warn: 1000, Fail: 5000},
+	NegativeSNR: Threshold{Warn: 2, Fail: 5},
+	Gradient:    Threshold{Warn: 1, Fail: 2},
+	Block:       Threshold{Warn: 1, Fail: 2},
+	Edge:        Threshold{Warn: 0.5, Fail: 1},
+}
+
+// Metric is a measured quality of an array, or of a wavelength.
+type Metric struct {
+	Name       string
+	Wavelength int
+	Value      float64
+	Status     Status
+}
+
+func (m Metric) String() string {
+	if m.Wavelength == 0 {
+		return m.Name
+	}
+
+	return fmt.Sprintf("%s %d", m.Name, m.Wavelength)
+}
+
+// Report holds the metrics of an array.
+type Report struct {
+	Array   string
+	Metrics []Metric
+	Spatial []spatial.Diagnosis
+
+	// Failed is the reason the array could not be measured.
+	Failed string
+}
+
+// Status returns the worst status of any metric.
+func (r Report) Status() Status {
+	if r.Failed != "" {
+		return Fail
+	}
+
+	s := Pass
+	for _, m := range r.Metrics {
+		if m.Status > s {
+			s = m.Status
+		}
+	}
+
+	return s
+}
+
+// Problems lists the metrics that warn or fail.
+func (r Report) Problems() []string {
+	var problems []string
+	if r.Failed != "" {
+		problems = append(problems, r.Failed)
+	}
+
+	for _, m := range r.Metrics {
+		if m.Status >= Warn {
+			problems = append(problems, fmt.Sprintf("%s %s", m, m.Status))
+		}
+	}
+
+	return problems
+}
+
+// Check measures an array read with every spot.
+func (t Thresholds) Check(name string, g *gpr.GPR) Report {
+	r := Report{Array: name}
+
+	add := func(metric string, w int, v float64, th Threshold) {
+		r.Metrics = append(r.Metrics, Metric{Name: metric, Wavelength: w, Value: v, Status: th.Judge(v)})
+	}
+
+	var flagged, notFound int
+	for _, row := range g.Rows {
+		switch {
+		case row.Flags == gpr.FlagNotFound:
+			notFound++
+		case row.Flags < 0:
+			flagged++
+		}
+	}
+
+	n := float64(len(g.Rows))
+	add("Flagged %", 0, 100*float64(flagged)/n, t.Flagged)
+	add("Not Found %", 0, 100*float64(notFound)/n, t.NotFound)
+
+	// spatial patterns are measured on the spots found by GenePix
+	unflagged, _ := g.Filter(gpr.ExcludeFlagged)
+
+	for _, w := range g.Wavelengths {
+		var saturated, background, negative []float64
+		replicates := map[string][]float64{}
+
+		for _, row := range g.Rows {
+			if row.Control == gpr.Excluded {
+				continue
+			}
+
+			c := row.Channel(w)
+			saturated = append(saturated, c.PercentSaturated)
+			background = append(background, c.BackgroundMedian)
+
+			switch row.Control {
+			case gpr.NegativeControl:
+				negative = append(negative, c.SNR)
+			case gpr.Sample:
+				if row.Flags >= 0 {
+					replicates[row.ID] = append(replicates[row.ID], c.MedianMinusBackground)
+				}
+			}
+		}
+
+		var cvs []float64
+		for _, xs := range replicates {
+			if len(stats.Values(xs)) > 1 {
+				cvs = append(cvs, math.Abs(stats.CV(xs)))
+			}
+		}
+
+		add("Replicate CV", w, stats.Median(cvs), t.CV)
+		add("Saturated %", w, stats.Mean(saturated), t.Saturated)
+		add("Background", w, stats.Median(background), t.Background)
+		add("Negative SNR", w, stats.Median(negative), t.NegativeSNR)
+
+		d := spatial.Diagnose(unflagged, w)
+		r.Spatial = append(r.Spatial, d)
+
+		worst := math.NaN()
+		if b, ok := d.WorstBlock(); ok {
+			worst = math.Abs(b.LogRatio)
+		}
+
+		add("Gradient", w, d.Gradient, t.Gradient)
+		add("Block Effect", w, worst, t.Block)
+		add("Edge Effect", w, math.Abs(d.Edge), t.Edge)
+	}
+
+	return r
+}
@@ -0,0 +1,96 @@
+package qc
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+func Test_Check(t *testing.T) {
+	rules, err := gpr.ReadRules(filepath.Join("..", "gpr", "testdata", "controls.rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gpr.Read(filepath.Join("..", "gpr", "testdata", "test1.gpr"), gpr.WithRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := DefaultThresholds.Check("test1", g)
+
+	if len(r.Metrics) != 16 || len(r.Spatial) != 2 {
+		t.Fatalf("expected 16 metrics, got %d", len(r.Metrics))
+	}
+
+	metric := func(name string, w int) Metric {
+		for _, m := range r.Metrics {
+			if m.Name == name && m.Wavelength == w {
+				return m
+			}
+		}
+
+		t.Fatalf("missing metric %s %d", name, w)
+		return Metric{}
+	}
+
+	if m := metric("Flagged %", 0); math.Abs(m.Value-100.0/24) > 1e-9 || m.Status != Pass {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	if m := metric("Not Found %", 0); math.Abs(m.Value-200.0/24) > 1e-9 || m.Status != Pass {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	if m := metric("Background", 550); m.Value != 73.5 || m.Status != Pass {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	if m := metric("Negative SNR", 650); m.Status != Fail || m.String() != "Negative SNR 650" {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	if r.Status() != Fail || len(r.Problems()) != 8 {
+		t.Errorf("unexpected problems %v", r.Problems())
+	}
+
+	for i := range g.Rows {
+		g.Rows[i].Control = gpr.Sample
+	}
+
+	if m := (Thresholds{}).Check("test1", g).Metrics[5]; m.Status != NotMeasured {
+		t.Errorf("expected no negative controls to be measured: %+v", m)
+	}
+}
+
+func Test_Report_Failed(t *testing.T) {
+	r := Report{Array: "test1", Failed: "could not read"}
+	if r.Status() != Fail || len(r.Problems()) != 1 || r.Problems()[0] != "could not read" {
+		t.Errorf("unexpected failed report %v %v", r.Status(), r.Problems())
+	}
+}
+
+func Test_Threshold(t *testing.T) {
+	th, err := ParseThreshold("10,25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for v, want := range map[float64]Status{5: Pass, 10: Pass, 11: Warn, 26: Fail, math.NaN(): NotMeasured} {
+		if s := th.Judge(v); s != want {
+			t.Errorf("%v: expected %s, got %s", v, want, s)
+		}
+	}
+
+	if s := (Threshold{Fail: 5}).Judge(4); s != Pass {
+		t.Errorf("expected pass without a warning, got %s", s)
+	}
+
+	for _, s := range []string{"10", "a,b", "25,10"} {
+		if _, err := ParseThreshold(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
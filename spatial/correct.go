@@ -0,0 +1,288 @@
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Correction removes spatial patterns from a channel of an array in place,
+// keeping its median.
+type Correction interface {
+	Correct(g *gpr.GPR, wavelength int)
+	String() string
+}
+
+// Correct returns a copy of g with every channel corrected.
+func Correct(g *gpr.GPR, c Correction) *gpr.GPR {
+	out := g.Clone()
+	for _, w := range out.Wavelengths {
+		c.Correct(out, w)
+	}
+
+	return out
+}
+
+// None leaves the array unchanged.
+type None struct{}
+
+func (None) Correct(g *gpr.GPR, wavelength int) {}
+
+func (None) String() string {
+	return "none"
+}
+
+// BlockMedian scales each block so its median matches the array.
+type BlockMedian struct{}
+
+func (BlockMedian) Correct(g *gpr.GPR, wavelength int) {
+	ss := spots(g, wavelength)
+	if len(ss) == 0 {
+		return
+	}
+
+	median := stats.Median(logs(ss))
+
+	byBlock := map[int][]float64{}
+	for _, s := range ss {
+		b := g.Rows[s.row].Block
+		byBlock[b] = append(byBlock[b], s.z)
+	}
+
+	factors := map[int]float64{}
+	for b, zs := range byBlock {
+		factors[b] = math.Exp2(median - stats.Median(zs))
+	}
+
+	for i := range g.Rows {
+		if f, ok := factors[g.Rows[i].Block]; ok {
+			scale(&g.Rows[i], wavelength, f)
+		}
+	}
+}
+
+func (BlockMedian) String() string {
+	return "block median"
+}
+
+// Loess removes smooth trends by fitting a local plane to the Span nearest
+// sample spots, on a grid for large arrays.
+type Loess struct {
+	Span float64
+}
+
+const gridSize = 32
+
+func (l Loess) Correct(g *gpr.GPR, wavelength int) {
+	ss := spots(g, wavelength)
+	if len(ss) < 3 {
+		return
+	}
+
+	k := int(math.Ceil(l.Span * float64(len(ss))))
+	if k < 3 {
+		k = 3
+	}
+
+	if k > len(ss) {
+		k = len(ss)
+	}
+
+	n := newNeighbourhood(ss, k)
+
+	var fit []float64
+	if len(g.Rows) > gridSize*gridSize {
+		fit = n.grid(g.Rows, gridSize)
+	} else {
+		fit = n.each(g.Rows)
+	}
+
+	var fitted []float64
+	for _, s := range ss {
+		fitted = append(fitted, fit[s.row])
+	}
+
+	median := stats.Median(fitted)
+
+	for i := range g.Rows {
+		if !math.IsNaN(fit[i]) {
+			scale(&g.Rows[i], wavelength, math.Exp2(median-fit[i]))
+		}
+	}
+}
+
+// neighbourhood fits local planes to the k nearest of a set of spots.
+type neighbourhood struct {
+	ss   []spot
+	k    int
+	dist []float64
+	work []float64
+	w    []float64
+}
+
+func newNeighbourhood(ss []spot, k int) *neighbourhood {
+	return &neighbourhood{
+		ss:   ss,
+		k:    k,
+		dist: make([]float64, len(ss)),
+		work: make([]float64, len(ss)),
+		w:    make([]float64, len(ss)),
+	}
+}
+
+// at returns the fitted log intensity at x, y, NaN if no plane fits.
+func (n *neighbourhood) at(x, y float64) float64 {
+	for j, s := range n.ss {
+		n.dist[j] = math.Hypot(s.x-x, s.y-y)
+	}
+
+	copy(n.work, n.dist)
+
+	// widened so the kth point keeps some weight
+	d := smallest(n.work, n.k) * 1.0001
+	if d == 0 {
+		d = 1
+	}
+
+	for j := range n.ss {
+		u := n.dist[j] / d
+		if u >= 1 {
+			n.w[j] = 0
+		} else {
+			n.w[j] = math.Pow(1-u*u*u, 3)
+		}
+	}
+
+	a, _, _, ok := weightedPlane(n.ss, n.w, x, y)
+	if !ok {
+		return math.NaN()
+	}
+
+	return a
+}
+
+// each fits every row at its own position.
+func (n *neighbourhood) each(rows []gpr.Row) []float64 {
+	fit := make([]float64, len(rows))
+	for i, r := range rows {
+		fit[i] = n.at(float64(r.X), float64(r.Y))
+	}
+
+	return fit
+}
+
+// grid fits a size by size grid and interpolates every row from it.
+func (n *neighbourhood) grid(rows []gpr.Row, size int) []float64 {
+	x0, y0 := math.Inf(1), math.Inf(1)
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+
+	for _, r := range rows {
+		x0, x1 = math.Min(x0, float64(r.X)), math.Max(x1, float64(r.X))
+		y0, y1 = math.Min(y0, float64(r.Y)), math.Max(y1, float64(r.Y))
+	}
+
+	if x0 == x1 || y0 == y1 {
+		return n.each(rows)
+	}
+
+	dx, dy := (x1-x0)/float64(size-1), (y1-y0)/float64(size-1)
+
+	nodes := make([][]float64, size)
+	for i := range nodes {
+		nodes[i] = make([]float64, size)
+		for j := range nodes[i] {
+			nodes[i][j] = n.at(x0+float64(i)*dx, y0+float64(j)*dy)
+		}
+	}
+
+	// cell returns the grid cell holding v and the position within it
+	cell := func(v float64) (int, float64) {
+		i := int(v)
+		if i > size-2 {
+			i = size - 2
+		}
+
+		return i, v - float64(i)
+	}
+
+	fit := make([]float64, len(rows))
+	for k, r := range rows {
+		i, u := cell((float64(r.X) - x0) / dx)
+		j, v := cell((float64(r.Y) - y0) / dy)
+
+		fit[k] = (1-u)*(1-v)*nodes[i][j] + u*(1-v)*nodes[i+1][j] + (1-u)*v*nodes[i][j+1] + u*v*nodes[i+1][j+1]
+	}
+
+	return fit
+}
+
+// smallest returns the kth smallest of xs from one, reordering xs.
+func smallest(xs []float64, k int) float64 {
+	k--
+	lo, hi := 0, len(xs)-1
+
+	for lo < hi {
+		pivot := xs[(lo+hi)/2]
+		i, j := lo, hi
+
+		for i <= j {
+			for xs[i] < pivot {
+				i++
+			}
+
+			for xs[j] > pivot {
+				j--
+			}
+
+			if i <= j {
+				xs[i], xs[j] = xs[j], xs[i]
+				i++
+				j--
+			}
+		}
+
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return xs[k]
+		}
+	}
+
+	return xs[k]
+}
+
+func (l Loess) String() string {
+	return fmt.Sprintf("spatial loess (span %g)", l.Span)
+}
+
+func scale(r *gpr.Row, wavelength int, f float64) {
+	for i := range r.Channels {
+		if c := &r.Channels[i]; c.Wavelength == wavelength {
+			c.MedianMinusBackground *= f
+			c.MeanMinusBackground *= f
+		}
+	}
+}
+
+// Corrections lists the names accepted by Parse.
+var Corrections = []string{"none", "block", "loess"}
+
+// Parse returns the correction with the given name.
+func Parse(name string) (Correction, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return None{}, nil
+	case "block":
+		return BlockMedian{}, nil
+	case "loess":
+		return Loess{Span: 0.2}, nil
+	default:
+		return nil, fmt.Errorf("unknown spatial correction %q", name)
+	}
+}
@@ -0,0 +1,265 @@
+// Package spatial finds and corrects intensity patterns across arrays.
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+	"gitlab.node-3.net/nadams/gpr/stats"
+)
+
+// Limits are the log2 fold changes above which a pattern is reported.
+type Limits struct {
+	Gradient float64
+	Block    float64
+	Edge     float64
+}
+
+var DefaultLimits = Limits{Gradient: 1, Block: 1, Edge: 0.5}
+
+// BlockEffect is the difference between a block and the array.
+type BlockEffect struct {
+	Block    int
+	N        int
+	Median   float64
+	LogRatio float64
+}
+
+// Diagnosis describes the spatial patterns of a channel of an array.
+type Diagnosis struct {
+	Wavelength int
+
+	// Gradient is the log2 fold change across the fitted plane, GradientR2
+	// the variance it explains.
+	Gradient   float64
+	GradientR2 float64
+
+	Blocks []BlockEffect
+
+	// Edge is the log2 ratio of the edge spots of blocks to the rest.
+	Edge float64
+}
+
+// WorstBlock returns the block furthest from the array median.
+func (d Diagnosis) WorstBlock() (BlockEffect, bool) {
+	var worst BlockEffect
+	var found bool
+
+	for _, b := range d.Blocks {
+		if !found || math.Abs(b.LogRatio) > math.Abs(worst.LogRatio) {
+			worst, found = b, true
+		}
+	}
+
+	return worst, found
+}
+
+// Problems describes the patterns beyond the limits.
+func (d Diagnosis) Problems(l Limits) []string {
+	var problems []string
+
+	if l.Gradient > 0 && d.Gradient > l.Gradient {
+		problems = append(problems, fmt.Sprintf("%d gradient of %.2f log2 across the array", d.Wavelength, d.Gradient))
+	}
+
+	for _, b := range d.Blocks {
+		if l.Block > 0 && math.Abs(b.LogRatio) > l.Block {
+			dir := "brighter"
+			if b.LogRatio < 0 {
+				dir = "dimmer"
+			}
+
+			problems = append(problems, fmt.Sprintf("%d block %d %.2f log2 %s", d.Wavelength, b.Block, math.Abs(b.LogRatio), dir))
+		}
+	}
+
+	if l.Edge > 0 && math.Abs(d.Edge) > l.Edge {
+		problems = append(problems, fmt.Sprintf("%d edge effect of %.2f log2", d.Wavelength, d.Edge))
+	}
+
+	return problems
+}
+
+// spot is the position and log intensity of a sample spot.
+type spot struct {
+	row  int
+	x, y float64
+	z    float64
+}
+
+func spots(g *gpr.GPR, w int) []spot {
+	var ss []spot
+
+	for i, r := range g.Rows {
+		if r.Control != gpr.Sample {
+			continue
+		}
+
+		if v := r.Channel(w).MedianMinusBackground; v > 0 {
+			ss = append(ss, spot{row: i, x: float64(r.X), y: float64(r.Y), z: math.Log2(v)})
+		}
+	}
+
+	return ss
+}
+
+func logs(ss []spot) []float64 {
+	zs := make([]float64, len(ss))
+	for i, s := range ss {
+		zs[i] = s.z
+	}
+
+	return zs
+}
+
+// Diagnose measures the spatial patterns of a channel of g.
+func Diagnose(g *gpr.GPR, w int) Diagnosis {
+	d := Diagnosis{Wavelength: w, Gradient: math.NaN(), GradientR2: math.NaN(), Edge: math.NaN()}
+
+	ss := spots(g, w)
+	if len(ss) == 0 {
+		return d
+	}
+
+	median := stats.Median(logs(ss))
+
+	if a, b, c, ok := plane(ss); ok {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		mean := stats.Mean(logs(ss))
+
+		var res, tot float64
+
+		for _, s := range ss {
+			f := a + b*s.x + c*s.y
+			lo, hi = math.Min(lo, f), math.Max(hi, f)
+			res += (s.z - f) * (s.z - f)
+			tot += (s.z - mean) * (s.z - mean)
+		}
+
+		d.Gradient = hi - lo
+		if tot > 0 {
+			d.GradientR2 = 1 - res/tot
+		}
+	}
+
+	byBlock := map[int][]float64{}
+	for _, s := range ss {
+		b := g.Rows[s.row].Block
+		byBlock[b] = append(byBlock[b], s.z)
+	}
+
+	for _, b := range sortedBlocks(byBlock) {
+		m := stats.Median(byBlock[b])
+		d.Blocks = append(d.Blocks, BlockEffect{Block: b, N: len(byBlock[b]), Median: math.Exp2(m), LogRatio: m - median})
+	}
+
+	edges := edges(g)
+
+	var edge, inside []float64
+	for _, s := range ss {
+		if edges[s.row] {
+			edge = append(edge, s.z)
+		} else {
+			inside = append(inside, s.z)
+		}
+	}
+
+	d.Edge = stats.Median(edge) - stats.Median(inside)
+
+	return d
+}
+
+func sortedBlocks(m map[int][]float64) []int {
+	blocks := make([]int, 0, len(m))
+	for b := range m {
+		blocks = append(blocks, b)
+	}
+
+	sort.Ints(blocks)
+
+	return blocks
+}
+
+// edges reports whether each row of g is on the edge of its block.
+func edges(g *gpr.GPR) []bool {
+	type extent struct{ cols, rows int }
+
+	ext := map[int]extent{}
+	for _, r := range g.Rows {
+		e := ext[r.Block]
+		if r.Column > e.cols {
+			e.cols = r.Column
+		}
+
+		if r.Row > e.rows {
+			e.rows = r.Row
+		}
+
+		ext[r.Block] = e
+	}
+
+	out := make([]bool, len(g.Rows))
+	for i, r := range g.Rows {
+		e := ext[r.Block]
+		out[i] = r.Column == 1 || r.Row == 1 || r.Column == e.cols || r.Row == e.rows
+	}
+
+	return out
+}
+
+// plane fits z = a + b·x + c·y to the spots by least squares.
+func plane(ss []spot) (a, b, c float64, ok bool) {
+	w := make([]float64, len(ss))
+	var x0, y0 float64
+
+	for i, s := range ss {
+		w[i] = 1
+		x0 += s.x / float64(len(ss))
+		y0 += s.y / float64(len(ss))
+	}
+
+	a, b, c, ok = weightedPlane(ss, w, x0, y0)
+
+	return a - b*x0 - c*y0, b, c, ok
+}
+
+// weightedPlane returns the weighted least squares plane at x0, y0, or the
+// weighted mean.
+func weightedPlane(ss []spot, w []float64, x0, y0 float64) (a, b, c float64, ok bool) {
+	var sw, sx, sy, sz, sxx, sxy, syy, sxz, syz float64
+
+	for i, s := range ss {
+		if w[i] == 0 {
+			continue
+		}
+
+		x, y := s.x-x0, s.y-y0
+		sw += w[i]
+		sx += w[i] * x
+		sy += w[i] * y
+		sz += w[i] * s.z
+		sxx += w[i] * x * x
+		sxy += w[i] * x * y
+		syy += w[i] * y * y
+		sxz += w[i] * x * s.z
+		syz += w[i] * y * s.z
+	}
+
+	if sw == 0 {
+		return 0, 0, 0, false
+	}
+
+	// solve the normal equations by Cramer's rule
+	det := sw*(sxx*syy-sxy*sxy) - sx*(sx*syy-sxy*sy) + sy*(sx*sxy-sxx*sy)
+	if math.Abs(det) <= 1e-12*sw*sxx*syy {
+		return sz / sw, 0, 0, true
+	}
+
+	a = (sz*(sxx*syy-sxy*sxy) - sx*(sxz*syy-sxy*syz) + sy*(sxz*sxy-sxx*syz)) / det
+	b = (sw*(sxz*syy-syz*sxy) - sz*(sx*syy-sxy*sy) + sy*(sx*syz-sxz*sy)) / det
+	c = (sw*(sxx*syz-sxy*sxz) - sx*(sx*syz-sxz*sy) + sz*(sx*sxy-sxx*sy)) / det
+
+	return a, b, c, true
+}
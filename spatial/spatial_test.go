@@ -0,0 +1,154 @@
+package spatial
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gitlab.node-3.net/nadams/gpr/gpr"
+)
+
+// array returns four 5×5 blocks of spots laid out 2×2 on the slide, with
+// intensities given by f.
+func array(f func(block, column, row, x, y int) float64) *gpr.GPR {
+	g := &gpr.GPR{Wavelengths: []int{635}}
+
+	for b := 0; b < 4; b++ {
+		for c := 1; c <= 5; c++ {
+			for r := 1; r <= 5; r++ {
+				x, y := (b%2)*2000+c*200, (b/2)*2000+r*200
+				v := f(b+1, c, r, x, y)
+				g.Rows = append(g.Rows, gpr.Row{
+					Block: b + 1, Column: c, Row: r, X: x, Y: y,
+					Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: v, MeanMinusBackground: v}},
+				})
+			}
+		}
+	}
+
+	return g
+}
+
+func Test_Diagnose_Flat(t *testing.T) {
+	d := Diagnose(array(func(b, c, r, x, y int) float64 { return 1000 }), 635)
+
+	if d.Gradient > 1e-9 || d.Edge != 0 || len(d.Blocks) != 4 || d.Blocks[0].Median != 1000 {
+		t.Errorf("unexpected diagnosis %+v", d)
+	}
+
+	if p := d.Problems(DefaultLimits); len(p) != 0 {
+		t.Errorf("unexpected problems %v", p)
+	}
+}
+
+func Test_Gradient(t *testing.T) {
+	g := array(func(b, c, r, x, y int) float64 { return 1000 * math.Exp2(float64(x)/1000) })
+
+	d := Diagnose(g, 635)
+	if math.Abs(d.Gradient-2.8) > 1e-9 || math.Abs(d.GradientR2-1) > 1e-9 {
+		t.Errorf("unexpected gradient %v (R² %v)", d.Gradient, d.GradientR2)
+	}
+
+	if p := d.Problems(DefaultLimits); len(p) == 0 || !strings.Contains(p[0], "gradient") {
+		t.Errorf("expected a gradient problem, got %v", p)
+	}
+
+	corrected := Correct(g, Loess{Span: 0.2})
+	if d := Diagnose(corrected, 635); d.Gradient > 0.2 {
+		t.Errorf("expected the gradient to be removed, got %v", d.Gradient)
+	}
+
+	if g.Rows[0].Channels[0].MedianMinusBackground != 1000*math.Exp2(0.2) {
+		t.Error("correct should not modify the original")
+	}
+}
+
+func Test_Loess_Grid(t *testing.T) {
+	g := &gpr.GPR{Wavelengths: []int{635}}
+
+	for c := 0; c < 50; c++ {
+		for r := 0; r < 50; r++ {
+			x, y := c*150, r*150
+			v := 1000 * math.Exp2(float64(x)/3000+math.Sin(float64(y)/2000))
+			g.Rows = append(g.Rows, gpr.Row{
+				Block: 1, Column: c + 1, Row: r + 1, X: x, Y: y,
+				Channels: []gpr.Channel{{Wavelength: 635, MedianMinusBackground: v, MeanMinusBackground: v}},
+			})
+		}
+	}
+
+	n := newNeighbourhood(spots(g, 635), 250)
+
+	each, grid := n.each(g.Rows), n.grid(g.Rows, gridSize)
+	for i := range each {
+		if math.Abs(each[i]-grid[i]) > 0.01 {
+			t.Fatalf("spot %d: expected the grid fit %v to match %v", i, grid[i], each[i])
+		}
+	}
+
+	if d := Diagnose(Correct(g, Loess{Span: 0.1}), 635); d.Gradient > 0.2 {
+		t.Errorf("expected the gradient to be removed, got %v", d.Gradient)
+	}
+}
+
+func Test_Smallest(t *testing.T) {
+	xs := []float64{5, 1, 4, 1, 3, 9, 2, 6}
+	for k, expected := range []float64{1, 1, 2, 3, 4, 5, 6, 9} {
+		if v := smallest(append([]float64(nil), xs...), k+1); v != expected {
+			t.Errorf("expected %v at %d, got %v", expected, k+1, v)
+		}
+	}
+}
+
+func Test_Block(t *testing.T) {
+	g := array(func(b, c, r, x, y int) float64 {
+		if b == 3 {
+			return 250
+		}
+
+		return 1000
+	})
+
+	d := Diagnose(g, 635)
+
+	worst, ok := d.WorstBlock()
+	if !ok || worst.Block != 3 || worst.LogRatio != -2 || worst.N != 25 {
+		t.Errorf("unexpected worst block %+v", worst)
+	}
+
+	// a block in a corner also tilts the fitted plane
+	if p := d.Problems(DefaultLimits); len(p) != 2 || p[1] != "635 block 3 2.00 log2 dimmer" {
+		t.Errorf("unexpected problems %v", p)
+	}
+
+	corrected := Correct(g, BlockMedian{})
+	if worst, _ := Diagnose(corrected, 635).WorstBlock(); worst.LogRatio != 0 {
+		t.Errorf("expected the block to be corrected: %+v", worst)
+	}
+}
+
+func Test_Edge(t *testing.T) {
+	g := array(func(b, c, r, x, y int) float64 {
+		if c == 1 || c == 5 || r == 1 || r == 5 {
+			return 2000
+		}
+
+		return 1000
+	})
+
+	if d := Diagnose(g, 635); d.Edge != 1 || len(d.Problems(DefaultLimits)) != 1 {
+		t.Errorf("unexpected edge effect %v", d.Edge)
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	for _, name := range Corrections {
+		if _, err := Parse(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := Parse("other"); err == nil {
+		t.Error("expected an error for an unknown correction")
+	}
+}
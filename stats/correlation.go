@@ -0,0 +1,55 @@
+package stats
+
+import "math"
+
+// pairs returns the values of x and y where neither is NaN.
+func pairs(x, y []float64) ([]float64, []float64) {
+	var xs, ys []float64
+
+	for i := range x {
+		if i >= len(y) || math.IsNaN(x[i]) || math.IsNaN(y[i]) {
+			continue
+		}
+
+		xs = append(xs, x[i])
+		ys = append(ys, y[i])
+	}
+
+	return xs, ys
+}
+
+// Pearson returns the correlation coefficient of the pairs of x and y,
+// ignoring pairs with a missing value.
+func Pearson(x, y []float64) float64 {
+	xs, ys := pairs(x, y)
+	if len(xs) < 2 {
+		return math.NaN()
+	}
+
+	mx, my := Mean(xs), Mean(ys)
+
+	var sxy, sxx, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// Spearman returns the rank correlation coefficient of the pairs of x and
+// y, ignoring pairs with a missing value.
+func Spearman(x, y []float64) float64 {
+	xs, ys := pairs(x, y)
+
+	rx, _ := rank(xs)
+	ry, _ := rank(ys)
+
+	return Pearson(rx, ry)
+}
@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Lowess returns the robust locally weighted regression of y on x at each x,
+// fitting span of the points around each.
+func Lowess(x, y []float64, span float64, iterations int) []float64 {
+	n := len(x)
+	fit := make([]float64, n)
+	if n == 0 {
+		return fit
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return x[order[i]] < x[order[j]]
+	})
+
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	for i, o := range order {
+		xs[i], ys[i] = x[o], y[o]
+	}
+
+	k := int(math.Ceil(span * float64(n)))
+	if k < 2 {
+		k = 2
+	}
+	if k > n {
+		k = n
+	}
+
+	robust := make([]float64, n)
+	for i := range robust {
+		robust[i] = 1
+	}
+
+	sorted := make([]float64, n)
+
+	for it := 0; it <= iterations; it++ {
+		lo := 0
+
+		for i := 0; i < n; i++ {
+			for lo+k < n && xs[i]-xs[lo] > xs[lo+k]-xs[i] {
+				lo++
+			}
+
+			hi := lo + k - 1
+
+			sorted[i] = localFit(xs, ys, robust, lo, hi, i)
+			if math.IsNaN(sorted[i]) {
+				sorted[i] = localFit(xs, ys, nil, lo, hi, i)
+			}
+		}
+
+		if it == iterations {
+			break
+		}
+
+		var scale float64
+		residuals := make([]float64, n)
+
+		for i := range residuals {
+			residuals[i] = math.Abs(ys[i] - sorted[i])
+			scale += math.Abs(ys[i])
+		}
+
+		s := 6 * Median(residuals)
+		if s <= 1e-7*scale/float64(n) {
+			break
+		}
+
+		for i, r := range residuals {
+			u := r / s
+			if u >= 1 {
+				robust[i] = 0
+			} else {
+				robust[i] = (1 - u*u) * (1 - u*u)
+			}
+		}
+	}
+
+	for i, o := range order {
+		fit[o] = sorted[i]
+	}
+
+	return fit
+}
+
+// localFit returns the tricube weighted fit at xs[i] of the points lo to hi,
+// or NaN when no point has weight.
+func localFit(xs, ys, robust []float64, lo, hi, i int) float64 {
+	d := math.Max(xs[i]-xs[lo], xs[hi]-xs[i])
+
+	var sw, swx, swy, swxx, swxy float64
+	for j := lo; j <= hi; j++ {
+		w := 1.0
+		if robust != nil {
+			w = robust[j]
+		}
+
+		if d > 0 {
+			u := math.Abs(xs[j]-xs[i]) / d
+			if u >= 1 {
+				continue
+			}
+
+			w *= math.Pow(1-u*u*u, 3)
+		}
+
+		sw += w
+		swx += w * xs[j]
+		swy += w * ys[j]
+		swxx += w * xs[j] * xs[j]
+		swxy += w * xs[j] * ys[j]
+	}
+
+	switch {
+	case sw == 0:
+		return math.NaN()
+	case swxx*sw-swx*swx > 1e-12*sw*sw:
+		b := (swxy*sw - swx*swy) / (swxx*sw - swx*swx)
+		return swy/sw + b*(xs[i]-swx/sw)
+	default:
+		return swy / sw
+	}
+}
@@ -0,0 +1,145 @@
+// Package stats provides the descriptive statistics used to summarise and
+// compare spots. NaN values are treated as missing and ignored.
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Values returns xs without NaN values, sorted in ascending order.
+func Values(xs []float64) []float64 {
+	vs := make([]float64, 0, len(xs))
+	for _, x := range xs {
+		if !math.IsNaN(x) {
+			vs = append(vs, x)
+		}
+	}
+
+	sort.Float64s(vs)
+
+	return vs
+}
+
+func Mean(xs []float64) float64 {
+	var sum float64
+	var n int
+
+	for _, x := range xs {
+		if math.IsNaN(x) {
+			continue
+		}
+
+		sum += x
+		n++
+	}
+
+	if n == 0 {
+		return math.NaN()
+	}
+
+	return sum / float64(n)
+}
+
+func Median(xs []float64) float64 {
+	return Quantile(xs, 0.5)
+}
+
+// Quantile returns the q-th quantile of xs, interpolating linearly between
+// the closest ranks.
+func Quantile(xs []float64, q float64) float64 {
+	vs := Values(xs)
+	if len(vs) == 0 {
+		return math.NaN()
+	}
+
+	pos := q * float64(len(vs)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+
+	return vs[lo] + (vs[hi]-vs[lo])*(pos-float64(lo))
+}
+
+// TrimmedMean returns the mean of xs after dropping the given fraction of
+// values from each end.
+func TrimmedMean(xs []float64, trim float64) float64 {
+	vs := Values(xs)
+	k := int(trim * float64(len(vs)))
+
+	if 2*k >= len(vs) {
+		return Median(vs)
+	}
+
+	return Mean(vs[k : len(vs)-k])
+}
+
+// SD returns the sample standard deviation of xs.
+func SD(xs []float64) float64 {
+	vs := Values(xs)
+	if len(vs) < 2 {
+		return math.NaN()
+	}
+
+	m := Mean(vs)
+
+	var ss float64
+	for _, v := range vs {
+		ss += (v - m) * (v - m)
+	}
+
+	return math.Sqrt(ss / float64(len(vs)-1))
+}
+
+// CV returns the coefficient of variation of xs, the standard deviation as
+// a fraction of the mean.
+func CV(xs []float64) float64 {
+	return SD(xs) / Mean(xs)
+}
+
+// MAD returns the median absolute deviation of xs from their median.
+func MAD(xs []float64) float64 {
+	m := Median(xs)
+
+	devs := make([]float64, 0, len(xs))
+	for _, x := range xs {
+		devs = append(devs, math.Abs(x-m))
+	}
+
+	return Median(devs)
+}
+
+// Mode returns the half-sample mode of xs, a robust estimate of the
+// location of the highest density.
+func Mode(xs []float64) float64 {
+	vs := Values(xs)
+
+	for len(vs) > 3 {
+		h := (len(vs) + 1) / 2
+
+		best := 0
+		for i := 1; i+h-1 < len(vs); i++ {
+			if vs[i+h-1]-vs[i] < vs[best+h-1]-vs[best] {
+				best = i
+			}
+		}
+
+		vs = vs[best : best+h]
+	}
+
+	switch len(vs) {
+	case 0:
+		return math.NaN()
+	case 3:
+		if vs[1]-vs[0] < vs[2]-vs[1] {
+			return (vs[0] + vs[1]) / 2
+		}
+
+		if vs[1]-vs[0] > vs[2]-vs[1] {
+			return (vs[1] + vs[2]) / 2
+		}
+
+		return vs[1]
+	default:
+		return Mean(vs)
+	}
+}
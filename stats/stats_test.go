@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func Test_Descriptive(t *testing.T) {
+	xs := []float64{4, 1, math.NaN(), 3, 2, 100}
+
+	if m := Mean(xs); !near(m, 22) {
+		t.Errorf("expected mean 22, got %v", m)
+	}
+
+	if m := Median(xs); m != 3 {
+		t.Errorf("expected median 3, got %v", m)
+	}
+
+	if m := TrimmedMean(xs, 0.2); m != 3 {
+		t.Errorf("expected trimmed mean 3, got %v", m)
+	}
+
+	if q := Quantile([]float64{1, 2, 3, 4}, 0.5); q != 2.5 {
+		t.Errorf("expected quantile 2.5, got %v", q)
+	}
+
+	if sd := SD([]float64{2, 4, 4, 4, 5, 5, 7, 9}); !near(sd, 2.138089935299395) {
+		t.Errorf("unexpected sd %v", sd)
+	}
+
+	if cv := CV([]float64{10, 20}); !near(cv, math.Sqrt2/3) {
+		t.Errorf("unexpected cv %v", cv)
+	}
+
+	if mad := MAD([]float64{1, 1, 2, 2, 4, 6, 9}); mad != 1 {
+		t.Errorf("expected mad 1, got %v", mad)
+	}
+
+	if sd := SD([]float64{1}); !math.IsNaN(sd) {
+		t.Errorf("expected NaN sd for one value, got %v", sd)
+	}
+
+	if m := Mode([]float64{1, 2, 2.1, 2.2, 2.15, 9, 40}); m < 2.1 || m > 2.2 {
+		t.Errorf("unexpected mode %v", m)
+	}
+
+	if m := Mean(nil); !math.IsNaN(m) {
+		t.Errorf("expected NaN mean for no values, got %v", m)
+	}
+}
+
+func Test_StudentT(t *testing.T) {
+	if q := StudentTQuantile(0.975, 10); math.Abs(q-2.228138852) > 1e-6 {
+		t.Errorf("unexpected quantile %v", q)
+	}
+
+	if p := StudentTCDF(2.015048373, 5); math.Abs(p-0.95) > 1e-6 {
+		t.Errorf("unexpected cdf %v", p)
+	}
+
+	if p := StudentTCDF(0, 3); p != 0.5 {
+		t.Errorf("expected cdf 0.5, got %v", p)
+	}
+
+	if p := NormalCDF(1.959963985); math.Abs(p-0.975) > 1e-9 {
+		t.Errorf("unexpected normal cdf %v", p)
+	}
+
+	if d := NormalPDF(0); math.Abs(d-0.398942280) > 1e-9 {
+		t.Errorf("unexpected normal pdf %v", d)
+	}
+}
+
+func Test_Lowess(t *testing.T) {
+	var x, y []float64
+	for i := 0; i < 50; i++ {
+		x = append(x, float64(i))
+		y = append(y, 2*float64(i)+1+math.Sin(float64(i)))
+	}
+
+	y[25] = 500
+
+	fit := Lowess(x, y, 0.5, 3)
+	for i := range x {
+		if math.Abs(fit[i]-(2*x[i]+1)) > 1 {
+			t.Errorf("unexpected fit at %v: %v", x[i], fit[i])
+		}
+	}
+}
+
+func Test_Correlation(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5, math.NaN()}
+	y := []float64{2, 4, 5, 4, 25, 7}
+
+	if r := Pearson(x, y); !within(r, 0.7603559, 1e-6) {
+		t.Errorf("unexpected pearson %v", r)
+	}
+
+	if r := Spearman(x, y); !within(r, 0.8207827, 1e-6) {
+		t.Errorf("unexpected spearman %v", r)
+	}
+
+	if r := Pearson([]float64{1, 1, 1}, []float64{1, 2, 3}); !math.IsNaN(r) {
+		t.Errorf("expected no correlation of a constant, got %v", r)
+	}
+}
@@ -0,0 +1,319 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// TestResult is the outcome of a two-sided test, P is NaN without enough values.
+type TestResult struct {
+	Statistic float64
+	DF        float64
+	P         float64
+}
+
+var untestable = TestResult{Statistic: math.NaN(), DF: math.NaN(), P: math.NaN()}
+
+// WelchT compares the means of a and b without assuming equal variances.
+func WelchT(a, b []float64) TestResult {
+	a, b = Values(a), Values(b)
+	na, nb := float64(len(a)), float64(len(b))
+
+	if na < 2 || nb < 2 {
+		return untestable
+	}
+
+	va, vb := variance(a)/na, variance(b)/nb
+	se := math.Sqrt(va + vb)
+	diff := Mean(a) - Mean(b)
+
+	if se == 0 {
+		return constant(diff)
+	}
+
+	df := (va + vb) * (va + vb) / (va*va/(na-1) + vb*vb/(nb-1))
+	t := diff / se
+
+	return TestResult{Statistic: t, DF: df, P: 2 * StudentTCDF(-math.Abs(t), df)}
+}
+
+// PairedT compares the mean difference of a[i] and b[i] against zero.
+// Pairs with a missing value are ignored.
+func PairedT(a, b []float64) TestResult {
+	d := differences(a, b)
+	n := float64(len(d))
+
+	if n < 2 {
+		return untestable
+	}
+
+	se := SD(d) / math.Sqrt(n)
+	m := Mean(d)
+
+	if se == 0 {
+		return constant(m)
+	}
+
+	t := m / se
+
+	return TestResult{Statistic: t, DF: n - 1, P: 2 * StudentTCDF(-math.Abs(t), n-1)}
+}
+
+// exactLimit is the largest sample size given exact rank test p values.
+const exactLimit = 50
+
+// MannWhitneyU compares the ranks of a and b, the statistic is the U of a.
+func MannWhitneyU(a, b []float64) TestResult {
+	a, b = Values(a), Values(b)
+	na, nb := len(a), len(b)
+
+	if na == 0 || nb == 0 {
+		return untestable
+	}
+
+	all := append(append([]float64{}, a...), b...)
+	ranks, ties := rank(all)
+
+	var ra float64
+	for _, r := range ranks[:na] {
+		ra += r
+	}
+
+	u := ra - float64(na*(na+1))/2
+	n := float64(na + nb)
+
+	if ties == 0 && na+nb <= exactLimit {
+		counts := mannWhitneyCounts(na, nb)
+		return TestResult{Statistic: u, DF: math.NaN(), P: exactP(counts, int(u))}
+	}
+
+	mu := float64(na*nb) / 2
+	sigma := math.Sqrt(float64(na*nb) / 12 * ((n + 1) - ties/(n*(n-1))))
+
+	return TestResult{Statistic: u, DF: math.NaN(), P: normalP(u, mu, sigma)}
+}
+
+// WilcoxonSignedRank compares the differences of a[i] and b[i] against zero,
+// the statistic is the sum of positive ranks.
+func WilcoxonSignedRank(a, b []float64) TestResult {
+	var d []float64
+	for _, x := range differences(a, b) {
+		if x != 0 {
+			d = append(d, x)
+		}
+	}
+
+	n := len(d)
+	if n == 0 {
+		return untestable
+	}
+
+	abs := make([]float64, n)
+	for i, x := range d {
+		abs[i] = math.Abs(x)
+	}
+
+	ranks, ties := rank(abs)
+
+	var w float64
+	for i, x := range d {
+		if x > 0 {
+			w += ranks[i]
+		}
+	}
+
+	if ties == 0 && n <= exactLimit {
+		return TestResult{Statistic: w, DF: math.NaN(), P: exactP(signedRankCounts(n), int(w))}
+	}
+
+	fn := float64(n)
+	mu := fn * (fn + 1) / 4
+	sigma := math.Sqrt(fn*(fn+1)*(2*fn+1)/24 - ties/48)
+
+	return TestResult{Statistic: w, DF: math.NaN(), P: normalP(w, mu, sigma)}
+}
+
+// Bonferroni returns the p values multiplied by the number of tests, capped
+// at 1. Missing p values are not counted.
+func Bonferroni(ps []float64) []float64 {
+	m := float64(len(Values(ps)))
+	adjusted := make([]float64, len(ps))
+
+	for i, p := range ps {
+		adjusted[i] = math.Min(1, p*m)
+	}
+
+	return adjusted
+}
+
+// BenjaminiHochberg returns the q values controlling the false discovery
+// rate of the p values. Missing p values are not counted.
+func BenjaminiHochberg(ps []float64) []float64 {
+	var order []int
+	for i, p := range ps {
+		if !math.IsNaN(p) {
+			order = append(order, i)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return ps[order[i]] < ps[order[j]]
+	})
+
+	adjusted := make([]float64, len(ps))
+	for i := range adjusted {
+		adjusted[i] = math.NaN()
+	}
+
+	m := float64(len(order))
+	q := 1.0
+
+	for k := len(order) - 1; k >= 0; k-- {
+		i := order[k]
+		q = math.Min(q, ps[i]*m/float64(k+1))
+		adjusted[i] = q
+	}
+
+	return adjusted
+}
+
+func variance(xs []float64) float64 {
+	sd := SD(xs)
+	return sd * sd
+}
+
+// constant is the result of a test where every value is identical.
+func constant(diff float64) TestResult {
+	if diff == 0 {
+		return TestResult{Statistic: 0, DF: math.NaN(), P: 1}
+	}
+
+	return TestResult{Statistic: math.Copysign(math.Inf(1), diff), DF: math.NaN(), P: 0}
+}
+
+// differences returns a[i] - b[i] for every pair without a missing value.
+func differences(a, b []float64) []float64 {
+	var d []float64
+	for i := range a {
+		if i < len(b) && !math.IsNaN(a[i]) && !math.IsNaN(b[i]) {
+			d = append(d, a[i]-b[i])
+		}
+	}
+
+	return d
+}
+
+// rank returns the ranks of xs, averaging ties, and the tie correction.
+func rank(xs []float64) ([]float64, float64) {
+	order := make([]int, len(xs))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return xs[order[i]] < xs[order[j]]
+	})
+
+	ranks := make([]float64, len(xs))
+
+	var ties float64
+
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && xs[order[j+1]] == xs[order[i]] {
+			j++
+		}
+
+		r := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			ranks[order[k]] = r
+		}
+
+		if t := float64(j - i + 1); t > 1 {
+			ties += t*t*t - t
+		}
+
+		i = j + 1
+	}
+
+	return ranks, ties
+}
+
+// normalP returns the two-sided p value of x under the normal distribution
+// with continuity correction.
+func normalP(x, mu, sigma float64) float64 {
+	if sigma == 0 {
+		return 1
+	}
+
+	z := math.Max(0, math.Abs(x-mu)-0.5) / sigma
+
+	return math.Min(1, 2*NormalCDF(-z))
+}
+
+// exactP returns the two-sided p value of x from the counts of every value
+// of a statistic.
+func exactP(counts []float64, x int) float64 {
+	var total, lower, upper float64
+	for i, c := range counts {
+		total += c
+		if i <= x {
+			lower += c
+		}
+
+		if i >= x {
+			upper += c
+		}
+	}
+
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
+
+// mannWhitneyCounts returns the number of arrangements of samples of size
+// m and n giving each value of U.
+func mannWhitneyCounts(m, n int) []float64 {
+	// only the counts for the current size of the first sample are kept
+	prev := make([][]float64, n+1)
+	for j := range prev {
+		prev[j] = []float64{1}
+	}
+
+	for i := 1; i <= m; i++ {
+		cur := make([][]float64, n+1)
+		cur[0] = []float64{1}
+
+		for j := 1; j <= n; j++ {
+			c := make([]float64, i*j+1)
+			for u := range c {
+				if u < len(cur[j-1]) {
+					c[u] += cur[j-1][u]
+				}
+
+				if u >= j && u-j < len(prev[j]) {
+					c[u] += prev[j][u-j]
+				}
+			}
+
+			cur[j] = c
+		}
+
+		prev = cur
+	}
+
+	return prev[n]
+}
+
+// signedRankCounts returns the number of sign assignments of the ranks 1 to
+// n giving each sum of positive ranks.
+func signedRankCounts(n int) []float64 {
+	counts := make([]float64, n*(n+1)/2+1)
+	counts[0] = 1
+
+	for r := 1; r <= n; r++ {
+		for s := len(counts) - 1; s >= r; s-- {
+			counts[s] += counts[s-r]
+		}
+	}
+
+	return counts
+}
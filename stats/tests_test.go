@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func within(a, b, tolerance float64) bool {
+	return math.Abs(a-b) < tolerance
+}
+
+func Test_WelchT(t *testing.T) {
+	r := WelchT([]float64{1, 2, 3, 4, math.NaN()}, []float64{2, 4, 6, 8})
+	if !within(r.Statistic, -1.7320508, 1e-6) || !within(r.DF, 4.4117647, 1e-6) || !within(r.P, 0.1515805, 1e-5) {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	if r := WelchT([]float64{1}, []float64{2, 3}); !math.IsNaN(r.P) {
+		t.Errorf("expected no p value for a single value, got %+v", r)
+	}
+
+	if r := WelchT([]float64{1, 1}, []float64{2, 2}); r.P != 0 {
+		t.Errorf("expected p 0 for constant groups, got %+v", r)
+	}
+}
+
+func Test_PairedT(t *testing.T) {
+	r := PairedT([]float64{1, 2, 3, 4, 5, 6}, []float64{1.5, 2.1, 3.9, 4.2, 6.0, math.NaN()})
+	if !within(r.Statistic, -2.9907834, 1e-6) || r.DF != 4 || !within(r.P, 0.0403069, 1e-5) {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func Test_MannWhitneyU(t *testing.T) {
+	r := MannWhitneyU([]float64{1, 2, 3}, []float64{4, 5, 6})
+	if r.Statistic != 0 || !within(r.P, 0.1, 1e-12) {
+		t.Errorf("unexpected exact result %+v", r)
+	}
+
+	r = MannWhitneyU([]float64{6, 5}, []float64{1, 2, 3, 4})
+	if r.Statistic != 8 || !within(r.P, 2.0/15, 1e-12) {
+		t.Errorf("unexpected exact result %+v", r)
+	}
+
+	r = MannWhitneyU([]float64{1, 2, 2, 3}, []float64{2, 3, 4, 5})
+	if r.Statistic != 2.5 || !within(r.P, 0.1366582, 1e-6) {
+		t.Errorf("unexpected approximate result %+v", r)
+	}
+}
+
+func Test_WilcoxonSignedRank(t *testing.T) {
+	r := WilcoxonSignedRank([]float64{2, 3, 4, 5, 6, 7}, []float64{1, 1, 1, 1, 1, 7})
+	if r.Statistic != 15 || !within(r.P, 0.0625, 1e-12) {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = WilcoxonSignedRank([]float64{1, 5, 3}, []float64{2, 3, 6})
+	if r.Statistic != 2 || !within(r.P, 0.75, 1e-12) {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func Test_Adjust(t *testing.T) {
+	ps := []float64{0.01, 0.04, math.NaN(), 0.03, 0.005}
+
+	q := BenjaminiHochberg(ps)
+	for i, want := range []float64{0.02, 0.04, math.NaN(), 0.04, 0.02} {
+		if !within(q[i], want, 1e-12) && !(math.IsNaN(want) && math.IsNaN(q[i])) {
+			t.Errorf("q %d: expected %v, got %v", i, want, q[i])
+		}
+	}
+
+	b := Bonferroni(ps)
+	if !within(b[0], 0.04, 1e-12) || !within(b[1], 0.16, 1e-12) || !math.IsNaN(b[2]) {
+		t.Errorf("unexpected bonferroni %v", b)
+	}
+}